@@ -0,0 +1,139 @@
+// Package bookmarks exports starred posts as JSON or as the Netscape bookmark
+// HTML file browsers and bookmarking services import.
+package bookmarks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pixel-87/warss/internal/storage"
+)
+
+// Bookmark is a starred post with the feed it came from
+type Bookmark struct {
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	Feed        string    `json:"feed"`
+	FeedURL     string    `json:"feed_url"`
+	Content     string    `json:"content"`
+	Authors     []string  `json:"authors,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	PublishedAt time.Time `json:"published_at"`
+	StarredAt   time.Time `json:"starred_at"`
+}
+
+// Starred collects every starred post, most recently starred first
+func Starred(ctx context.Context, db *storage.DB) ([]Bookmark, error) {
+	feeds, err := db.GetFeedsContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list feeds for export: %w", err)
+	}
+	titles := make(map[int]string, len(feeds))
+	urls := make(map[int]string, len(feeds))
+	for _, f := range feeds {
+		titles[f.ID], urls[f.ID] = f.Title, f.URL
+	}
+
+	marks := []Bookmark{}
+	var cursor storage.Cursor
+	for {
+		posts, next, err := db.StarredPosts(ctx, 200, cursor)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list starred posts for export: %w", err)
+		}
+		for _, p := range posts {
+			marks = append(marks, Bookmark{
+				Title:       p.Title,
+				URL:         p.Link,
+				Feed:        titles[p.FeedID],
+				FeedURL:     urls[p.FeedID],
+				Content:     p.Content,
+				Authors:     p.Authors,
+				Tags:        p.Tags,
+				PublishedAt: p.PublishedAt,
+				StarredAt:   p.StarredAt,
+			})
+		}
+		if next == "" {
+			return marks, nil
+		}
+		cursor = next
+	}
+}
+
+// WriteJSON writes marks as an indented JSON array
+func WriteJSON(w io.Writer, marks []Bookmark) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(marks); err != nil {
+		return fmt.Errorf("failed writing bookmarks: %w", err)
+	}
+	return nil
+}
+
+// folder is the bookmarks of one feed, the HTML export files them per feed
+type folder struct {
+	Name  string
+	Marks []Bookmark
+}
+
+var netscape = template.Must(template.New("bookmarks").Funcs(template.FuncMap{
+	"unix": func(t time.Time) int64 { return t.Unix() },
+	"tags": func(tags []string) string { return strings.Join(tags, ",") },
+}).Parse(`<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file.
+     It will be read and overwritten.
+     DO NOT EDIT! -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>{{.Title}}</TITLE>
+<H1>{{.Title}}</H1>
+<DL><p>
+{{- range .Folders}}
+    <DT><H3>{{.Name}}</H3>
+    <DL><p>
+    {{- range .Marks}}
+        <DT><A HREF="{{.URL}}" ADD_DATE="{{unix .StarredAt}}"{{with .Tags}} TAGS="{{tags .}}"{{end}}>{{.Title}}</A>
+    {{- end}}
+    </DL><p>
+{{- end}}
+</DL><p>
+`))
+
+// WriteHTML writes marks in the Netscape bookmark format with a folder per
+// feed. Posts without a link have nothing to bookmark and are left out.
+func WriteHTML(w io.Writer, title string, marks []Bookmark) error {
+	var (
+		folders []folder
+		index   = map[string]int{}
+	)
+	for _, m := range marks {
+		if m.URL == "" {
+			continue
+		}
+		name := m.Feed
+		if name == "" {
+			name = m.FeedURL
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(folders)
+			index[name] = i
+			folders = append(folders, folder{Name: name})
+		}
+		folders[i].Marks = append(folders[i].Marks, m)
+	}
+
+	data := struct {
+		Title   string
+		Folders []folder
+	}{title, folders}
+	if err := netscape.Execute(w, data); err != nil {
+		return fmt.Errorf("failed writing bookmarks: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package bookmarks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+	"github.com/pixel-87/warss/internal/storage"
+)
+
+func TestWriteHTML(t *testing.T) {
+	starred := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	marks := []Bookmark{
+		{Title: "Tips & <tricks>", URL: "https://a.example.com/1", Feed: "A", StarredAt: starred},
+		{Title: "Status", Feed: "A", StarredAt: starred},
+		{Title: "Recipe", URL: "https://b.example.com/2?x=1&y=2", FeedURL: "https://b.example.com/feed", StarredAt: starred},
+		{Title: "More tips", URL: "https://a.example.com/3", Feed: "A", Tags: []string{"go", "r&d"}, StarredAt: starred},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteHTML(&buf, "Starred", marks); err != nil {
+		t.Fatalf("WriteHTML() error = %v", err)
+	}
+	out := buf.String()
+
+	if !strings.HasPrefix(out, "<!DOCTYPE NETSCAPE-Bookmark-file-1>") {
+		t.Errorf("output doesn't start with the Netscape doctype:\n%s", out)
+	}
+	for _, want := range []string{
+		"<TITLE>Starred</TITLE>",
+		`<DT><H3>A</H3>`,
+		`<DT><A HREF="https://a.example.com/1" ADD_DATE="1714521600">Tips &amp; &lt;tricks&gt;</A>`,
+		`<DT><H3>https://b.example.com/feed</H3>`,
+		`HREF="https://b.example.com/2?x=1&amp;y=2"`,
+		`ADD_DATE="1714521600" TAGS="go,r&amp;d">More tips</A>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %s:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, "<H3>A</H3>") != 1 {
+		t.Errorf("feed A should be a single folder:\n%s", out)
+	}
+	if strings.Contains(out, "Status") {
+		t.Errorf("post without a link was exported:\n%s", out)
+	}
+}
+
+func TestStarredJSON(t *testing.T) {
+	db, err := storage.NewDB(filepath.Join(t.TempDir(), "rss.db"))
+	if err != nil {
+		t.Fatalf("failed to create test database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	ctx := context.Background()
+
+	if err := db.AddFeed("https://example.com/feed.xml", "Example"); err != nil {
+		t.Fatalf("AddFeed() error = %v", err)
+	}
+	feeds, err := db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	feed := feeds[0]
+	feed.Posts = []models.Post{
+		{GUID: "1", Title: "Keep me", Link: "https://example.com/1", Content: "<p>Worth it</p>"},
+		{GUID: "2", Title: "Skip me", Link: "https://example.com/2"},
+	}
+	if _, err := db.SaveFeed(ctx, feed); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	posts, _, err := db.ListPosts(ctx, storage.PostQuery{FeedIDs: []int{feed.ID}})
+	if err != nil {
+		t.Fatalf("ListPosts() error = %v", err)
+	}
+	for _, p := range posts {
+		if p.Title == "Keep me" {
+			if err := db.Star(ctx, p.ID); err != nil {
+				t.Fatalf("Star() error = %v", err)
+			}
+		}
+	}
+
+	marks, err := Starred(ctx, db)
+	if err != nil {
+		t.Fatalf("Starred() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, marks); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	var got []Bookmark
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not JSON: %v", err)
+	}
+	if len(got) != 1 || got[0].Title != "Keep me" || got[0].Feed != "Example" || got[0].Content != "<p>Worth it</p>" || got[0].StarredAt.IsZero() {
+		t.Errorf("exported %+v, want just the starred post with its feed", got)
+	}
+}
@@ -0,0 +1,228 @@
+// Package cli implements the warss command line: global flags and one
+// subcommand per action.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/pixel-87/warss/internal/render"
+	"github.com/pixel-87/warss/internal/storage"
+)
+
+// errUsage means the arguments were wrong, the command's usage has already been printed
+var errUsage = errors.New("usage error")
+
+// app is the state shared by every command
+type app struct {
+	db      *storage.DB
+	dbPath  string
+	format  string
+	version string
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+// command is a single `warss <name>` subcommand
+type command struct {
+	name    string
+	args    string
+	summary string
+	noDB    bool
+	run     func(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error
+	// flags registers command specific flags before parsing, may be nil
+	flags func(fs *flag.FlagSet)
+}
+
+// Run parses args (without the program name), runs the matching subcommand
+// and returns the process exit code
+func Run(ctx context.Context, args []string, version string) int {
+	return run(ctx, args, version, os.Stdout, os.Stderr)
+}
+
+func run(ctx context.Context, args []string, version string, stdout, stderr io.Writer) int {
+	a := &app{version: version, stdout: stdout, stderr: stderr}
+
+	global := flag.NewFlagSet("warss", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	global.StringVar(&a.dbPath, "db", defaultDBPath(), "path to the sqlite database")
+	global.StringVar(&a.format, "format", "text", "output format: text or json")
+	global.Usage = func() { usage(global) }
+
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if a.format != "text" && a.format != "json" {
+		_, _ = fmt.Fprintf(stderr, "warss: unknown format %q, want text or json\n", a.format)
+		return 2
+	}
+	// Plain `warss` opens the interface
+	cmdArgs := global.Args()
+	if len(cmdArgs) == 0 {
+		cmdArgs = []string{"tui"}
+	}
+
+	name := cmdArgs[0]
+	cmd, ok := commands[name]
+	if !ok {
+		_, _ = fmt.Fprintf(stderr, "warss: unknown command %q\n\n", name)
+		usage(global)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("warss "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "usage: warss %s %s\n\n%s\n", name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	if err := fs.Parse(cmdArgs[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if !cmd.noDB {
+		if err := os.MkdirAll(filepath.Dir(a.dbPath), 0o755); err != nil {
+			_, _ = fmt.Fprintf(stderr, "warss: %v\n", err)
+			return 1
+		}
+		db, err := storage.NewDBContext(ctx, a.dbPath)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "warss: database error: %v\n", err)
+			return 1
+		}
+		defer func() {
+			if err := db.Close(); err != nil {
+				_, _ = fmt.Fprintf(stderr, "warss: error closing database: %v\n", err)
+			}
+		}()
+		a.db = db
+	}
+
+	if err := cmd.run(ctx, a, fs, fs.Args()); err != nil {
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return 2
+		}
+		_, _ = fmt.Fprintf(stderr, "warss %s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+func usage(global *flag.FlagSet) {
+	w := global.Output()
+	_, _ = fmt.Fprintf(w, "usage: warss [flags] [command] [args]\n\nwithout a command warss opens the interface\n\ncommands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		_, _ = fmt.Fprintf(tw, "  %s\t%s\n", name, commands[name].summary)
+	}
+	_ = tw.Flush()
+
+	_, _ = fmt.Fprintf(w, "\nflags:\n")
+	global.PrintDefaults()
+}
+
+// legacyDBPath is where the database was kept before it moved to the XDG data
+// directory, relative to wherever warss ran
+const legacyDBPath = "rss.db"
+
+// defaultDBPath honours $WARSS_DB, then the XDG data directory. Until there's
+// a database there, a ./rss.db from before the move stays in use so its
+// subscriptions aren't left behind.
+func defaultDBPath() string {
+	if p := os.Getenv("WARSS_DB"); p != "" {
+		return p
+	}
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return legacyDBPath
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	p := filepath.Join(dir, "warss", "rss.db")
+	if _, err := os.Stat(p); errors.Is(err, fs.ErrNotExist) {
+		if _, err := os.Stat(legacyDBPath); err == nil {
+			return legacyDBPath
+		}
+	}
+	return p
+}
+
+// defaultDownloadDir is where enclosures are saved: $WARSS_DOWNLOADS, then
+// next to the default database
+func defaultDownloadDir() string {
+	if p := os.Getenv("WARSS_DOWNLOADS"); p != "" {
+		return p
+	}
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "downloads"
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "warss", "downloads")
+}
+
+// printJSON writes v as indented JSON, used by every command when --format=json
+func (a *app) printJSON(v any) error {
+	enc := json.NewEncoder(a.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// isTerminal reports whether stdout is a terminal, so styling is worth emitting
+func (a *app) isTerminal() bool {
+	return terminal(a.stdout)
+}
+
+// terminal reports whether w is a terminal
+func terminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// termWidth is the column to wrap rendered posts at, from $COLUMNS when set
+func (a *app) termWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return render.DefaultWidth
+}
+
+func (a *app) json() bool {
+	return a.format == "json"
+}
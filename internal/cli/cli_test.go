@@ -0,0 +1,804 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCLI runs warss against a database in dir and returns the exit code and output
+func runCLI(t *testing.T, dir string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-db", filepath.Join(dir, "rss.db")}, args...)
+	code := run(context.Background(), args, "1.2.3", &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	code, out, _ := runCLI(t, dir, "version")
+	if code != 0 || out != "warss 1.2.3\n" {
+		t.Errorf("version = %d %q, want 0 %q", code, out, "warss 1.2.3\n")
+	}
+
+	// version never needs the database
+	if _, err := os.Stat(filepath.Join(dir, "rss.db")); !os.IsNotExist(err) {
+		t.Errorf("version created a database: %v", err)
+	}
+}
+
+// TestDefaultDBPath checks a ./rss.db from before the XDG move keeps being
+// used until the data directory has a database of its own
+func TestDefaultDBPath(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("WARSS_DB", "")
+	t.Setenv("XDG_DATA_HOME", data)
+	t.Chdir(t.TempDir())
+	xdg := filepath.Join(data, "warss", "rss.db")
+
+	if got := defaultDBPath(); got != xdg {
+		t.Errorf("fresh install db = %q, want %q", got, xdg)
+	}
+	if err := os.WriteFile(legacyDBPath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := defaultDBPath(); got != legacyDBPath {
+		t.Errorf("with an old ./rss.db db = %q, want %q", got, legacyDBPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(xdg), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(xdg, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := defaultDBPath(); got != xdg {
+		t.Errorf("once moved db = %q, want %q", got, xdg)
+	}
+	t.Setenv("WARSS_DB", "elsewhere.db")
+	if got := defaultDBPath(); got != "elsewhere.db" {
+		t.Errorf("with $WARSS_DB db = %q, want %q", got, "elsewhere.db")
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{name: "Unknown command", args: []string{"frobnicate"}, want: 2},
+		{name: "Unknown format", args: []string{"-format", "yaml", "list"}, want: 2},
+		{name: "Add without url", args: []string{"add"}, want: 2},
+		{name: "Read with bad id", args: []string{"read", "abc"}, want: 2},
+		{name: "Mark-read with nothing", args: []string{"mark-read"}, want: 2},
+		{name: "Mark-read all and feed", args: []string{"mark-read", "-all", "-feed", "1"}, want: 2},
+		{name: "Help", args: []string{"-h"}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := runCLI(t, t.TempDir(), tt.args...)
+			if code != tt.want {
+				t.Errorf("exit code = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddListRemove(t *testing.T) {
+	dir := t.TempDir()
+
+	if code, _, errOut := runCLI(t, dir, "add", "-no-discover", "-title", "Example", "https://example.com/feed.xml"); code != 0 {
+		t.Fatalf("add failed: %s", errOut)
+	}
+	if code, _, _ := runCLI(t, dir, "add", "-no-discover", "https://example.com/feed.xml"); code != 1 {
+		t.Errorf("adding a duplicate exit code = %d, want 1", code)
+	}
+
+	code, out, errOut := runCLI(t, dir, "-format", "json", "list")
+	if code != 0 {
+		t.Fatalf("list failed: %s", errOut)
+	}
+	var feeds []feedJSON
+	if err := json.Unmarshal([]byte(out), &feeds); err != nil {
+		t.Fatalf("list output is not JSON: %v\n%s", err, out)
+	}
+	if len(feeds) != 1 || feeds[0].Title != "Example" {
+		t.Fatalf("list = %+v, want the Example feed", feeds)
+	}
+
+	if code, _, errOut := runCLI(t, dir, "remove", "https://example.com/feed.xml"); code != 0 {
+		t.Fatalf("remove failed: %s", errOut)
+	}
+	_, out, _ = runCLI(t, dir, "-format", "json", "list")
+	if strings.TrimSpace(out) != "[]" {
+		t.Errorf("list after remove = %s, want []", out)
+	}
+}
+
+func TestRefreshReadMarkRead(t *testing.T) {
+	feed := `<?xml version="1.0"?><rss version="2.0"><channel><title>Served</title>
+		<item><title>One</title><link>http://example.com/1</link><description>First</description></item>
+		<item><title>Two</title><link>http://example.com/2</link><description>Second</description></item>
+	</channel></rss>`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(feed))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if code, _, errOut := runCLI(t, dir, "add", srv.URL); code != 0 {
+		t.Fatalf("add failed: %s", errOut)
+	}
+
+	code, out, errOut := runCLI(t, dir, "refresh")
+	if code != 0 {
+		t.Fatalf("refresh failed: %s", errOut)
+	}
+	if !strings.Contains(out, "Served (2 new") {
+		t.Errorf("refresh output = %q, want 2 new posts for Served", out)
+	}
+
+	code, out, errOut = runCLI(t, dir, "read", "1")
+	if code != 0 {
+		t.Fatalf("read failed: %s", errOut)
+	}
+	if !strings.Contains(out, "One") || !strings.Contains(out, "First") {
+		t.Errorf("read output = %q, want post One", out)
+	}
+
+	_, out, _ = runCLI(t, dir, "-format", "json", "list")
+	var feeds []feedJSON
+	if err := json.Unmarshal([]byte(out), &feeds); err != nil {
+		t.Fatalf("list output is not JSON: %v", err)
+	}
+	if feeds[0].Unread != 1 {
+		t.Errorf("unread after read = %d, want 1", feeds[0].Unread)
+	}
+
+	if code, _, errOut := runCLI(t, dir, "mark-read", "-all"); code != 0 {
+		t.Fatalf("mark-read failed: %s", errOut)
+	}
+	if code, _, errOut := runCLI(t, dir, "mark-read", "-unread", "2"); code != 0 {
+		t.Fatalf("mark-read -unread failed: %s", errOut)
+	}
+	_, out, _ = runCLI(t, dir, "-format", "json", "list")
+	if err := json.Unmarshal([]byte(out), &feeds); err != nil {
+		t.Fatalf("list output is not JSON: %v", err)
+	}
+	if feeds[0].Unread != 1 {
+		t.Errorf("unread after mark-read = %d, want 1", feeds[0].Unread)
+	}
+
+	if code, _, errOut := runCLI(t, dir, "mark-read", "-feed", srv.URL); code != 0 {
+		t.Fatalf("mark-read -feed by url failed: %s", errOut)
+	}
+	_, out, _ = runCLI(t, dir, "-format", "json", "list")
+	if err := json.Unmarshal([]byte(out), &feeds); err != nil {
+		t.Fatalf("list output is not JSON: %v", err)
+	}
+	if feeds[0].Unread != 0 {
+		t.Errorf("unread after mark-read -feed = %d, want 0", feeds[0].Unread)
+	}
+	if code, out, errOut := runCLI(t, dir, "mark-read", "999"); code != 1 || !strings.Contains(errOut, "no such post") {
+		t.Errorf("mark-read of an unknown post = %d, %q, %q, want it to fail", code, out, errOut)
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.opml")
+	err := os.WriteFile(src, []byte(`<?xml version="1.0"?><opml version="2.0"><body>
+		<outline text="A" type="rss" xmlUrl="https://a.example.com/feed"/>
+		<outline text="B" type="rss" xmlUrl="https://b.example.com/feed"/>
+	</body></opml>`), 0o644)
+	if err != nil {
+		t.Fatalf("failed to write OPML: %v", err)
+	}
+
+	if code, out, errOut := runCLI(t, dir, "import", src); code != 0 || !strings.Contains(out, "imported 2 feeds") {
+		t.Fatalf("import = %d %q %q", code, out, errOut)
+	}
+
+	dst := filepath.Join(dir, "out.opml")
+	if code, _, errOut := runCLI(t, dir, "export", dst); code != 0 {
+		t.Fatalf("export failed: %s", errOut)
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+	if !strings.Contains(string(data), "https://b.example.com/feed") {
+		t.Errorf("export is missing feed B:\n%s", data)
+	}
+}
+
+func TestAddDiscovers(t *testing.T) {
+	feed := `<?xml version="1.0"?><rss version="2.0"><channel><title>Found</title></channel></rss>`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/one/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html><head><link rel="alternate" type="application/rss+xml" href="/feed.xml"></head></html>`))
+	})
+	mux.HandleFunc("/two/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html><head>
+			<link rel="alternate" type="application/rss+xml" title="Posts" href="/feed.xml">
+			<link rel="alternate" type="application/atom+xml" title="Comments" href="/comments.xml">
+		</head></html>`))
+	})
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(feed))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	code, out, errOut := runCLI(t, dir, "add", srv.URL+"/one/")
+	if code != 0 {
+		t.Fatalf("add failed: %s", errOut)
+	}
+	if !strings.Contains(out, srv.URL+"/feed.xml") {
+		t.Errorf("add output = %q, want the discovered feed url", out)
+	}
+
+	// Several feeds need a choice
+	code, out, _ = runCLI(t, dir, "add", srv.URL+"/two/")
+	if code != 1 || !strings.Contains(out, "2. Comments") {
+		t.Errorf("add with two candidates = %d %q, want 1 and a numbered list", code, out)
+	}
+	code, _, errOut = runCLI(t, dir, "add", "-pick", "2", srv.URL+"/two/")
+	if code != 0 {
+		t.Fatalf("add -pick 2 failed: %s", errOut)
+	}
+
+	_, out, _ = runCLI(t, dir, "-format", "json", "list")
+	var feeds []feedJSON
+	if err := json.Unmarshal([]byte(out), &feeds); err != nil {
+		t.Fatalf("list output is not JSON: %v", err)
+	}
+	if len(feeds) != 2 || feeds[1].Title != "Comments" || feeds[1].URL != srv.URL+"/comments.xml" {
+		t.Errorf("feeds = %+v, want the discovered feed and Comments", feeds)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	body := "First draft"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel><title>Edits</title>
+			<item><guid>post-1</guid><title>One</title><link>http://example.com/1</link><description>` + body + `</description></item>
+		</channel></rss>`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if code, _, errOut := runCLI(t, dir, "add", "-no-discover", srv.URL); code != 0 {
+		t.Fatalf("add failed: %s", errOut)
+	}
+	if code, _, errOut := runCLI(t, dir, "refresh"); code != 0 {
+		t.Fatalf("refresh failed: %s", errOut)
+	}
+	if code, _, _ := runCLI(t, dir, "diff", "1"); code == 0 {
+		t.Error("diff of an unedited post succeeded")
+	}
+	if code, _, errOut := runCLI(t, dir, "read", "1"); code != 0 {
+		t.Fatalf("read failed: %s", errOut)
+	}
+
+	body = "Second draft"
+	code, out, errOut := runCLI(t, dir, "refresh", "-force")
+	if code != 0 {
+		t.Fatalf("refresh failed: %s", errOut)
+	}
+	if !strings.Contains(out, "1 updated") {
+		t.Errorf("refresh output = %q, want 1 updated", out)
+	}
+
+	_, out, _ = runCLI(t, dir, "read", "-keep-unread", "1")
+	if !strings.Contains(out, "updated since you read it") {
+		t.Errorf("read output = %q, want the updated note", out)
+	}
+
+	code, out, errOut = runCLI(t, dir, "diff", "1")
+	if code != 0 {
+		t.Fatalf("diff failed: %s", errOut)
+	}
+	if !strings.Contains(out, "-First draft") || !strings.Contains(out, "+Second draft") {
+		t.Errorf("diff output = %q, want the changed line", out)
+	}
+
+	if code, _, _ := runCLI(t, dir, "diff", "-revision", "2", "1"); code == 0 {
+		t.Error("diff of a revision that doesn't exist succeeded")
+	}
+}
+
+func TestRetentionPrune(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel><title>Busy</title>
+			<item><guid>1</guid><title>One</title><pubDate>Mon, 01 Jan 2024 00:00:00 +0000</pubDate></item>
+			<item><guid>2</guid><title>Two</title><pubDate>Tue, 02 Jan 2024 00:00:00 +0000</pubDate></item>
+			<item><guid>3</guid><title>Three</title><pubDate>Wed, 03 Jan 2024 00:00:00 +0000</pubDate></item>
+		</channel></rss>`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if code, _, errOut := runCLI(t, dir, "add", "-no-discover", srv.URL); code != 0 {
+		t.Fatalf("add failed: %s", errOut)
+	}
+	if code, _, errOut := runCLI(t, dir, "refresh"); code != 0 {
+		t.Fatalf("refresh failed: %s", errOut)
+	}
+
+	if code, _, errOut := runCLI(t, dir, "retention", "-max-posts", "-1"); code == 0 {
+		t.Errorf("global retention accepted inherit: %s", errOut)
+	}
+	code, out, errOut := runCLI(t, dir, "retention", "-max-posts", "1")
+	if code != 0 {
+		t.Fatalf("retention failed: %s", errOut)
+	}
+	if !strings.Contains(out, "1 posts") {
+		t.Errorf("retention output = %q, want the new limit", out)
+	}
+
+	code, out, errOut = runCLI(t, dir, "prune", "-dry-run")
+	if code != 0 {
+		t.Fatalf("prune -dry-run failed: %s", errOut)
+	}
+	if !strings.Contains(out, "Busy: would remove 2 posts") {
+		t.Errorf("prune -dry-run output = %q, want 2 posts from Busy", out)
+	}
+
+	// refresh prunes on its own, and the pruned posts don't come back
+	code, out, errOut = runCLI(t, dir, "refresh", "-vacuum")
+	if code != 0 {
+		t.Fatalf("refresh failed: %s", errOut)
+	}
+	if !strings.Contains(out, "pruned 2 old posts") {
+		t.Errorf("refresh output = %q, want 2 posts pruned", out)
+	}
+	_, out, _ = runCLI(t, dir, "-format", "json", "list")
+	var feeds []feedJSON
+	if err := json.Unmarshal([]byte(out), &feeds); err != nil {
+		t.Fatalf("list output is not JSON: %v", err)
+	}
+	if feeds[0].Unread != 1 {
+		t.Errorf("unread after prune = %d, want 1", feeds[0].Unread)
+	}
+
+	// A feed can opt out of the global limit
+	if code, _, errOut := runCLI(t, dir, "retention", "-feed", "1", "-max-posts", "0"); code != 0 {
+		t.Fatalf("retention -feed failed: %s", errOut)
+	}
+	_, out, _ = runCLI(t, dir, "-format", "json", "retention", "-feed", "1")
+	var policy retentionJSON
+	if err := json.Unmarshal([]byte(out), &policy); err != nil {
+		t.Fatalf("retention output is not JSON: %v", err)
+	}
+	if policy != (retentionJSON{FeedID: 1, MaxAgeDays: -1, MaxPosts: 0, ReadMaxAgeDays: -1}) {
+		t.Errorf("feed retention = %+v, want max posts 0 and the rest inherited", policy)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel><title>Notes</title>
+			<item><guid>1</guid><title>SQLite tips</title><description>Use full text search</description><pubDate>Mon, 01 Jan 2024 00:00:00 +0000</pubDate></item>
+			<item><guid>2</guid><title>Gardening</title><description>Tomatoes again</description><pubDate>Tue, 02 Jan 2024 00:00:00 +0000</pubDate></item>
+		</channel></rss>`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if code, _, errOut := runCLI(t, dir, "add", "-no-discover", srv.URL); code != 0 {
+		t.Fatalf("add failed: %s", errOut)
+	}
+	if code, _, errOut := runCLI(t, dir, "refresh"); code != 0 {
+		t.Fatalf("refresh failed: %s", errOut)
+	}
+
+	code, out, errOut := runCLI(t, dir, "search", "full", "text")
+	if strings.Contains(errOut, "sqlite_fts5") {
+		t.Skip("SQLite built without FTS5, run with -tags sqlite_fts5")
+	}
+	if code != 0 {
+		t.Fatalf("search failed: %s", errOut)
+	}
+	if !strings.Contains(out, "SQLite tips") || strings.Contains(out, "Gardening") {
+		t.Errorf("search output = %q, want only SQLite tips", out)
+	}
+
+	_, out, _ = runCLI(t, dir, "-format", "json", "search", "-since", "2024-01-02", "tomato*")
+	var results []searchJSON
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatalf("search output is not JSON: %v", err)
+	}
+	if len(results) != 1 || results[0].Title != "Gardening" || results[0].Snippet != "Tomatoes again" {
+		t.Errorf("search results = %+v, want Gardening with a plain snippet", results)
+	}
+
+	if code, _, _ := runCLI(t, dir, "search", "-since", "yesterday", "text"); code == 0 {
+		t.Error("search accepted a bad date")
+	}
+	if code, _, _ := runCLI(t, dir, "search", "-read", "-unread", "text"); code == 0 {
+		t.Error("search accepted -read with -unread")
+	}
+}
+
+func TestStarred(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel><title>Saved</title>
+			<item><guid>1</guid><title>One</title><link>http://example.com/1</link></item>
+			<item><guid>2</guid><title>Two</title><link>http://example.com/2</link></item>
+			<item><guid>3</guid><title>Three</title><link>http://example.com/3</link></item>
+		</channel></rss>`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if code, _, errOut := runCLI(t, dir, "add", "-no-discover", srv.URL); code != 0 {
+		t.Fatalf("add failed: %s", errOut)
+	}
+	if code, _, errOut := runCLI(t, dir, "refresh"); code != 0 {
+		t.Fatalf("refresh failed: %s", errOut)
+	}
+	if code, _, errOut := runCLI(t, dir, "star", "1", "2", "3"); code != 0 {
+		t.Fatalf("star failed: %s", errOut)
+	}
+	if code, _, errOut := runCLI(t, dir, "star", "-remove", "2"); code != 0 {
+		t.Fatalf("star -remove failed: %s", errOut)
+	}
+	if code, out, errOut := runCLI(t, dir, "star", "999"); code != 1 || !strings.Contains(errOut, "no such post") {
+		t.Errorf("star of an unknown post = %d, %q, %q, want it to fail", code, out, errOut)
+	}
+
+	code, out, errOut := runCLI(t, dir, "-format", "json", "starred", "-limit", "1")
+	if code != 0 {
+		t.Fatalf("starred failed: %s", errOut)
+	}
+	var page pageJSON
+	if err := json.Unmarshal([]byte(out), &page); err != nil {
+		t.Fatalf("starred output is not JSON: %v", err)
+	}
+	if len(page.Posts) != 1 || page.Next == "" || page.Posts[0].StarredAt == nil {
+		t.Fatalf("first page = %+v, want one starred post and a cursor", page)
+	}
+	_, out, _ = runCLI(t, dir, "-format", "json", "starred", "-limit", "1", "-after", page.Next)
+	var second pageJSON
+	if err := json.Unmarshal([]byte(out), &second); err != nil {
+		t.Fatalf("starred output is not JSON: %v", err)
+	}
+	if len(second.Posts) != 1 || second.Next != "" || second.Posts[0].ID == page.Posts[0].ID {
+		t.Errorf("second page = %+v, want the other starred post and no cursor", second)
+	}
+
+	file := filepath.Join(dir, "starred.html")
+	if code, _, errOut := runCLI(t, dir, "export-starred", file); code != 0 {
+		t.Fatalf("export-starred failed: %s", errOut)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("couldn't read export: %v", err)
+	}
+	if !strings.Contains(string(data), `HREF="http://example.com/3"`) || strings.Contains(string(data), `HREF="http://example.com/2"`) {
+		t.Errorf("export = %s, want posts 1 and 3 only", data)
+	}
+	if code, _, _ := runCLI(t, dir, "export-starred", "-as", "csv"); code == 0 {
+		t.Error("export-starred accepted an unknown format")
+	}
+}
+
+func TestCategories(t *testing.T) {
+	dir := t.TempDir()
+	for _, url := range []string{"https://a.example.com/feed.xml", "https://b.example.com/feed.xml"} {
+		if code, _, errOut := runCLI(t, dir, "add", "-no-discover", url); code != 0 {
+			t.Fatalf("add %s failed: %s", url, errOut)
+		}
+	}
+	if code, _, errOut := runCLI(t, dir, "category-add", "Tech"); code != 0 {
+		t.Fatalf("category-add failed: %s", errOut)
+	}
+	if code, _, errOut := runCLI(t, dir, "category-add", "-parent", "1", "Go"); code != 0 {
+		t.Fatalf("category-add -parent failed: %s", errOut)
+	}
+	if code, _, _ := runCLI(t, dir, "category-add", "Tech"); code == 0 {
+		t.Error("category-add accepted a duplicate name")
+	}
+	if code, _, errOut := runCLI(t, dir, "categorize", "2", "1", "https://b.example.com/feed.xml"); code != 0 {
+		t.Fatalf("categorize failed: %s", errOut)
+	}
+	if code, _, errOut := runCLI(t, dir, "categorize", "-remove", "2", "1"); code != 0 {
+		t.Fatalf("categorize -remove failed: %s", errOut)
+	}
+	if code, _, _ := runCLI(t, dir, "category-edit", "-parent", "2", "1"); code == 0 {
+		t.Error("category-edit moved a category inside itself")
+	}
+	if code, _, errOut := runCLI(t, dir, "category-edit", "-name", "Golang", "2"); code != 0 {
+		t.Fatalf("category-edit failed: %s", errOut)
+	}
+
+	code, out, errOut := runCLI(t, dir, "-format", "json", "categories")
+	if code != 0 {
+		t.Fatalf("categories failed: %s", errOut)
+	}
+	var categories []categoryJSON
+	if err := json.Unmarshal([]byte(out), &categories); err != nil {
+		t.Fatalf("categories output is not JSON: %v", err)
+	}
+	if len(categories) != 2 {
+		t.Fatalf("categories = %+v, want 2", categories)
+	}
+	golang := categories[0]
+	if golang.Name != "Golang" || golang.ParentID != 1 || len(golang.Feeds) != 1 || golang.Feeds[0] != 2 {
+		t.Errorf("Golang = %+v, want under 1 holding feed 2", golang)
+	}
+
+	_, out, _ = runCLI(t, dir, "categories")
+	if !strings.Contains(out, "Tech/") || !strings.Contains(out, "  Golang/") {
+		t.Errorf("categories output = %q, want Golang nested under Tech", out)
+	}
+
+	if code, _, errOut := runCLI(t, dir, "category-remove", "1"); code != 0 {
+		t.Fatalf("category-remove failed: %s", errOut)
+	}
+	_, out, _ = runCLI(t, dir, "-format", "json", "list")
+	var feeds []feedJSON
+	if err := json.Unmarshal([]byte(out), &feeds); err != nil {
+		t.Fatalf("list output is not JSON: %v", err)
+	}
+	if len(feeds) != 2 || feeds[1].Categories != nil {
+		t.Errorf("after category-remove feeds = %+v, want both still there and uncategorized", feeds)
+	}
+}
+
+func TestPosts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel><title>Timeline</title>
+			<item><guid>1</guid><title>One</title><pubDate>Mon, 01 Jan 2024 10:00:00 GMT</pubDate></item>
+			<item><guid>2</guid><title>Two</title><pubDate>Tue, 02 Jan 2024 10:00:00 GMT</pubDate></item>
+			<item><guid>3</guid><title>Three</title><pubDate>Wed, 03 Jan 2024 10:00:00 GMT</pubDate></item>
+		</channel></rss>`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if code, _, errOut := runCLI(t, dir, "add", "-no-discover", srv.URL); code != 0 {
+		t.Fatalf("add failed: %s", errOut)
+	}
+	if code, _, errOut := runCLI(t, dir, "refresh"); code != 0 {
+		t.Fatalf("refresh failed: %s", errOut)
+	}
+
+	var titles []string
+	after := ""
+	for page := 0; page < 5; page++ {
+		code, out, errOut := runCLI(t, dir, "-format", "json", "posts", "-oldest", "-limit", "2", "-after", after)
+		if code != 0 {
+			t.Fatalf("posts failed: %s", errOut)
+		}
+		var got pageJSON
+		if err := json.Unmarshal([]byte(out), &got); err != nil {
+			t.Fatalf("posts output is not JSON: %v", err)
+		}
+		for _, p := range got.Posts {
+			titles = append(titles, p.Title)
+		}
+		if after = got.Next; after == "" {
+			break
+		}
+	}
+	if want := "One Two Three"; strings.Join(titles, " ") != want {
+		t.Errorf("posts -oldest = %v, want %s", titles, want)
+	}
+
+	_, out, _ := runCLI(t, dir, "posts", "-since", "2024-01-02", "-limit", "1")
+	if !strings.Contains(out, "Three") || strings.Contains(out, "Two") || !strings.Contains(out, "more: warss posts -after") {
+		t.Errorf("posts -since -limit 1 = %q, want Three and a cursor", out)
+	}
+	if code, _, _ := runCLI(t, dir, "posts", "-read", "-unread"); code != 2 {
+		t.Errorf("posts -read -unread exit = %d, want 2", code)
+	}
+}
+
+func TestEnclosuresDownload(t *testing.T) {
+	episode := strings.Repeat("audio ", 1000)
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/episode.mp3":
+			_, _ = w.Write([]byte(episode))
+			return
+		case "/missing.mp3":
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`<?xml version="1.0"?>
+			<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel><title>Cast</title>
+			<item><title>Episode</title><guid>1</guid>
+				<enclosure url="` + srv.URL + `/episode.mp3" type="audio/mpeg" length="6000"/>
+				<itunes:duration>1:02:03</itunes:duration></item>
+			<item><title>Gone</title><guid>2</guid>
+				<enclosure url="` + srv.URL + `/missing.mp3" type="audio/mpeg"/></item>
+		</channel></rss>`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	downloads := filepath.Join(dir, "downloads")
+	if code, _, errOut := runCLI(t, dir, "add", "-no-discover", srv.URL+"/feed.xml"); code != 0 {
+		t.Fatalf("add failed: %s", errOut)
+	}
+	if code, _, errOut := runCLI(t, dir, "refresh"); code != 0 {
+		t.Fatalf("refresh failed: %s", errOut)
+	}
+
+	code, out, errOut := runCLI(t, dir, "-format", "json", "enclosures")
+	if code != 0 {
+		t.Fatalf("enclosures failed: %s", errOut)
+	}
+	var listed []enclosureJSON
+	if err := json.Unmarshal([]byte(out), &listed); err != nil {
+		t.Fatalf("enclosures output is not JSON: %v", err)
+	}
+	if len(listed) != 2 {
+		t.Fatalf("got %d enclosures, want 2", len(listed))
+	}
+	var good enclosureJSON
+	for _, e := range listed {
+		if strings.HasSuffix(e.URL, "/episode.mp3") {
+			good = e
+		}
+	}
+	if good.Duration != 3723 || good.Length != 6000 || good.State != "none" {
+		t.Errorf("episode = %+v, want 3723s, 6000 bytes, not downloaded", good)
+	}
+
+	code, out, _ = runCLI(t, dir, "download", "-dir", downloads, "-feed", srv.URL+"/feed.xml")
+	if code != 1 {
+		t.Errorf("download with a missing file exit code = %d, want 1", code)
+	}
+	if !strings.Contains(out, "✅") || !strings.Contains(out, "✗") {
+		t.Errorf("download output = %q, want one success and one failure", out)
+	}
+	got, err := os.ReadFile(filepath.Join(downloads, fmt.Sprintf("%d-episode.mp3", good.ID)))
+	if err != nil || string(got) != episode {
+		t.Errorf("downloaded file = %d bytes, %v, want the episode", len(got), err)
+	}
+
+	_, out, _ = runCLI(t, dir, "enclosures", "-state", "done")
+	if !strings.Contains(out, "1h2m3s") || strings.Contains(out, "missing.mp3") {
+		t.Errorf("done enclosures = %q, want just the episode", out)
+	}
+
+	// Without a selection only the failed one is tried again
+	_, out, _ = runCLI(t, dir, "-format", "json", "download", "-dir", downloads)
+	var retried []enclosureJSON
+	if err := json.Unmarshal([]byte(out), &retried); err != nil {
+		t.Fatalf("download output is not JSON: %v", err)
+	}
+	if len(retried) != 1 || !strings.HasSuffix(retried[0].URL, "/missing.mp3") || retried[0].State != "failed" {
+		t.Errorf("retried = %+v, want only the missing file, failed again", retried)
+	}
+
+	if code, _, _ := runCLI(t, dir, "enclosures", "-state", "bogus"); code != 1 {
+		t.Errorf("enclosures with a bad state exit code = %d, want 1", code)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	feed := `<?xml version="1.0"?><rss version="2.0"><channel><title>Quiet</title></channel></rss>`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/quiet", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(feed))
+	})
+	mux.Handle("/old", http.RedirectHandler("/quiet", http.StatusMovedPermanently))
+	mux.HandleFunc("/gone", http.NotFound)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	for _, path := range []string{"/quiet", "/old", "/gone"} {
+		if code, _, errOut := runCLI(t, dir, "add", "-no-discover", "-title", path, srv.URL+path); code != 0 {
+			t.Fatalf("add %s failed: %s", path, errOut)
+		}
+	}
+	if code, out, errOut := runCLI(t, dir, "health"); code != 0 || out != "every feed is healthy\n" {
+		t.Fatalf("health before any refresh = %d %q: %s", code, out, errOut)
+	}
+
+	// Twice, so the broken feed starts backing off
+	for range 2 {
+		if code, _, _ := runCLI(t, dir, "refresh"); code != 1 {
+			t.Errorf("refresh with a broken feed exit code = %d, want 1", code)
+		}
+	}
+	_, out, _ := runCLI(t, dir, "refresh")
+	if !strings.Contains(out, "⏭ /gone (failing") {
+		t.Errorf("refresh output = %q, want the broken feed skipped", out)
+	}
+	// The working feeds were just fetched, so they're summed up rather than listed
+	if !strings.Contains(out, "⏭ 2 feeds not due yet") || strings.Contains(out, "/quiet") {
+		t.Errorf("refresh output = %q, want the working feeds not due", out)
+	}
+	_, out, _ = runCLI(t, dir, "refresh", "-force")
+	if !strings.Contains(out, "✗ /gone") {
+		t.Errorf("refresh -force output = %q, want the broken feed tried", out)
+	}
+
+	code, out, errOut := runCLI(t, dir, "-format", "json", "health")
+	if code != 0 {
+		t.Fatalf("health failed: %s", errOut)
+	}
+	var report healthReportJSON
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("health output is not JSON: %v", err)
+	}
+	if len(report.Broken) != 1 || report.Broken[0].URL != srv.URL+"/gone" || report.Broken[0].Errors != 3 ||
+		report.Broken[0].LastStatus != http.StatusNotFound || report.Broken[0].RetryAt == nil {
+		t.Errorf("broken = %+v, want /gone failing 3 times with a 404", report.Broken)
+	}
+	// Neither working feed has ever had a post
+	if len(report.Stale) != 2 {
+		t.Errorf("stale = %+v, want both working feeds", report.Stale)
+	}
+	if len(report.Moved) != 1 || report.Moved[0].URL != srv.URL+"/old" || report.Moved[0].MovedTo != srv.URL+"/quiet" {
+		t.Errorf("moved = %+v, want /old moved to /quiet", report.Moved)
+	}
+
+	_, out, _ = runCLI(t, dir, "health")
+	for _, want := range []string{"broken", "404 Not Found", "stale, nothing new in 90 days", "moved permanently", srv.URL + "/quiet"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("health output = %q, want %q in it", out, want)
+		}
+	}
+	if code, _, _ := runCLI(t, dir, "health", "-stale", "0"); code != 2 {
+		t.Errorf("health -stale 0 exit code = %d, want 2", code)
+	}
+}
+
+func TestRefreshFollowsMove(t *testing.T) {
+	feed := `<?xml version="1.0"?><rss version="2.0"><channel><title>Moving</title></channel></rss>`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(feed))
+	})
+	mux.Handle("/old", http.RedirectHandler("/new", http.StatusPermanentRedirect))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if code, _, errOut := runCLI(t, dir, "add", "-no-discover", srv.URL+"/old"); code != 0 {
+		t.Fatalf("add failed: %s", errOut)
+	}
+	var out string
+	for range 3 {
+		_, out, _ = runCLI(t, dir, "refresh", "-force")
+	}
+	if !strings.Contains(out, "↪ Moving moved permanently from "+srv.URL+"/old to "+srv.URL+"/new") {
+		t.Errorf("third refresh output = %q, want the move reported", out)
+	}
+
+	_, out, _ = runCLI(t, dir, "-format", "json", "list")
+	var feeds []feedJSON
+	if err := json.Unmarshal([]byte(out), &feeds); err != nil {
+		t.Fatalf("list output is not JSON: %v", err)
+	}
+	if len(feeds) != 1 || feeds[0].URL != srv.URL+"/new" || len(feeds[0].Aliases) != 1 {
+		t.Errorf("feeds = %+v, want one at the new url with the old one as an alias", feeds)
+	}
+
+	// The old url is still this feed
+	if code, _, errOut := runCLI(t, dir, "add", "-no-discover", srv.URL+"/old"); code != 1 || !strings.Contains(errOut, "already subscribed") {
+		t.Errorf("add of the old url = %d %q, want already subscribed", code, errOut)
+	}
+	if code, _, errOut := runCLI(t, dir, "remove", srv.URL+"/old"); code != 0 {
+		t.Errorf("remove by the old url failed: %s", errOut)
+	}
+}
@@ -0,0 +1,1807 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pixel-87/warss/internal/bookmarks"
+	"github.com/pixel-87/warss/internal/diff"
+	"github.com/pixel-87/warss/internal/download"
+	"github.com/pixel-87/warss/internal/models"
+	"github.com/pixel-87/warss/internal/opml"
+	"github.com/pixel-87/warss/internal/render"
+	"github.com/pixel-87/warss/internal/rss"
+	"github.com/pixel-87/warss/internal/storage"
+	"github.com/pixel-87/warss/internal/tui"
+)
+
+var commands = map[string]*command{
+	"add": {
+		name:    "add",
+		args:    "[-title title] [-pick n] [-no-discover] <url>",
+		summary: "subscribe to a feed, or the feed a web page links to",
+		flags: func(fs *flag.FlagSet) {
+			fs.String("title", "", "title to use until the feed is first refreshed")
+			fs.Int("pick", 0, "which discovered feed to subscribe to when a page offers several")
+			fs.Bool("no-discover", false, "subscribe to the url as given without fetching it")
+		},
+		run: runAdd,
+	},
+	"remove": {
+		name:    "remove",
+		args:    "<feed id|url>",
+		summary: "unsubscribe from a feed and delete its posts",
+		run:     runRemove,
+	},
+	"list": {
+		name:    "list",
+		args:    "",
+		summary: "list subscriptions with their unread counts",
+		run:     runList,
+	},
+	"categories": {
+		name:    "categories",
+		args:    "",
+		summary: "list categories as a tree with their unread counts",
+		run:     runCategories,
+	},
+	"category-add": {
+		name:    "category-add",
+		args:    "[-parent id] <name>",
+		summary: "create a category to file feeds under",
+		flags: func(fs *flag.FlagSet) {
+			fs.Int("parent", 0, "create it inside this category")
+		},
+		run: runCategoryAdd,
+	},
+	"category-edit": {
+		name:    "category-edit",
+		args:    "[-name name] [-parent id] <category id>",
+		summary: "rename a category or move it, -parent 0 moves it to the top",
+		flags: func(fs *flag.FlagSet) {
+			fs.String("name", "", "new name")
+			fs.Int("parent", 0, "move it inside this category")
+		},
+		run: runCategoryEdit,
+	},
+	"category-remove": {
+		name:    "category-remove",
+		args:    "<category id>",
+		summary: "delete a category and those inside it, keeping their feeds",
+		run:     runCategoryRemove,
+	},
+	"categorize": {
+		name:    "categorize",
+		args:    "[-remove] <category id> <feed id|url>...",
+		summary: "file feeds under a category, or take them out with -remove",
+		flags: func(fs *flag.FlagSet) {
+			fs.Bool("remove", false, "take the feeds out of the category instead")
+		},
+		run: runCategorize,
+	},
+	"refresh": {
+		name:    "refresh",
+		args:    "[-workers n] [-force] [-no-prune] [-vacuum]",
+		summary: "fetch the feeds that are due and store new posts",
+		flags: func(fs *flag.FlagSet) {
+			fs.Int("workers", rss.DefaultWorkers, "number of feeds to fetch at once")
+			fs.Bool("force", false, "also fetch feeds that aren't due yet, failing ones included")
+			fs.Bool("no-prune", false, "don't apply the retention policy afterwards")
+			fs.Bool("vacuum", false, "give the space freed by pruning back to the filesystem")
+		},
+		run: runRefresh,
+	},
+	"health": {
+		name:    "health",
+		args:    "[-stale days]",
+		summary: "list feeds that keep failing, have gone quiet or have moved",
+		flags: func(fs *flag.FlagSet) {
+			fs.Int("stale", 90, "call a feed stale after this many days without a new post")
+		},
+		run: runHealth,
+	},
+	"posts": {
+		name:    "posts",
+		args:    "[-feed id|url] [-category id] [-unread | -read] [-starred] [-since date] [-until date] [-oldest] [-limit n] [-after cursor]",
+		summary: "list posts from every feed, or some of them, newest first",
+		flags: func(fs *flag.FlagSet) {
+			fs.String("feed", "", "only posts from this feed")
+			fs.Int("category", 0, "only posts from feeds in this category or those inside it")
+			fs.Bool("unread", false, "only unread posts")
+			fs.Bool("read", false, "only read posts")
+			fs.Bool("starred", false, "only starred posts")
+			fs.String("since", "", "only posts published on or after this date (YYYY-MM-DD)")
+			fs.String("until", "", "only posts published before this date (YYYY-MM-DD)")
+			fs.Bool("oldest", false, "list the oldest posts first")
+			fs.Int("limit", 20, "show at most this many posts")
+			fs.String("after", "", "continue from the cursor printed by the previous page")
+		},
+		run: runPosts,
+	},
+	"read": {
+		name:    "read",
+		args:    "[-keep-unread] [-raw] <post id>",
+		summary: "print a post and mark it read",
+		flags: func(fs *flag.FlagSet) {
+			fs.Bool("keep-unread", false, "don't mark the post read")
+			fs.Bool("raw", false, "print the content as the feed's HTML instead of rendering it")
+		},
+		run: runRead,
+	},
+	"diff": {
+		name:    "diff",
+		args:    "[-revision n] [-raw] <post id>",
+		summary: "show what changed when a feed edited a post",
+		flags: func(fs *flag.FlagSet) {
+			fs.Int("revision", 0, "compare revision n (1 is the oldest) with the version after it instead of the latest change")
+			fs.Bool("raw", false, "compare the feed's HTML instead of the rendered text")
+		},
+		run: runDiff,
+	},
+	"search": {
+		name:    "search",
+		args:    "[-feed id|url] [-unread | -read] [-since date] [-until date] [-limit n] [-offset n] <query>",
+		summary: "search the title and content of every stored post",
+		flags: func(fs *flag.FlagSet) {
+			fs.String("feed", "", "only search this feed")
+			fs.Bool("unread", false, "only unread posts")
+			fs.Bool("read", false, "only read posts")
+			fs.String("since", "", "only posts published on or after this date (YYYY-MM-DD)")
+			fs.String("until", "", "only posts published before this date (YYYY-MM-DD)")
+			fs.Int("limit", 20, "show at most this many results")
+			fs.Int("offset", 0, "skip this many results, for paging")
+		},
+		run: runSearch,
+	},
+	"prune": {
+		name:    "prune",
+		args:    "[-dry-run] [-vacuum]",
+		summary: "delete posts the retention policy no longer keeps",
+		flags: func(fs *flag.FlagSet) {
+			fs.Bool("dry-run", false, "only report what would be deleted")
+			fs.Bool("vacuum", false, "give the freed space back to the filesystem")
+		},
+		run: runPrune,
+	},
+	"retention": {
+		name:    "retention",
+		args:    "[-feed id|url] [-max-age days] [-max-posts n] [-read-max-age days]",
+		summary: "show or change how long posts are kept",
+		flags: func(fs *flag.FlagSet) {
+			fs.String("feed", "", "change this feed's overrides instead of the global policy")
+			fs.Int("max-age", 0, "delete posts published more than this many days ago, 0 keeps them (-1 inherits, for -feed)")
+			fs.Int("max-posts", 0, "keep only this many of each feed's newest posts, 0 keeps all (-1 inherits, for -feed)")
+			fs.Int("read-max-age", 0, "delete read posts published more than this many days ago, 0 keeps them (-1 inherits, for -feed)")
+		},
+		run: runRetention,
+	},
+	"mark-read": {
+		name:    "mark-read",
+		args:    "[-unread] [-feed id|url | -all | <post id>...]",
+		summary: "mark posts, a whole feed or everything as read",
+		flags: func(fs *flag.FlagSet) {
+			fs.Bool("unread", false, "mark the given posts unread instead")
+			fs.String("feed", "", "mark every post of this feed read")
+			fs.Bool("all", false, "mark every post read")
+		},
+		run: runMarkRead,
+	},
+	"star": {
+		name:    "star",
+		args:    "[-remove] <post id>...",
+		summary: "star posts to keep them, or unstar them with -remove",
+		flags: func(fs *flag.FlagSet) {
+			fs.Bool("remove", false, "unstar the posts instead")
+		},
+		run: runStar,
+	},
+	"starred": {
+		name:    "starred",
+		args:    "[-limit n] [-after cursor]",
+		summary: "list starred posts from every feed, most recently starred first",
+		flags: func(fs *flag.FlagSet) {
+			fs.Int("limit", 20, "show at most this many posts")
+			fs.String("after", "", "continue from the cursor printed by the previous page")
+		},
+		run: runStarred,
+	},
+	"export-starred": {
+		name:    "export-starred",
+		args:    "[-as html|json] [file]",
+		summary: "write starred posts as browser bookmarks or JSON to a file or stdout",
+		flags: func(fs *flag.FlagSet) {
+			fs.String("as", "html", "html for a Netscape bookmark file, json for everything stored")
+		},
+		run: runExportStarred,
+	},
+	"enclosures": {
+		name:    "enclosures",
+		args:    "[-post id] [-feed id|url] [-state state]",
+		summary: "list podcast episodes and other files attached to posts",
+		flags: func(fs *flag.FlagSet) {
+			fs.Int("post", 0, "only this post's enclosures")
+			fs.String("feed", "", "only enclosures from this feed")
+			fs.String("state", "", "only enclosures in this download state: none, queued, downloading, done or failed")
+		},
+		run: runEnclosures,
+	},
+	"download": {
+		name:    "download",
+		args:    "[-dir path] [-workers n] [-post id] [-feed id|url] [enclosure id...]",
+		summary: "download enclosures, or carry on with queued and failed ones",
+		flags: func(fs *flag.FlagSet) {
+			fs.String("dir", defaultDownloadDir(), "directory to save into")
+			fs.Int("workers", download.DefaultWorkers, "number of files to download at once")
+			fs.Int("post", 0, "download this post's enclosures")
+			fs.String("feed", "", "download every enclosure from this feed")
+		},
+		run: runDownload,
+	},
+	"import": {
+		name:    "import",
+		args:    "<file.opml>",
+		summary: "subscribe to every feed in an OPML file",
+		run:     runImport,
+	},
+	"export": {
+		name:    "export",
+		args:    "[file.opml]",
+		summary: "write subscriptions as OPML to a file or stdout",
+		run:     runExport,
+	},
+	"tui": {
+		name:    "tui",
+		args:    "",
+		summary: "browse feeds and posts interactively (the default)",
+		run:     runTUI,
+	},
+	"version": {
+		name:    "version",
+		args:    "",
+		summary: "print the warss version",
+		noDB:    true,
+		run:     runVersion,
+	},
+}
+
+// flagValue reads back a command specific flag registered in command.flags
+func flagValue[T any](fs *flag.FlagSet, name string) T {
+	return fs.Lookup(name).Value.(flag.Getter).Get().(T)
+}
+
+// findFeed resolves a feed from either its numeric id or its url, current or old
+func findFeed(ctx context.Context, a *app, arg string) (models.Feed, error) {
+	feeds, err := a.db.GetFeedsContext(ctx)
+	if err != nil {
+		return models.Feed{}, err
+	}
+	id, idErr := strconv.Atoi(arg)
+	for _, f := range feeds {
+		if (idErr == nil && f.ID == id) || f.URL == arg || slices.Contains(f.Aliases, arg) {
+			return f, nil
+		}
+	}
+	return models.Feed{}, fmt.Errorf("no feed %q", arg)
+}
+
+type feedJSON struct {
+	ID          int      `json:"id"`
+	Title       string   `json:"title"`
+	URL         string   `json:"url"`
+	Unread      int      `json:"unread"`
+	Categories  []int    `json:"categories,omitempty"`
+	SiteURL     string   `json:"site_url,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Icon        string   `json:"icon,omitempty"`
+	Logo        string   `json:"logo,omitempty"`
+	Language    string   `json:"language,omitempty"`
+	Aliases     []string `json:"aliases,omitempty"`
+	// NextDueAt is when refresh next fetches the feed, left out when it's due now
+	NextDueAt *time.Time `json:"next_due_at,omitempty"`
+}
+
+func toFeedJSON(f models.Feed) feedJSON {
+	return feedJSON{
+		ID:          f.ID,
+		Title:       f.Title,
+		URL:         f.URL,
+		Unread:      f.UnreadCount(),
+		Categories:  f.CategoryIDs,
+		SiteURL:     f.SiteURL,
+		Description: f.Description,
+		Icon:        f.IconURL,
+		Logo:        f.LogoURL,
+		Language:    f.Language,
+		Aliases:     f.Aliases,
+		NextDueAt:   optionalTime(f.Schedule.NextDueAt),
+	}
+}
+
+type candidateJSON struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+}
+
+func runAdd(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	url := args[0]
+	title := flagValue[string](fs, "title")
+
+	if !flagValue[bool](fs, "no-discover") {
+		candidates, err := rss.NewFetcher(a.db).Discover(ctx, url)
+		if err != nil {
+			return err
+		}
+		pick := flagValue[int](fs, "pick")
+		if pick < 0 || pick > len(candidates) {
+			return fmt.Errorf("-pick %d is out of range, found %d feeds", pick, len(candidates))
+		}
+		if pick == 0 && len(candidates) > 1 {
+			if a.json() {
+				out := make([]candidateJSON, len(candidates))
+				for i, c := range candidates {
+					out[i] = candidateJSON{URL: c.URL, Title: c.Title}
+				}
+				if err := a.printJSON(out); err != nil {
+					return err
+				}
+			} else {
+				for i, c := range candidates {
+					_, _ = fmt.Fprintf(a.stdout, "%d. %s %s\n", i+1, c.Title, c.URL)
+				}
+			}
+			return fmt.Errorf("%s offers %d feeds, choose one with -pick", url, len(candidates))
+		}
+
+		chosen := candidates[max(pick, 1)-1]
+		url = chosen.URL
+		if title == "" {
+			title = chosen.Title
+		}
+	}
+
+	if err := a.db.AddFeedContext(ctx, url, title); err != nil {
+		return err
+	}
+	feed, err := findFeed(ctx, a, url)
+	if err != nil {
+		return err
+	}
+	if a.json() {
+		return a.printJSON(toFeedJSON(feed))
+	}
+	_, err = fmt.Fprintf(a.stdout, "added %s (id %d)\n", feed.URL, feed.ID)
+	return err
+}
+
+func runRemove(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	feed, err := findFeed(ctx, a, args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.db.DeleteFeedContext(ctx, feed.ID); err != nil {
+		return err
+	}
+	if a.json() {
+		return a.printJSON(toFeedJSON(feed))
+	}
+	_, err = fmt.Fprintf(a.stdout, "removed %s\n", feed.URL)
+	return err
+}
+
+func runList(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	feeds, err := a.db.GetFeedsContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if a.json() {
+		out := make([]feedJSON, 0, len(feeds))
+		for _, f := range feeds {
+			out = append(out, toFeedJSON(f))
+		}
+		return a.printJSON(out)
+	}
+
+	tw := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tUNREAD\tTITLE\tURL")
+	for _, f := range feeds {
+		_, _ = fmt.Fprintf(tw, "%d\t%d\t%s\t%s\n", f.ID, f.UnreadCount(), f.Title, f.URL)
+	}
+	return tw.Flush()
+}
+
+type categoryJSON struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	ParentID int    `json:"parent_id,omitempty"`
+	Unread   int    `json:"unread"`
+	Feeds    []int  `json:"feeds"`
+}
+
+func runCategories(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	categories, err := a.db.GetCategories(ctx)
+	if err != nil {
+		return err
+	}
+	feeds, err := a.db.GetFeedsContext(ctx)
+	if err != nil {
+		return err
+	}
+	filed := make(map[int][]models.Feed)
+	for _, f := range feeds {
+		for _, id := range f.CategoryIDs {
+			filed[id] = append(filed[id], f)
+		}
+	}
+
+	if a.json() {
+		out := make([]categoryJSON, 0, len(categories))
+		for _, c := range categories {
+			ids := []int{}
+			for _, f := range filed[c.ID] {
+				ids = append(ids, f.ID)
+			}
+			out = append(out, categoryJSON{ID: c.ID, Name: c.Name, ParentID: c.ParentID, Unread: c.Unread, Feeds: ids})
+		}
+		return a.printJSON(out)
+	}
+
+	children := make(map[int][]models.Category)
+	for _, c := range categories {
+		children[c.ParentID] = append(children[c.ParentID], c)
+	}
+	tw := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tUNREAD\tNAME")
+	var walk func(parent int, indent string)
+	walk = func(parent int, indent string) {
+		for _, c := range children[parent] {
+			_, _ = fmt.Fprintf(tw, "%d\t%d\t%s%s/\n", c.ID, c.Unread, indent, c.Name)
+			walk(c.ID, indent+"  ")
+			for _, f := range filed[c.ID] {
+				_, _ = fmt.Fprintf(tw, "\t%d\t%s  %s\n", f.UnreadCount(), indent, f.Title)
+			}
+		}
+	}
+	walk(0, "")
+	return tw.Flush()
+}
+
+func runCategoryAdd(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := a.db.AddCategory(ctx, args[0], flagValue[int](fs, "parent"))
+	if err != nil {
+		return err
+	}
+	if a.json() {
+		return a.printJSON(categoryJSON{ID: id, Name: args[0], ParentID: flagValue[int](fs, "parent"), Feeds: []int{}})
+	}
+	_, err = fmt.Fprintf(a.stdout, "added category %d %s\n", id, args[0])
+	return err
+}
+
+// findCategory resolves a category from its numeric id
+func findCategory(ctx context.Context, a *app, arg string) (models.Category, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return models.Category{}, errUsage
+	}
+	categories, err := a.db.GetCategories(ctx)
+	if err != nil {
+		return models.Category{}, err
+	}
+	for _, c := range categories {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return models.Category{}, fmt.Errorf("no category %d", id)
+}
+
+func runCategoryEdit(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	c, err := findCategory(ctx, a, args[0])
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			c.Name, changed = flagValue[string](fs, f.Name), true
+		case "parent":
+			c.ParentID, changed = flagValue[int](fs, f.Name), true
+		}
+	})
+	if !changed {
+		return errUsage
+	}
+	if err := a.db.UpdateCategory(ctx, c); err != nil {
+		return err
+	}
+
+	if a.json() {
+		return a.printJSON(map[string]any{"id": c.ID, "name": c.Name, "parent_id": c.ParentID})
+	}
+	_, err = fmt.Fprintf(a.stdout, "updated category %d %s\n", c.ID, c.Name)
+	return err
+}
+
+func runCategoryRemove(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	c, err := findCategory(ctx, a, args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.db.DeleteCategory(ctx, c.ID); err != nil {
+		return err
+	}
+	if a.json() {
+		return a.printJSON(map[string]any{"id": c.ID, "name": c.Name})
+	}
+	_, err = fmt.Fprintf(a.stdout, "removed category %s\n", c.Name)
+	return err
+}
+
+func runCategorize(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	c, err := findCategory(ctx, a, args[0])
+	if err != nil {
+		return err
+	}
+	remove := flagValue[bool](fs, "remove")
+	for _, arg := range args[1:] {
+		feed, err := findFeed(ctx, a, arg)
+		if err != nil {
+			return err
+		}
+		if remove {
+			err = a.db.RemoveFeedFromCategory(ctx, feed.ID, c.ID)
+		} else {
+			err = a.db.AddFeedToCategory(ctx, feed.ID, c.ID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.json() {
+		return a.printJSON(map[string]int{"category": c.ID, "feeds": len(args) - 1})
+	}
+	verb := "filed %d feeds under %s\n"
+	if remove {
+		verb = "took %d feeds out of %s\n"
+	}
+	_, err = fmt.Fprintf(a.stdout, verb, len(args)-1, c.Name)
+	return err
+}
+
+type refreshJSON struct {
+	FeedID    int    `json:"feed_id"`
+	Title     string `json:"title"`
+	Status    string `json:"status"`
+	New       int    `json:"new"`
+	Updated   int    `json:"updated"`
+	Unchanged int    `json:"unchanged"`
+	Error     string `json:"error,omitempty"`
+	// NextDueAt is when the feed is next fetched
+	NextDueAt *time.Time `json:"next_due_at,omitempty"`
+	// MovedFrom is the old URL of a feed this refresh found had moved
+	MovedFrom string `json:"moved_from,omitempty"`
+}
+
+func runRefresh(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	subs, err := a.db.GetFeedsContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	fetcher := rss.NewFetcher(a.db)
+	fetcher.SetWorkers(flagValue[int](fs, "workers"))
+	fetcher.SetForce(flagValue[bool](fs, "force"))
+
+	var out []refreshJSON
+	failed, notDue := 0, 0
+	for res := range fetcher.RefreshAll(ctx, subs) {
+		r := refreshJSON{
+			FeedID:    res.Feed.ID,
+			Title:     res.Feed.Title,
+			Status:    res.Status.String(),
+			New:       res.Stats.New,
+			Updated:   res.Stats.Updated,
+			Unchanged: res.Stats.Unchanged,
+		}
+		if res.Err != nil {
+			r.Error = res.Err.Error()
+			failed++
+		}
+		r.NextDueAt = optionalTime(res.Feed.Schedule.NextDueAt)
+		r.MovedFrom = res.MovedFrom
+		if a.json() {
+			out = append(out, r)
+			continue
+		}
+
+		if r.MovedFrom != "" {
+			_, _ = fmt.Fprintf(a.stdout, "↪ %s moved permanently from %s to %s\n", r.Title, r.MovedFrom, res.Feed.URL)
+		}
+		switch res.Status {
+		case rss.StatusUnchanged:
+			_, _ = fmt.Fprintf(a.stdout, "⏸ %s (unchanged)\n", r.Title)
+		case rss.StatusFailed:
+			_, _ = fmt.Fprintf(a.stdout, "✗ %s: %s\n", r.Title, r.Error)
+		case rss.StatusSkipped:
+			if !res.Feed.Health.Failing() {
+				notDue++
+				continue
+			}
+			_, _ = fmt.Fprintf(a.stdout, "⏭ %s (failing, next try %s)\n", r.Title, res.Feed.Schedule.NextDueAt.Local().Format(time.DateTime))
+		default:
+			_, _ = fmt.Fprintf(a.stdout, "✅ %s (%d new, %d updated, %d unchanged)\n", r.Title, r.New, r.Updated, r.Unchanged)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Listing every feed that wasn't due would bury the ones that were
+	if notDue > 0 && !a.json() {
+		_, _ = fmt.Fprintf(a.stdout, "⏭ %d feeds not due yet, -force fetches them anyway\n", notDue)
+	}
+
+	if a.json() {
+		if out == nil {
+			out = []refreshJSON{}
+		}
+		if err := a.printJSON(out); err != nil {
+			return err
+		}
+	}
+
+	if !flagValue[bool](fs, "no-prune") {
+		pruned, err := a.db.Prune(ctx, false)
+		if err != nil {
+			return err
+		}
+		if n := prunedPosts(pruned); n > 0 && !a.json() {
+			_, _ = fmt.Fprintf(a.stdout, "🧹 pruned %d old posts\n", n)
+		}
+	}
+	if flagValue[bool](fs, "vacuum") {
+		if err := a.db.Vacuum(ctx); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d feeds failed", failed, len(subs))
+	}
+	return nil
+}
+
+type healthJSON struct {
+	FeedID        int        `json:"feed_id"`
+	Title         string     `json:"title"`
+	URL           string     `json:"url"`
+	LastFetchAt   *time.Time `json:"last_fetch_at,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+	Errors        int        `json:"errors"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastStatus    int        `json:"last_status,omitempty"`
+	RetryAt       *time.Time `json:"retry_at,omitempty"`
+	MovedTo       string     `json:"moved_to,omitempty"`
+	MovedCount    int        `json:"moved_count,omitempty"`
+	ToPage        bool       `json:"redirected_to_page,omitempty"`
+	LastNewPostAt *time.Time `json:"last_new_post_at,omitempty"`
+}
+
+// optionalTime leaves zero times out of JSON
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func toHealthJSON(f models.Feed) healthJSON {
+	h := f.Health
+	return healthJSON{
+		FeedID:        f.ID,
+		Title:         f.Title,
+		URL:           f.URL,
+		LastFetchAt:   optionalTime(h.LastFetchAt),
+		LastSuccessAt: optionalTime(h.LastSuccessAt),
+		Errors:        h.Errors,
+		LastError:     h.LastError,
+		LastStatus:    h.LastStatus,
+		RetryAt:       optionalTime(h.RetryAt),
+		MovedTo:       h.MovedTo,
+		MovedCount:    h.MovedCount,
+		ToPage:        h.RedirectedToPage,
+		LastNewPostAt: optionalTime(h.LastNewPostAt),
+	}
+}
+
+// healthReportJSON sorts feeds by what's wrong with them. A failing feed is
+// only listed as broken, not as stale too, and one whose URL now leads to a
+// web page only under pages.
+type healthReportJSON struct {
+	Broken []healthJSON `json:"broken"`
+	Pages  []healthJSON `json:"pages"`
+	Stale  []healthJSON `json:"stale"`
+	Moved  []healthJSON `json:"moved"`
+}
+
+// day formats a time as a date, "never" when it's zero
+func day(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(time.DateOnly)
+}
+
+func runHealth(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	days := flagValue[int](fs, "stale")
+	if len(args) != 0 || days < 1 {
+		return errUsage
+	}
+	feeds, err := a.db.GetFeedsContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var broken, pages, stale, moved []models.Feed
+	now := time.Now()
+	for _, f := range feeds {
+		switch {
+		case f.Health.RedirectedToPage:
+			pages = append(pages, f)
+		case f.Health.Failing():
+			broken = append(broken, f)
+		case f.Health.Stale(now, time.Duration(days)*24*time.Hour):
+			stale = append(stale, f)
+		}
+		if f.Health.MovedTo != "" {
+			moved = append(moved, f)
+		}
+	}
+	// Longest failing and longest quiet first
+	sort.SliceStable(broken, func(i, j int) bool { return broken[i].Health.Errors > broken[j].Health.Errors })
+	sort.SliceStable(stale, func(i, j int) bool {
+		return stale[i].Health.LastNewPostAt.Before(stale[j].Health.LastNewPostAt)
+	})
+
+	if a.json() {
+		out := healthReportJSON{Broken: []healthJSON{}, Pages: []healthJSON{}, Stale: []healthJSON{}, Moved: []healthJSON{}}
+		for _, f := range broken {
+			out.Broken = append(out.Broken, toHealthJSON(f))
+		}
+		for _, f := range pages {
+			out.Pages = append(out.Pages, toHealthJSON(f))
+		}
+		for _, f := range stale {
+			out.Stale = append(out.Stale, toHealthJSON(f))
+		}
+		for _, f := range moved {
+			out.Moved = append(out.Moved, toHealthJSON(f))
+		}
+		return a.printJSON(out)
+	}
+
+	if len(broken)+len(pages)+len(stale)+len(moved) == 0 {
+		_, err := fmt.Fprintln(a.stdout, "every feed is healthy")
+		return err
+	}
+	tw := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	if len(broken) > 0 {
+		_, _ = fmt.Fprintf(tw, "broken\n  ID\tTITLE\tFAILURES\tLAST WORKED\tNEXT TRY\tERROR\n")
+		for _, f := range broken {
+			next := "next refresh"
+			if !f.Health.RetryAt.IsZero() {
+				next = f.Health.RetryAt.Local().Format(time.DateTime)
+			}
+			_, _ = fmt.Fprintf(tw, "  %d\t%s\t%d\t%s\t%s\t%s\n", f.ID, f.Title, f.Health.Errors,
+				day(f.Health.LastSuccessAt), next, f.Health.LastError)
+		}
+	}
+	if len(pages) > 0 {
+		_, _ = fmt.Fprintf(tw, "redirects to a web page, the feed may be gone\n  ID\tTITLE\tLAST WORKED\tERROR\n")
+		for _, f := range pages {
+			_, _ = fmt.Fprintf(tw, "  %d\t%s\t%s\t%s\n", f.ID, f.Title, day(f.Health.LastSuccessAt), f.Health.LastError)
+		}
+	}
+	if len(stale) > 0 {
+		_, _ = fmt.Fprintf(tw, "stale, nothing new in %d days\n  ID\tTITLE\tLAST NEW POST\n", days)
+		for _, f := range stale {
+			_, _ = fmt.Fprintf(tw, "  %d\t%s\t%s\n", f.ID, f.Title, day(f.Health.LastNewPostAt))
+		}
+	}
+	if len(moved) > 0 {
+		_, _ = fmt.Fprintf(tw, "moved permanently, followed once it's seen a few times\n  ID\tTITLE\tURL\tNOW AT\tSEEN\n")
+		for _, f := range moved {
+			_, _ = fmt.Fprintf(tw, "  %d\t%s\t%s\t%s\t%d\n", f.ID, f.Title, f.URL, f.Health.MovedTo, f.Health.MovedCount)
+		}
+	}
+	return tw.Flush()
+}
+
+type searchJSON struct {
+	ID          int       `json:"id"`
+	FeedID      int       `json:"feed_id"`
+	FeedTitle   string    `json:"feed_title"`
+	Title       string    `json:"title"`
+	Link        string    `json:"link"`
+	PublishedAt time.Time `json:"published_at"`
+	Read        bool      `json:"read"`
+	Snippet     string    `json:"snippet"`
+}
+
+// highlight turns the match markers Search puts in titles and snippets into
+// bold text, or drops them when there's no terminal to style
+func highlight(s string, color bool) string {
+	start, end := "", ""
+	if color {
+		start, end = "\x1b[1m", "\x1b[22m"
+	}
+	return strings.NewReplacer(storage.HighlightStart, start, storage.HighlightEnd, end).Replace(s)
+}
+
+// parseDate reads a YYYY-MM-DD flag value as midnight UTC, empty is the zero time
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad date %q, want YYYY-MM-DD", value)
+	}
+	return t, nil
+}
+
+func runPosts(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	query := storage.PostQuery{
+		CategoryID: flagValue[int](fs, "category"),
+		Starred:    flagValue[bool](fs, "starred"),
+		Limit:      flagValue[int](fs, "limit"),
+		After:      storage.Cursor(flagValue[string](fs, "after")),
+	}
+	switch unread, read := flagValue[bool](fs, "unread"), flagValue[bool](fs, "read"); {
+	case unread && read:
+		return errUsage
+	case unread:
+		query.Read = storage.UnreadPosts
+	case read:
+		query.Read = storage.ReadPosts
+	}
+	if flagValue[bool](fs, "oldest") {
+		query.Order = storage.OldestFirst
+	}
+	feeds, err := a.db.GetFeedsContext(ctx)
+	if err != nil {
+		return err
+	}
+	if arg := flagValue[string](fs, "feed"); arg != "" {
+		feed, err := findFeed(ctx, a, arg)
+		if err != nil {
+			return err
+		}
+		query.FeedIDs = []int{feed.ID}
+	}
+	if query.Since, err = parseDate(flagValue[string](fs, "since")); err != nil {
+		return err
+	}
+	if query.Until, err = parseDate(flagValue[string](fs, "until")); err != nil {
+		return err
+	}
+
+	posts, next, err := a.db.ListPosts(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	if a.json() {
+		out := pageJSON{Posts: []postJSON{}, Next: string(next)}
+		for _, p := range posts {
+			out.Posts = append(out.Posts, toPostJSON(p))
+		}
+		return a.printJSON(out)
+	}
+
+	titles := make(map[int]string, len(feeds))
+	for _, f := range feeds {
+		titles[f.ID] = f.Title
+	}
+	tw := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tPUBLISHED\tFEED\tTITLE")
+	for _, p := range posts {
+		date := "-"
+		if !p.PublishedAt.IsZero() {
+			date = p.PublishedAt.Format(time.DateOnly)
+		}
+		title := p.Title
+		if !p.Read {
+			title = "• " + title
+		}
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", p.ID, date, titles[p.FeedID], title)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if next != "" {
+		_, err = fmt.Fprintf(a.stdout, "more: warss posts -after %s (with the same filters)\n", next)
+	}
+	return err
+}
+
+func runSearch(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	filters := storage.SearchFilters{
+		Limit:  flagValue[int](fs, "limit"),
+		Offset: flagValue[int](fs, "offset"),
+	}
+	switch unread, read := flagValue[bool](fs, "unread"), flagValue[bool](fs, "read"); {
+	case unread && read:
+		return errUsage
+	case unread:
+		filters.Read = storage.UnreadPosts
+	case read:
+		filters.Read = storage.ReadPosts
+	}
+	if arg := flagValue[string](fs, "feed"); arg != "" {
+		feed, err := findFeed(ctx, a, arg)
+		if err != nil {
+			return err
+		}
+		filters.FeedIDs = []int{feed.ID}
+	}
+	var err error
+	if filters.Since, err = parseDate(flagValue[string](fs, "since")); err != nil {
+		return err
+	}
+	if filters.Until, err = parseDate(flagValue[string](fs, "until")); err != nil {
+		return err
+	}
+
+	// Unquoted words arrive as separate arguments
+	results, err := a.db.Search(ctx, strings.Join(args, " "), filters)
+	if err != nil {
+		return err
+	}
+
+	if a.json() {
+		out := []searchJSON{}
+		for _, r := range results {
+			out = append(out, searchJSON{
+				ID:          r.Post.ID,
+				FeedID:      r.Post.FeedID,
+				FeedTitle:   r.FeedTitle,
+				Title:       r.Post.Title,
+				Link:        r.Post.Link,
+				PublishedAt: r.Post.PublishedAt,
+				Read:        r.Post.Read,
+				Snippet:     highlight(r.Snippet, false),
+			})
+		}
+		return a.printJSON(out)
+	}
+
+	color := a.isTerminal()
+	for _, r := range results {
+		marker := " "
+		if !r.Post.Read {
+			marker = "•"
+		}
+		_, _ = fmt.Fprintf(a.stdout, "%s %d\t%s — %s (%s)\n", marker, r.Post.ID,
+			highlight(r.Title, color), r.FeedTitle, r.Post.PublishedAt.Format(time.DateOnly))
+		if r.Snippet != "" {
+			_, _ = fmt.Fprintf(a.stdout, "\t%s\n", highlight(r.Snippet, color))
+		}
+	}
+	if len(results) == 0 {
+		_, err = fmt.Fprintln(a.stdout, "no posts found")
+	}
+	return err
+}
+
+type pruneJSON struct {
+	FeedID int    `json:"feed_id"`
+	Title  string `json:"title"`
+	Posts  int    `json:"posts"`
+}
+
+func prunedPosts(results []storage.PruneResult) int {
+	n := 0
+	for _, r := range results {
+		n += r.Posts
+	}
+	return n
+}
+
+func runPrune(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	dryRun := flagValue[bool](fs, "dry-run")
+	results, err := a.db.Prune(ctx, dryRun)
+	if err != nil {
+		return err
+	}
+
+	if a.json() {
+		out := []pruneJSON{}
+		for _, r := range results {
+			out = append(out, pruneJSON{FeedID: r.FeedID, Title: r.Title, Posts: r.Posts})
+		}
+		err = a.printJSON(out)
+	} else {
+		verb := "removed"
+		if dryRun {
+			verb = "would remove"
+		}
+		for _, r := range results {
+			_, _ = fmt.Fprintf(a.stdout, "%s: %s %d posts\n", r.Title, verb, r.Posts)
+		}
+		_, err = fmt.Fprintf(a.stdout, "%s %d posts in total\n", verb, prunedPosts(results))
+	}
+	if err != nil {
+		return err
+	}
+
+	if dryRun || !flagValue[bool](fs, "vacuum") {
+		return nil
+	}
+	return a.db.Vacuum(ctx)
+}
+
+type retentionJSON struct {
+	FeedID         int `json:"feed_id,omitempty"`
+	MaxAgeDays     int `json:"max_age_days"`
+	MaxPosts       int `json:"max_posts"`
+	ReadMaxAgeDays int `json:"read_max_age_days"`
+}
+
+// describeRule says what one retention rule does, for the text output of retention
+func describeRule(n int, unit string, global int) string {
+	switch {
+	case n == storage.Inherit:
+		return "inherit (" + describeRule(global, unit, 0) + ")"
+	case n == 0:
+		return "no limit"
+	default:
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+}
+
+func runRetention(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	global, err := a.db.RetentionPolicy(ctx)
+	if err != nil {
+		return err
+	}
+
+	var feed models.Feed
+	policy := global
+	if arg := flagValue[string](fs, "feed"); arg != "" {
+		if feed, err = findFeed(ctx, a, arg); err != nil {
+			return err
+		}
+		if policy, err = a.db.FeedRetentionPolicy(ctx, feed.ID); err != nil {
+			return err
+		}
+	}
+
+	// Only the rules given on the command line change
+	changed := false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "max-age":
+			policy.MaxAgeDays, changed = flagValue[int](fs, f.Name), true
+		case "max-posts":
+			policy.MaxPosts, changed = flagValue[int](fs, f.Name), true
+		case "read-max-age":
+			policy.ReadMaxAgeDays, changed = flagValue[int](fs, f.Name), true
+		}
+	})
+	if changed {
+		if policy.MaxAgeDays < storage.Inherit || policy.MaxPosts < storage.Inherit || policy.ReadMaxAgeDays < storage.Inherit {
+			return errUsage
+		}
+		if feed.ID == 0 {
+			err = a.db.SetRetentionPolicy(ctx, policy)
+		} else {
+			err = a.db.SetFeedRetentionPolicy(ctx, feed.ID, policy)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.json() {
+		return a.printJSON(retentionJSON{
+			FeedID:         feed.ID,
+			MaxAgeDays:     policy.MaxAgeDays,
+			MaxPosts:       policy.MaxPosts,
+			ReadMaxAgeDays: policy.ReadMaxAgeDays,
+		})
+	}
+	name := "global"
+	if feed.ID != 0 {
+		name = feed.Title
+	}
+	w := tabwriter.NewWriter(a.stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "%s\n", name)
+	_, _ = fmt.Fprintf(w, "  max age\t%s\n", describeRule(policy.MaxAgeDays, "days", global.MaxAgeDays))
+	_, _ = fmt.Fprintf(w, "  max posts per feed\t%s\n", describeRule(policy.MaxPosts, "posts", global.MaxPosts))
+	_, _ = fmt.Fprintf(w, "  read posts max age\t%s\n", describeRule(policy.ReadMaxAgeDays, "days", global.ReadMaxAgeDays))
+	return w.Flush()
+}
+
+type postJSON struct {
+	ID               int        `json:"id"`
+	FeedID           int        `json:"feed_id"`
+	Title            string     `json:"title"`
+	Link             string     `json:"link"`
+	Content          string     `json:"content"`
+	Summary          string     `json:"summary,omitempty"`
+	Authors          []string   `json:"authors,omitempty"`
+	Tags             []string   `json:"tags,omitempty"`
+	Image            string     `json:"image,omitempty"`
+	CommentsURL      string     `json:"comments_url,omitempty"`
+	Language         string     `json:"language,omitempty"`
+	PublishedAt      time.Time  `json:"published_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	DateSource       string     `json:"date_source,omitempty"`
+	Read             bool       `json:"read"`
+	UpdatedSinceRead bool       `json:"updated_since_read"`
+	StarredAt        *time.Time `json:"starred_at,omitempty"`
+}
+
+func toPostJSON(p models.Post) postJSON {
+	out := postJSON{
+		ID:               p.ID,
+		FeedID:           p.FeedID,
+		Title:            p.Title,
+		Link:             p.Link,
+		Content:          p.Content,
+		Summary:          p.Summary,
+		Authors:          p.Authors,
+		Tags:             p.Tags,
+		Image:            p.ImageURL,
+		CommentsURL:      p.CommentsURL,
+		Language:         p.Language,
+		PublishedAt:      p.PublishedAt,
+		UpdatedAt:        p.UpdatedAt,
+		DateSource:       string(p.DateSource),
+		Read:             p.Read,
+		UpdatedSinceRead: p.UpdatedSinceRead,
+	}
+	if p.Starred() {
+		out.StarredAt = &p.StarredAt
+	}
+	return out
+}
+
+func runRead(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return errUsage
+	}
+	post, err := a.db.GetPost(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if a.json() {
+		err = a.printJSON(toPostJSON(post))
+	} else {
+		content := post.Content
+		if !flagValue[bool](fs, "raw") {
+			content = render.HTML(content, render.Options{
+				Width:   a.termWidth(),
+				Color:   a.isTerminal(),
+				BaseURL: post.Link,
+			})
+		}
+		_, err = fmt.Fprintf(a.stdout, "%s\n%s\n", post.Title, post.Link)
+		if err == nil && post.Byline() != "" {
+			_, err = fmt.Fprintf(a.stdout, "by %s\n", post.Byline())
+		}
+		if err == nil {
+			_, err = fmt.Fprintf(a.stdout, "%s\n", post.PublishedAt.Format(time.RFC1123))
+		}
+		if err == nil && len(post.Tags) > 0 {
+			_, err = fmt.Fprintf(a.stdout, "tags: %s\n", strings.Join(post.Tags, ", "))
+		}
+		if err == nil && post.CommentsURL != "" {
+			_, err = fmt.Fprintf(a.stdout, "comments: %s\n", post.CommentsURL)
+		}
+		if err == nil && post.UpdatedSinceRead {
+			_, err = fmt.Fprintf(a.stdout, "updated since you read it, see warss diff %d\n", post.ID)
+		}
+		if err == nil {
+			_, err = fmt.Fprintf(a.stdout, "\n%s\n", content)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if flagValue[bool](fs, "keep-unread") {
+		return nil
+	}
+	return a.db.MarkRead(ctx, post.ID)
+}
+
+type diffLineJSON struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+type diffJSON struct {
+	PostID   int            `json:"post_id"`
+	Revision int            `json:"revision"`
+	From     time.Time      `json:"from_updated_at"`
+	To       time.Time      `json:"to_updated_at"`
+	Lines    []diffLineJSON `json:"lines"`
+}
+
+// postVersion is one version of a post as it is compared by diff
+type postVersion struct {
+	title, link, content string
+	updatedAt            time.Time
+}
+
+func (v postVersion) text(a *app, raw bool) string {
+	content := v.content
+	if !raw {
+		content = render.HTML(content, render.Options{Width: a.termWidth(), BaseURL: v.link})
+	}
+	return v.title + "\n" + v.link + "\n\n" + content
+}
+
+func runDiff(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return errUsage
+	}
+	post, err := a.db.GetPost(ctx, id)
+	if err != nil {
+		return err
+	}
+	revisions, err := a.db.GetPostRevisions(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		return fmt.Errorf("post %d hasn't changed since it was first fetched", id)
+	}
+
+	// Oldest first with the current version at the end
+	versions := make([]postVersion, 0, len(revisions)+1)
+	for _, r := range revisions {
+		versions = append(versions, postVersion{r.Title, r.Link, r.Content, r.UpdatedAt})
+	}
+	versions = append(versions, postVersion{post.Title, post.Link, post.Content, post.UpdatedAt})
+
+	n := flagValue[int](fs, "revision")
+	if n == 0 {
+		n = len(revisions)
+	}
+	if n < 1 || n > len(revisions) {
+		return fmt.Errorf("post %d has revisions 1 to %d, not %d", id, len(revisions), n)
+	}
+	from, to := versions[n-1], versions[n]
+	raw := flagValue[bool](fs, "raw")
+	lines := diff.Text(from.text(a, raw), to.text(a, raw))
+
+	if a.json() {
+		out := diffJSON{PostID: id, Revision: n, From: from.updatedAt, To: to.updatedAt}
+		for _, l := range lines {
+			out.Lines = append(out.Lines, diffLineJSON{Op: l.Op.Prefix(), Text: l.Text})
+		}
+		return a.printJSON(out)
+	}
+
+	toName := "current"
+	if n < len(revisions) {
+		toName = fmt.Sprintf("revision %d", n+1)
+	}
+	w := a.stdout
+	if _, err := fmt.Fprintf(w, "--- revision %d (%s)\n+++ %s (%s)\n",
+		n, from.updatedAt.Format(time.RFC1123), toName, to.updatedAt.Format(time.RFC1123)); err != nil {
+		return err
+	}
+	color := a.isTerminal()
+	for i, hunk := range diff.Hunks(lines, 3) {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w, "…"); err != nil {
+				return err
+			}
+		}
+		for _, l := range hunk {
+			line := l.Op.Prefix() + l.Text
+			if color {
+				switch l.Op {
+				case diff.Delete:
+					line = "\x1b[31m" + line + "\x1b[0m"
+				case diff.Insert:
+					line = "\x1b[32m" + line + "\x1b[0m"
+				}
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runMarkRead(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	unread := flagValue[bool](fs, "unread")
+	feedArg := flagValue[string](fs, "feed")
+	all := flagValue[bool](fs, "all")
+
+	var (
+		n   int
+		err error
+	)
+	switch {
+	case all && feedArg == "" && len(args) == 0 && !unread:
+		n, err = a.db.MarkAllRead(ctx)
+	case feedArg != "" && !all && len(args) == 0 && !unread:
+		feed, ferr := findFeed(ctx, a, feedArg)
+		if ferr != nil {
+			return ferr
+		}
+		n, err = a.db.MarkFeedRead(ctx, feed.ID, time.Now())
+	case len(args) > 0 && !all && feedArg == "":
+		for _, arg := range args {
+			id, perr := strconv.Atoi(arg)
+			if perr != nil {
+				return errUsage
+			}
+			if unread {
+				err = a.db.MarkUnread(ctx, id)
+			} else {
+				err = a.db.MarkRead(ctx, id)
+			}
+			if err != nil {
+				return err
+			}
+			n++
+		}
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+
+	if a.json() {
+		return a.printJSON(map[string]int{"marked": n})
+	}
+	state := "read"
+	if unread {
+		state = "unread"
+	}
+	_, err = fmt.Fprintf(a.stdout, "marked %d posts %s\n", n, state)
+	return err
+}
+
+type importJSON struct {
+	Added      []string `json:"added"`
+	Duplicates []string `json:"duplicates"`
+}
+
+func runStar(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	remove := flagValue[bool](fs, "remove")
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return errUsage
+		}
+		if remove {
+			err = a.db.Unstar(ctx, id)
+		} else {
+			err = a.db.Star(ctx, id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.json() {
+		return a.printJSON(map[string]int{"starred": len(args)})
+	}
+	verb := "starred"
+	if remove {
+		verb = "unstarred"
+	}
+	_, err := fmt.Fprintf(a.stdout, "%s %d posts\n", verb, len(args))
+	return err
+}
+
+// pageJSON is one page of posts and the cursor for the next
+type pageJSON struct {
+	Posts []postJSON `json:"posts"`
+	Next  string     `json:"next,omitempty"`
+}
+
+func runStarred(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	posts, next, err := a.db.StarredPosts(ctx, flagValue[int](fs, "limit"), storage.Cursor(flagValue[string](fs, "after")))
+	if err != nil {
+		return err
+	}
+
+	if a.json() {
+		out := pageJSON{Posts: []postJSON{}, Next: string(next)}
+		for _, p := range posts {
+			out.Posts = append(out.Posts, toPostJSON(p))
+		}
+		return a.printJSON(out)
+	}
+
+	tw := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tSTARRED\tTITLE")
+	for _, p := range posts {
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\n", p.ID, p.StarredAt.Format(time.DateOnly), p.Title)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if next != "" {
+		_, err = fmt.Fprintf(a.stdout, "more: warss starred -after %s\n", next)
+	}
+	return err
+}
+
+func runExportStarred(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	var write func(io.Writer, []bookmarks.Bookmark) error
+	switch flagValue[string](fs, "as") {
+	case "html":
+		write = func(w io.Writer, marks []bookmarks.Bookmark) error {
+			return bookmarks.WriteHTML(w, "warss starred posts", marks)
+		}
+	case "json":
+		write = bookmarks.WriteJSON
+	default:
+		return errUsage
+	}
+	if len(args) > 1 {
+		return errUsage
+	}
+
+	marks, err := bookmarks.Starred(ctx, a.db)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return write(a.stdout, marks)
+	}
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := write(file, marks); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+type enclosureJSON struct {
+	ID         int    `json:"id"`
+	PostID     int    `json:"post_id"`
+	FeedID     int    `json:"feed_id"`
+	URL        string `json:"url"`
+	Type       string `json:"type,omitempty"`
+	Length     int64  `json:"length,omitempty"`
+	Duration   int64  `json:"duration_seconds,omitempty"`
+	State      string `json:"state"`
+	Path       string `json:"path,omitempty"`
+	Downloaded int64  `json:"downloaded"`
+	Error      string `json:"error,omitempty"`
+}
+
+func toEnclosureJSON(e models.Enclosure) enclosureJSON {
+	return enclosureJSON{
+		ID:         e.ID,
+		PostID:     e.PostID,
+		FeedID:     e.FeedID,
+		URL:        e.URL,
+		Type:       e.Type,
+		Length:     e.Length,
+		Duration:   int64(e.Duration.Seconds()),
+		State:      string(e.State),
+		Path:       e.Path,
+		Downloaded: e.Downloaded,
+		Error:      e.Error,
+	}
+}
+
+// formatSize prints a byte count the way people read file sizes
+func formatSize(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	size, suffix := float64(n), ""
+	for _, s := range []string{"kB", "MB", "GB", "TB"} {
+		size, suffix = size/unit, s
+		if size < unit {
+			break
+		}
+	}
+	return fmt.Sprintf("%.1f %s", size, suffix)
+}
+
+// enclosureQuery builds the -post and -feed selection shared by enclosures and download
+func enclosureQuery(ctx context.Context, a *app, fs *flag.FlagSet) (storage.EnclosureQuery, error) {
+	q := storage.EnclosureQuery{PostID: flagValue[int](fs, "post")}
+	if arg := flagValue[string](fs, "feed"); arg != "" {
+		feed, err := findFeed(ctx, a, arg)
+		if err != nil {
+			return q, err
+		}
+		q.FeedID = feed.ID
+	}
+	return q, nil
+}
+
+func runEnclosures(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	query, err := enclosureQuery(ctx, a, fs)
+	if err != nil {
+		return err
+	}
+	switch state := models.DownloadState(flagValue[string](fs, "state")); state {
+	case "":
+	case models.DownloadNone, models.DownloadQueued, models.DownloadActive, models.DownloadDone, models.DownloadFailed:
+		query.States = []models.DownloadState{state}
+	default:
+		return fmt.Errorf("unknown state %q", state)
+	}
+	enclosures, err := a.db.ListEnclosures(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	if a.json() {
+		out := []enclosureJSON{}
+		for _, e := range enclosures {
+			out = append(out, toEnclosureJSON(e))
+		}
+		return a.printJSON(out)
+	}
+
+	tw := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tPOST\tSTATE\tSIZE\tDURATION\tURL")
+	for _, e := range enclosures {
+		size := "-"
+		switch {
+		case e.State == models.DownloadDone:
+			size = formatSize(e.Downloaded)
+		case e.Downloaded > 0 && e.Length > 0:
+			size = formatSize(e.Downloaded) + " of " + formatSize(e.Length)
+		case e.Downloaded > 0:
+			size = formatSize(e.Downloaded)
+		case e.Length > 0:
+			size = formatSize(e.Length)
+		}
+		duration := "-"
+		if e.Duration > 0 {
+			duration = e.Duration.String()
+		}
+		_, _ = fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\n", e.ID, e.PostID, e.State, size, duration, e.URL)
+	}
+	return tw.Flush()
+}
+
+func runDownload(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	query, err := enclosureQuery(ctx, a, fs)
+	if err != nil {
+		return err
+	}
+
+	var enclosures []models.Enclosure
+	switch {
+	case len(args) > 0 && query.PostID == 0 && query.FeedID == 0:
+		for _, arg := range args {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				return errUsage
+			}
+			e, err := a.db.GetEnclosure(ctx, id)
+			if err != nil {
+				return err
+			}
+			enclosures = append(enclosures, e)
+		}
+	case len(args) > 0:
+		return errUsage
+	default:
+		if query.PostID == 0 && query.FeedID == 0 {
+			// Nothing picked, carry on with whatever was left unfinished
+			query.States = []models.DownloadState{models.DownloadQueued, models.DownloadActive, models.DownloadFailed}
+		}
+		if enclosures, err = a.db.ListEnclosures(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	manager := download.NewManager(a.db, flagValue[string](fs, "dir"))
+	manager.SetWorkers(flagValue[int](fs, "workers"))
+
+	// Progress only makes sense redrawn in place on a terminal
+	live := !a.json() && terminal(a.stderr)
+	var out []enclosureJSON
+	failed := 0
+	for p := range manager.Download(ctx, enclosures) {
+		if !p.Done {
+			if live {
+				progress := formatSize(p.Downloaded)
+				if p.Total > 0 {
+					progress = fmt.Sprintf("%s of %s (%d%%)", progress, formatSize(p.Total), p.Downloaded*100/p.Total)
+				}
+				_, _ = fmt.Fprintf(a.stderr, "\r\x1b[K⬇ %d %s", p.Enclosure.ID, progress)
+			}
+			continue
+		}
+		if live {
+			_, _ = fmt.Fprint(a.stderr, "\r\x1b[K")
+		}
+		if p.Err != nil {
+			failed++
+		}
+		if a.json() {
+			r := toEnclosureJSON(p.Enclosure)
+			if p.Err != nil {
+				r.Error = p.Err.Error()
+			}
+			out = append(out, r)
+			continue
+		}
+		if p.Err != nil {
+			_, _ = fmt.Fprintf(a.stdout, "✗ %d %s: %v\n", p.Enclosure.ID, p.Enclosure.URL, p.Err)
+		} else {
+			_, _ = fmt.Fprintf(a.stdout, "✅ %d %s (%s)\n", p.Enclosure.ID, p.Enclosure.Path, formatSize(p.Downloaded))
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if a.json() {
+		if out == nil {
+			out = []enclosureJSON{}
+		}
+		if err := a.printJSON(out); err != nil {
+			return err
+		}
+	} else if len(enclosures) == 0 {
+		_, _ = fmt.Fprintln(a.stdout, "nothing to download")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d downloads failed", failed, len(enclosures))
+	}
+	return nil
+}
+
+func runImport(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	res, err := opml.Import(ctx, a.db, file)
+	if err != nil {
+		return err
+	}
+
+	if a.json() {
+		out := importJSON{Added: []string{}, Duplicates: []string{}}
+		for _, s := range res.Added {
+			out.Added = append(out.Added, s.URL)
+		}
+		for _, s := range res.Duplicates {
+			out.Duplicates = append(out.Duplicates, s.URL)
+		}
+		return a.printJSON(out)
+	}
+	for _, s := range res.Duplicates {
+		_, _ = fmt.Fprintf(a.stdout, "skipped %s (already subscribed)\n", s.URL)
+	}
+	_, err = fmt.Fprintf(a.stdout, "imported %d feeds, %d duplicates\n", len(res.Added), len(res.Duplicates))
+	return err
+}
+
+func runExport(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	switch len(args) {
+	case 0:
+		return opml.Export(ctx, a.stdout, a.db)
+	case 1:
+	default:
+		return errUsage
+	}
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := opml.Export(ctx, file, a.db); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func runTUI(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	return tui.Run(ctx, a.db)
+}
+
+func runVersion(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if a.json() {
+		return a.printJSON(map[string]string{"version": a.version})
+	}
+	_, err := fmt.Fprintf(a.stdout, "warss %s\n", a.version)
+	return err
+}
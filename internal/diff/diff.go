@@ -0,0 +1,121 @@
+// Package diff compares two versions of a text line by line
+package diff
+
+import "strings"
+
+// Op says what happened to a line going from the old text to the new one
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Prefix is the marker unified diffs put in front of a line
+func (o Op) Prefix() string {
+	switch o {
+	case Delete:
+		return "-"
+	case Insert:
+		return "+"
+	default:
+		return " "
+	}
+}
+
+// Line is one line of a diff
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// Lines returns the shortest edit turning a into b, found through the longest
+// common subsequence. Posts are at most a few hundred lines, so the quadratic
+// table is fine.
+func Lines(a, b []string) []Line {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out []Line
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, Line{Equal, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, Line{Delete, a[i]})
+			i++
+		default:
+			out = append(out, Line{Insert, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, Line{Delete, a[i]})
+	}
+	for ; j < len(b); j++ {
+		out = append(out, Line{Insert, b[j]})
+	}
+	return out
+}
+
+// Text diffs two strings split into lines
+func Text(a, b string) []Line {
+	return Lines(strings.Split(a, "\n"), strings.Split(b, "\n"))
+}
+
+// Changed reports whether the diff has any insertions or deletions
+func Changed(lines []Line) bool {
+	for _, l := range lines {
+		if l.Op != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+// Hunks groups changed lines with up to context unchanged lines either side,
+// one slice per hunk. Unchanged stretches further from any change are dropped.
+func Hunks(lines []Line, context int) [][]Line {
+	keep := make([]bool, len(lines))
+	for i, l := range lines {
+		if l.Op == Equal {
+			continue
+		}
+		for k := max(i-context, 0); k <= min(i+context, len(lines)-1); k++ {
+			keep[k] = true
+		}
+	}
+
+	var hunks [][]Line
+	var cur []Line
+	for i, l := range lines {
+		if !keep[i] {
+			if cur != nil {
+				hunks = append(hunks, cur)
+				cur = nil
+			}
+			continue
+		}
+		cur = append(cur, l)
+	}
+	if cur != nil {
+		hunks = append(hunks, cur)
+	}
+	return hunks
+}
@@ -0,0 +1,95 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// render writes lines the way a unified diff would, for easy comparison
+func render(lines []Line) string {
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(l.Op.Prefix() + l.Text + "\n")
+	}
+	return b.String()
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "identical",
+			a:    "one\ntwo",
+			b:    "one\ntwo",
+			want: " one\n two\n",
+		},
+		{
+			name: "changed line",
+			a:    "one\ntwo\nthree",
+			b:    "one\n2\nthree",
+			want: " one\n-two\n+2\n three\n",
+		},
+		{
+			name: "appended",
+			a:    "one",
+			b:    "one\ntwo",
+			want: " one\n+two\n",
+		},
+		{
+			name: "removed from start",
+			a:    "zero\none\ntwo",
+			b:    "one\ntwo",
+			want: "-zero\n one\n two\n",
+		},
+		{
+			name: "empty old",
+			a:    "",
+			b:    "new",
+			want: "-\n+new\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := render(Text(tt.a, tt.b))
+			if got != tt.want {
+				t.Errorf("Text(%q, %q) =\n%s\nwant\n%s", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChanged(t *testing.T) {
+	if Changed(Text("a\nb", "a\nb")) {
+		t.Error("Changed() = true for identical text")
+	}
+	if !Changed(Text("a\nb", "a\nc")) {
+		t.Error("Changed() = false for different text")
+	}
+}
+
+func TestHunks(t *testing.T) {
+	a := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9"}
+	b := []string{"1", "two", "3", "4", "5", "6", "7", "8", "nine"}
+
+	hunks := Hunks(Lines(a, b), 1)
+	var got []string
+	for _, h := range hunks {
+		got = append(got, render(h))
+	}
+	want := []string{
+		" 1\n-2\n+two\n 3\n",
+		" 8\n-9\n+nine\n",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Hunks() = %q, want %q", got, want)
+	}
+
+	if hunks := Hunks(Lines(a, a), 3); hunks != nil {
+		t.Errorf("Hunks() of identical text = %v, want none", hunks)
+	}
+}
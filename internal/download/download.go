@@ -0,0 +1,339 @@
+// Package download fetches post enclosures, podcast episodes mostly, into a
+// directory. Interrupted downloads resume where they stopped with an HTTP
+// Range request, and each enclosure's progress is kept in the database.
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+	"github.com/pixel-87/warss/internal/storage"
+)
+
+// DefaultWorkers is how many enclosures Download fetches at once unless told otherwise
+const DefaultWorkers = 2
+
+// Progress is streamed by Download while an enclosure downloads and once
+// more, with Done set, when it finishes or fails
+type Progress struct {
+	Enclosure models.Enclosure
+	// Downloaded is how many bytes are on disk, Total how many are expected,
+	// 0 when neither the server nor the feed said
+	Downloaded int64
+	Total      int64
+	Done       bool
+	Err        error
+}
+
+// Manager downloads enclosures into a directory
+type Manager struct {
+	client  *http.Client
+	db      *storage.DB
+	dir     string
+	workers int
+	// interval is the least time between progress reports for one download
+	interval time.Duration
+}
+
+// NewManager returns a Manager saving into dir. Without a database nothing
+// is recorded, files still resume from what's on disk.
+func NewManager(db *storage.DB, dir string) *Manager {
+	// No overall timeout, an episode can take a long time to come down
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	return &Manager{
+		client:   &http.Client{Transport: transport},
+		db:       db,
+		dir:      dir,
+		workers:  DefaultWorkers,
+		interval: 250 * time.Millisecond,
+	}
+}
+
+// SetWorkers sets how many enclosures Download fetches in parallel, values below 1 mean 1
+func (m *Manager) SetWorkers(n int) {
+	m.workers = max(n, 1)
+}
+
+// Download fetches enclosures with at most the configured number of workers,
+// streaming progress as it goes. Each enclosure is queued first, so ones a
+// cancelled run never reached are still marked as wanted. The channel is
+// closed once every enclosure is done. Cancelling ctx stops the downloads in
+// flight, keeping what they fetched for next time.
+func (m *Manager) Download(ctx context.Context, enclosures []models.Enclosure) <-chan Progress {
+	results := make(chan Progress)
+	jobs := make(chan models.Enclosure)
+
+	var wg sync.WaitGroup
+	for range min(m.workers, max(len(enclosures), 1)) {
+		wg.Go(func() {
+			for e := range jobs {
+				res := m.downloadOne(ctx, e, results)
+				select {
+				case results <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+
+	go func() {
+		defer close(jobs)
+		var queued []models.Enclosure
+		for _, e := range enclosures {
+			if e.State != models.DownloadDone {
+				e.State, e.Error = models.DownloadQueued, ""
+				if err := m.save(ctx, e); err != nil {
+					select {
+					case results <- Progress{Enclosure: e, Done: true, Err: err}:
+					case <-ctx.Done():
+						return
+					}
+					continue
+				}
+			}
+			queued = append(queued, e)
+		}
+		for _, e := range queued {
+			select {
+			case jobs <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// save records e's download state when there's a database
+func (m *Manager) save(ctx context.Context, e models.Enclosure) error {
+	if m.db == nil {
+		return nil
+	}
+	return m.db.UpdateDownload(ctx, e)
+}
+
+// downloadOne fetches e, sending progress to results along the way, and
+// returns the final report
+func (m *Manager) downloadOne(ctx context.Context, e models.Enclosure, results chan<- Progress) Progress {
+	// Already fetched and still there
+	if e.State == models.DownloadDone && e.Path != "" {
+		if info, err := os.Stat(e.Path); err == nil {
+			return Progress{Enclosure: e, Downloaded: info.Size(), Total: info.Size(), Done: true}
+		}
+	}
+
+	// Recorded up front, so whatever this leaves on disk can be found again
+	e.Path = filepath.Join(m.dir, fileName(e))
+	e.State, e.Error = models.DownloadActive, ""
+	if err := m.save(ctx, e); err != nil {
+		return Progress{Enclosure: e, Done: true, Err: err}
+	}
+
+	var (
+		last  time.Time
+		total = e.Length
+	)
+	err := m.fetch(ctx, &e, func(downloaded, size int64) {
+		total = size
+		if time.Since(last) < m.interval {
+			return
+		}
+		last = time.Now()
+		select {
+		case results <- Progress{Enclosure: e, Downloaded: downloaded, Total: size}:
+		case <-ctx.Done():
+		}
+	})
+
+	switch {
+	case ctx.Err() != nil:
+		// Interrupted rather than failed, the next run carries on
+		e.State = models.DownloadQueued
+		err = ctx.Err()
+	case err != nil:
+		e.State, e.Error = models.DownloadFailed, err.Error()
+	default:
+		e.State = models.DownloadDone
+	}
+	// The state has to be written even when ctx is what stopped us
+	if serr := m.save(context.WithoutCancel(ctx), e); serr != nil && err == nil {
+		err = serr
+	}
+	return Progress{Enclosure: e, Downloaded: e.Downloaded, Total: total, Done: true, Err: err}
+}
+
+// fetch downloads e to e.Path, resuming from the part file left by an earlier
+// attempt. e.Downloaded follows what's on disk. report is called as bytes
+// arrive with the count so far and the expected total.
+func (m *Manager) fetch(ctx context.Context, e *models.Enclosure, report func(downloaded, total int64)) error {
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create download directory: %w", err)
+	}
+	final, part := e.Path, e.PartPath()
+
+	var offset int64
+	if info, err := os.Stat(part); err == nil {
+		offset = info.Size()
+	}
+	e.Downloaded = offset
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request for %s: %w", e.URL, err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", e.URL, err)
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			fmt.Printf("error closing response body %v", cerr)
+		}
+	}()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		start, _, err := contentRange(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			// Can't trust what we have to line up, start again next time
+			_ = os.Remove(part)
+			e.Downloaded = 0
+			return fmt.Errorf("server resumed %s at the wrong place: %q", e.URL, resp.Header.Get("Content-Range"))
+		}
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// Nothing past the end, the part may already be the whole file
+		if _, size, err := contentRange(resp.Header.Get("Content-Range")); err != nil || size != offset {
+			_ = os.Remove(part)
+			e.Downloaded = 0
+			return fmt.Errorf("server refused to resume %s: %s", e.URL, resp.Status)
+		}
+		report(offset, offset)
+		return finish(part, final)
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		// The server ignored the range and sent the whole file
+		offset = 0
+		flags |= os.O_TRUNC
+	default:
+		return fmt.Errorf("unexpected status fetching %s: %s", e.URL, resp.Status)
+	}
+
+	total := e.Length
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	f, err := os.OpenFile(part, flags, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", part, err)
+	}
+	w := &progressWriter{n: offset, total: total, report: report}
+	_, err = io.Copy(io.MultiWriter(f, w), resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	e.Downloaded = w.n
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", e.URL, err)
+	}
+	if resp.ContentLength >= 0 && w.n != total {
+		return fmt.Errorf("download of %s stopped at %d of %d bytes", e.URL, w.n, total)
+	}
+	return finish(part, final)
+}
+
+// finish moves a complete download into place
+func finish(part, final string) error {
+	if err := os.Rename(part, final); err != nil {
+		return fmt.Errorf("failed to move download into place: %w", err)
+	}
+	return nil
+}
+
+// progressWriter counts bytes written through it and reports the count
+type progressWriter struct {
+	n, total int64
+	report   func(downloaded, total int64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	w.report(w.n, w.total)
+	return len(p), nil
+}
+
+// contentRange reads the first byte and the full size from a Content-Range
+// header, "bytes 100-199/200" or "bytes */200". The size is -1 when the server
+// doesn't know it.
+func contentRange(header string) (start, size int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, 0, fmt.Errorf("bad Content-Range %q", header)
+	}
+	span, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("bad Content-Range %q", header)
+	}
+	size = -1
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("bad Content-Range %q: %w", header, err)
+		}
+	}
+	if span == "*" {
+		return 0, size, nil
+	}
+	first, _, ok := strings.Cut(span, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("bad Content-Range %q", header)
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("bad Content-Range %q: %w", header, err)
+	}
+	return start, size, nil
+}
+
+// fileName is where an enclosure is saved: its id, so names never collide,
+// then the name the URL gives it
+func fileName(e models.Enclosure) string {
+	name := ""
+	if u, err := url.Parse(e.URL); err == nil && u.Path != "" {
+		name = path.Base(u.Path)
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' {
+			return -1
+		}
+		return r
+	}, strings.TrimLeft(name, "."))
+	if name == "" {
+		name = "enclosure"
+		if exts, err := mime.ExtensionsByType(e.Type); err == nil && len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+	return strconv.Itoa(e.ID) + "-" + name
+}
@@ -0,0 +1,338 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+	"github.com/pixel-87/warss/internal/storage"
+)
+
+// episode is the file every test server hands out
+var episode = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+// setupEnclosures stores a post for each url and returns their enclosures
+func setupEnclosures(t *testing.T, urls ...string) (*storage.DB, []models.Enclosure) {
+	t.Helper()
+	db, err := storage.NewDB(filepath.Join(t.TempDir(), "rss.db"))
+	if err != nil {
+		t.Fatalf("failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err := db.AddFeed("https://podcast.example.com/feed.xml", "Podcast"); err != nil {
+		t.Fatalf("AddFeed() error = %v", err)
+	}
+	feeds, err := db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	feed := feeds[0]
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, u := range urls {
+		feed.Posts = append(feed.Posts, models.Post{
+			GUID:        fmt.Sprint(i),
+			Title:       fmt.Sprintf("Episode %d", i),
+			PublishedAt: start.Add(time.Duration(-i) * time.Hour),
+			Enclosures:  []models.Enclosure{{URL: u, Type: "audio/mpeg", Length: int64(len(episode))}},
+		})
+	}
+	if _, err := db.SaveFeed(context.Background(), feed); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	enclosures, err := db.ListEnclosures(context.Background(), storage.EnclosureQuery{})
+	if err != nil {
+		t.Fatalf("ListEnclosures() error = %v", err)
+	}
+	return db, enclosures
+}
+
+// collect drains a Download, returning the final report for each enclosure
+func collect(t *testing.T, progress <-chan Progress) map[int]Progress {
+	t.Helper()
+	done := make(map[int]Progress)
+	for p := range progress {
+		if p.Done {
+			done[p.Enclosure.ID] = p
+		}
+	}
+	return done
+}
+
+// serveEpisode serves episode with Range support, recording the Range headers
+func serveEpisode(ranges *[]string, mu *sync.Mutex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "episode.mp3", time.Time{}, bytes.NewReader(episode))
+	}
+}
+
+func TestDownload(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	srv := httptest.NewServer(serveEpisode(&ranges, &mu))
+	defer srv.Close()
+
+	db, enclosures := setupEnclosures(t, srv.URL+"/episodes/one.mp3", srv.URL+"/missing.mp3")
+	dir := t.TempDir()
+	m := NewManager(db, dir)
+
+	// The second episode has half a file from an interrupted run
+	resumed := enclosures[1]
+	part := filepath.Join(dir, fileName(resumed)) + ".part"
+	if err := os.WriteFile(part, episode[:1000], 0o644); err != nil {
+		t.Fatalf("failed to write part file: %v", err)
+	}
+
+	done := collect(t, m.Download(context.Background(), enclosures))
+	if len(done) != 2 {
+		t.Fatalf("got %d finished downloads, want 2", len(done))
+	}
+	for _, e := range enclosures {
+		res := done[e.ID]
+		if res.Err != nil {
+			t.Errorf("download of %s error = %v", e.URL, res.Err)
+			continue
+		}
+		got, err := os.ReadFile(res.Enclosure.Path)
+		if err != nil {
+			t.Fatalf("failed to read download: %v", err)
+		}
+		if !bytes.Equal(got, episode) {
+			t.Errorf("%s downloaded %d bytes that don't match the episode", e.URL, len(got))
+		}
+		stored, err := db.GetEnclosure(context.Background(), e.ID)
+		if err != nil {
+			t.Fatalf("GetEnclosure() error = %v", err)
+		}
+		if stored.State != models.DownloadDone || stored.Path != res.Enclosure.Path || stored.Downloaded != int64(len(episode)) {
+			t.Errorf("stored enclosure = %+v, want done at %s", stored, res.Enclosure.Path)
+		}
+	}
+	if _, err := os.Stat(part); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("part file still there after finishing: %v", err)
+	}
+	if !strings.HasSuffix(done[enclosures[0].ID].Enclosure.Path, "-one.mp3") {
+		t.Errorf("saved as %s, want the name from the url", done[enclosures[0].ID].Enclosure.Path)
+	}
+
+	mu.Lock()
+	if !slices.Contains(ranges, "bytes=1000-") || !slices.Contains(ranges, "") {
+		t.Errorf("requests asked for ranges %q, want one fresh and one from byte 1000", ranges)
+	}
+	ranges = nil
+	mu.Unlock()
+
+	// Finished downloads aren't fetched again
+	again, err := db.ListEnclosures(context.Background(), storage.EnclosureQuery{})
+	if err != nil {
+		t.Fatalf("ListEnclosures() error = %v", err)
+	}
+	collect(t, m.Download(context.Background(), again))
+	mu.Lock()
+	if len(ranges) != 0 {
+		t.Errorf("finished downloads were requested again: %q", ranges)
+	}
+	mu.Unlock()
+}
+
+func TestDownloadResumeEdgeCases(t *testing.T) {
+	tests := []struct {
+		name    string
+		part    []byte
+		handler http.HandlerFunc
+	}{
+		{
+			name: "Server ignores the range",
+			part: []byte("stale bytes"),
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write(episode)
+			},
+		},
+		{
+			name: "Part is already complete",
+			part: episode,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "episode.mp3", time.Time{}, bytes.NewReader(episode))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			db, enclosures := setupEnclosures(t, srv.URL+"/episode.mp3")
+			dir := t.TempDir()
+			part := filepath.Join(dir, fileName(enclosures[0])) + ".part"
+			if err := os.WriteFile(part, tt.part, 0o644); err != nil {
+				t.Fatalf("failed to write part file: %v", err)
+			}
+
+			res := collect(t, NewManager(db, dir).Download(context.Background(), enclosures))[enclosures[0].ID]
+			if res.Err != nil {
+				t.Fatalf("Download() error = %v", res.Err)
+			}
+			got, err := os.ReadFile(res.Enclosure.Path)
+			if err != nil {
+				t.Fatalf("failed to read download: %v", err)
+			}
+			if !bytes.Equal(got, episode) {
+				t.Errorf("downloaded %d bytes that don't match the episode", len(got))
+			}
+		})
+	}
+}
+
+func TestDownloadFailure(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	db, enclosures := setupEnclosures(t, srv.URL+"/gone.mp3")
+	res := collect(t, NewManager(db, t.TempDir()).Download(context.Background(), enclosures))[enclosures[0].ID]
+	if res.Err == nil {
+		t.Fatal("Download() of a missing file succeeded")
+	}
+	stored, err := db.GetEnclosure(context.Background(), enclosures[0].ID)
+	if err != nil {
+		t.Fatalf("GetEnclosure() error = %v", err)
+	}
+	if stored.State != models.DownloadFailed || !strings.Contains(stored.Error, "404") {
+		t.Errorf("stored enclosure = %+v, want failed with the status", stored)
+	}
+}
+
+// TestDownloadWorkers checks no more than the configured number of downloads
+// run at once
+func TestDownloadWorkers(t *testing.T) {
+	var inFlight, most atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := most.Load()
+			if n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write(episode)
+	}))
+	defer srv.Close()
+
+	var urls []string
+	for i := range 6 {
+		urls = append(urls, fmt.Sprintf("%s/%d.mp3", srv.URL, i))
+	}
+	db, enclosures := setupEnclosures(t, urls...)
+	m := NewManager(db, t.TempDir())
+	m.SetWorkers(2)
+
+	done := collect(t, m.Download(context.Background(), enclosures))
+	if len(done) != len(urls) {
+		t.Errorf("got %d finished downloads, want %d", len(done), len(urls))
+	}
+	if got := most.Load(); got > 2 {
+		t.Errorf("%d downloads ran at once, want at most 2", got)
+	}
+}
+
+// TestDownloadCancel checks an interrupted download goes back in the queue
+// with what it fetched kept for next time
+func TestDownloadCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(episode)))
+		_, _ = w.Write(episode[:2048])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	db, enclosures := setupEnclosures(t, srv.URL+"/slow.mp3")
+	dir := t.TempDir()
+	m := NewManager(db, dir)
+	m.interval = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for p := range m.Download(ctx, enclosures) {
+		if p.Downloaded >= 2048 {
+			cancel()
+		}
+	}
+
+	stored, err := db.GetEnclosure(context.Background(), enclosures[0].ID)
+	if err != nil {
+		t.Fatalf("GetEnclosure() error = %v", err)
+	}
+	if stored.State != models.DownloadQueued || stored.Downloaded != 2048 {
+		t.Errorf("stored enclosure = %+v, want queued with 2048 bytes", stored)
+	}
+	info, err := os.Stat(filepath.Join(dir, fileName(enclosures[0])) + ".part")
+	if err != nil || info.Size() != 2048 {
+		t.Errorf("part file = %v, %v, want 2048 bytes kept", info, err)
+	}
+}
+
+func TestContentRange(t *testing.T) {
+	tests := []struct {
+		header    string
+		wantStart int64
+		wantSize  int64
+		wantErr   bool
+	}{
+		{header: "bytes 100-199/200", wantStart: 100, wantSize: 200},
+		{header: "bytes 0-99/*", wantStart: 0, wantSize: -1},
+		{header: "bytes */200", wantStart: 0, wantSize: 200},
+		{header: "", wantErr: true},
+		{header: "bytes 100-199", wantErr: true},
+		{header: "bytes x-199/200", wantErr: true},
+	}
+	for _, tt := range tests {
+		start, size, err := contentRange(tt.header)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("contentRange(%q) accepted a bad header", tt.header)
+			}
+			continue
+		}
+		if err != nil || start != tt.wantStart || size != tt.wantSize {
+			t.Errorf("contentRange(%q) = %d, %d, %v, want %d, %d", tt.header, start, size, err, tt.wantStart, tt.wantSize)
+		}
+	}
+}
+
+func TestFileName(t *testing.T) {
+	tests := []struct {
+		enclosure models.Enclosure
+		want      string
+	}{
+		{models.Enclosure{ID: 1, URL: "https://cdn.example.com/shows/ep%201.mp3?token=x"}, "1-ep 1.mp3"},
+		{models.Enclosure{ID: 2, URL: "https://cdn.example.com/", Type: "audio/mpeg"}, "2-enclosure.mp3"},
+		{models.Enclosure{ID: 3, URL: "https://cdn.example.com/..%2f..%2fetc%2fpasswd"}, "3-passwd"},
+		{models.Enclosure{ID: 5, URL: `https://cdn.example.com/a\b.mp3`}, "5-ab.mp3"},
+		{models.Enclosure{ID: 4, URL: "https://cdn.example.com/.hidden"}, "4-hidden"},
+	}
+	for _, tt := range tests {
+		if got := fileName(tt.enclosure); got != tt.want {
+			t.Errorf("fileName(%q) = %q, want %q", tt.enclosure.URL, got, tt.want)
+		}
+	}
+}
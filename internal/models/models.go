@@ -1,19 +1,116 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"strings"
 	"time"
 )
 
 type Post struct {
-	ID          int
-	Title       string
-	Content     string
+	ID int
+	// GUID is the feed's own id for the item (RSS guid, Atom id), often empty
+	GUID    string
+	Title   string
+	Content string
+	// Summary is the feed's short description of the post, kept apart when
+	// the feed also gives the full content
+	Summary     string
 	Link        string
 	FeedID      int // feed id
 	PublishedAt time.Time
 	UpdatedAt   time.Time
-	Read        bool
+	// DateSource is where PublishedAt came from, empty for posts stored before
+	// it was recorded
+	DateSource DateSource
+	Read       bool
+	// UpdatedSinceRead is set when the feed changed the post after it was read
+	UpdatedSinceRead bool
+	// StarredAt is when the user starred the post, zero if they haven't
+	StarredAt time.Time
+	// Authors are the names the feed credits, Tags its categories or tags
+	Authors     []string
+	Tags        []string
+	ImageURL    string
+	CommentsURL string
+	// Language is the post's language tag, the feed's when it doesn't say
+	Language string
+	// Enclosures are files attached to the post, podcast episodes mostly.
+	// Only filled in by parsing, storage loads them on request.
+	Enclosures []Enclosure
+}
+
+// DownloadState is how far downloading an enclosure has got
+type DownloadState string
+
+const (
+	DownloadNone DownloadState = "none"
+	// DownloadQueued enclosures were asked for, or interrupted, and are
+	// picked up by the next download run
+	DownloadQueued DownloadState = "queued"
+	DownloadActive DownloadState = "downloading"
+	DownloadDone   DownloadState = "done"
+	DownloadFailed DownloadState = "failed"
+)
+
+// Enclosure is a file attached to a post and the state of its download
+type Enclosure struct {
+	ID     int
+	PostID int
+	FeedID int
+	URL    string
+	// Type is the MIME type and Length the size in bytes as the feed states
+	// them, Length is 0 when unknown
+	Type     string
+	Length   int64
+	Duration time.Duration
+	State    DownloadState
+	// Path is where the download goes, set once it has started and holding
+	// the finished file once State is DownloadDone. Downloaded is how many
+	// bytes are on disk so far.
+	Path       string
+	Downloaded int64
+	// Error is why the last download failed
+	Error string
+}
+
+// PartPath is where an unfinished download of the enclosure is kept
+func (e Enclosure) PartPath() string {
+	return e.Path + ".part"
+}
+
+// DateSource names the part of a feed item a post's date was taken from
+type DateSource string
+
+const (
+	DatePublished DateSource = "published"
+	DateUpdated   DateSource = "updated"
+	DateDC        DateSource = "dc:date"
+	// DateFirstSeen means the item had no usable date, so the time it was
+	// fetched stands in
+	DateFirstSeen DateSource = "first-seen"
+)
+
+// Byline lists the post's authors for display, empty when it has none
+func (p *Post) Byline() string {
+	return strings.Join(p.Authors, ", ")
+}
+
+// Starred reports whether the user saved the post to keep
+func (p *Post) Starred() bool {
+	return !p.StarredAt.IsZero()
+}
+
+// PostRevision is an earlier version of a post, kept when the feed edits it
+type PostRevision struct {
+	ID        int
+	PostID    int
+	Title     string
+	Link      string
+	Content   string
+	UpdatedAt time.Time
+	// ReplacedAt is when the newer version was stored over this one
+	ReplacedAt time.Time
 }
 
 // An entire Feed
@@ -22,10 +119,105 @@ type Feed struct {
 	URL   string
 	Posts []Post
 	ID    int
+	// HTTP validators from the last successful fetch, sent back as
+	// If-None-Match / If-Modified-Since so unchanged feeds can answer 304
+	ETag         string
+	LastModified string
+	// Unread is the unread post count as counted by storage, used when
+	// Posts hasn't been loaded
+	Unread int
+	// CategoryIDs are the categories the feed is filed under, if any
+	CategoryIDs []int
+	// SiteURL is the website the feed belongs to
+	SiteURL     string
+	Description string
+	// IconURL is a small square image for lists, LogoURL a larger one
+	IconURL  string
+	LogoURL  string
+	Language string
+	Health   FeedHealth
+	Schedule FeedSchedule
+	// Aliases are URLs the feed was subscribed at before it moved
+	Aliases []string
+}
+
+// FeedSchedule is when a feed is next worth fetching and the hints that was
+// worked out from
+type FeedSchedule struct {
+	// TTL is how long the feed says it may be cached, from RSS <ttl>
+	TTL time.Duration
+	// UpdatePeriod is how often the feed says it's updated, from
+	// sy:updatePeriod and sy:updateFrequency
+	UpdatePeriod time.Duration
+	// SkipHours (0-23, in UTC) and SkipDays are when the feed asks not to be
+	// fetched, from RSS <skipHours> and <skipDays>
+	SkipHours []int
+	SkipDays  []time.Weekday
+	// PostInterval is the typical time between the feed's posts, 0 when
+	// there are too few dated posts to tell
+	PostInterval time.Duration
+	// NextDueAt is when the feed should next be fetched, zero for right away
+	NextDueAt time.Time
+}
+
+// Due reports whether the feed should be fetched at now
+func (s FeedSchedule) Due(now time.Time) bool {
+	return !now.Before(s.NextDueAt)
+}
+
+// FeedHealth is how fetching a feed has been going
+type FeedHealth struct {
+	// LastFetchAt is the last attempt, whether or not it worked
+	LastFetchAt   time.Time
+	LastSuccessAt time.Time
+	// Errors counts the failed fetches since the last one that worked
+	Errors    int
+	LastError string
+	// LastStatus is the HTTP status of the last response, 0 if there wasn't one
+	LastStatus int
+	// RetryAt is when a failing feed is next worth fetching, zero while it works
+	RetryAt time.Time
+	// MovedTo is where the feed said it has permanently moved on the last
+	// fetch, empty when it wasn't redirected that way. MovedCount is how
+	// many fetches in a row have said so.
+	MovedTo    string
+	MovedCount int
+	// RedirectedToPage is set when the feed's URL redirects to a web page
+	// instead of a feed, usually a site that dropped its feed
+	RedirectedToPage bool
+	// LastNewPostAt is when a fetch last brought a post not seen before
+	LastNewPostAt time.Time
+}
+
+// Failing reports whether the last fetch of the feed failed
+func (h FeedHealth) Failing() bool {
+	return h.Errors > 0
+}
+
+// Stale reports whether the feed has gone quiet: nothing new for longer than
+// after, or never a post at all from a feed that fetches fine
+func (h FeedHealth) Stale(now time.Time, after time.Duration) bool {
+	if h.LastNewPostAt.IsZero() {
+		return !h.LastSuccessAt.IsZero()
+	}
+	return now.Sub(h.LastNewPostAt) > after
+}
+
+// Category is a folder of feeds. Categories nest, ParentID is 0 at the top.
+type Category struct {
+	ID       int
+	Name     string
+	ParentID int
+	// Unread counts the unread posts of every feed in the category and the
+	// categories below it, each feed once
+	Unread int
 }
 
 // HasUnreadPosts returns true if the feed has any unread posts
 func (f *Feed) HasUnreadPosts() bool {
+	if f.Posts == nil {
+		return f.Unread > 0
+	}
 	for _, post := range f.Posts {
 		if !post.Read {
 			return true
@@ -34,8 +226,12 @@ func (f *Feed) HasUnreadPosts() bool {
 	return false
 }
 
-// UnreadCount returns the number of unread posts in the feed
+// UnreadCount returns the number of unread posts in the feed, falling back
+// to the stored Unread count when Posts hasn't been loaded
 func (f *Feed) UnreadCount() int {
+	if f.Posts == nil {
+		return f.Unread
+	}
 	count := 0
 	for _, post := range f.Posts {
 		if !post.Read {
@@ -53,6 +249,8 @@ func (p *Post) IsValid() bool {
 // Sanitize returns a copy of the Post with trimmed whitespace
 func (p *Post) Sanitize() Post {
 	return Post{
+		ID:      p.ID,
+		GUID:    strings.TrimSpace(p.GUID),
 		FeedID:  p.FeedID,
 		Title:   strings.TrimSpace(p.Title),
 		Content: strings.TrimSpace(p.Content),
@@ -60,3 +258,20 @@ func (p *Post) Sanitize() Post {
 		Read:    p.Read,
 	}
 }
+
+// Identity is what tells a post apart from the other posts in its feed: the
+// GUID when the feed provides one, otherwise a hash of the link and title
+func (p *Post) Identity() string {
+	if guid := strings.TrimSpace(p.GUID); guid != "" {
+		return guid
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSpace(p.Link) + "\n" + strings.TrimSpace(p.Title)))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ContentHash fingerprints what a reader sees of the post, so an edit is
+// noticed even when the feed doesn't bump its updated date
+func (p *Post) ContentHash() string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(p.Title) + "\n" + strings.TrimSpace(p.Content)))
+	return hex.EncodeToString(sum[:])
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestFeedHasUnreadPosts tests the HasUnreadPosts method
@@ -46,6 +47,13 @@ func TestFeedHasUnreadPosts(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "Posts not loaded, stored count",
+			feed: Feed{
+				Unread: 3,
+			},
+			want: true,
+		},
 		{
 			name: "Feed with single unread post",
 			feed: Feed{
@@ -132,6 +140,23 @@ func TestFeedUnreadCount(t *testing.T) {
 			},
 			want: 0,
 		},
+		{
+			name: "Posts not loaded, stored count",
+			feed: Feed{
+				Unread: 7,
+			},
+			want: 7,
+		},
+		{
+			name: "Loaded posts win over stored count",
+			feed: Feed{
+				Unread: 7,
+				Posts: []Post{
+					{Title: "Post 1", Read: false},
+				},
+			},
+			want: 1,
+		},
 		{
 			name: "Large feed with many unread",
 			feed: Feed{
@@ -672,3 +697,100 @@ func TestPostComparison(t *testing.T) {
 		t.Errorf("post1.Read should not equal post3.Read")
 	}
 }
+
+// TestPostIdentity tests the GUID and hash fallback used to tell posts apart
+func TestPostIdentity(t *testing.T) {
+	withGUID := Post{GUID: "  urn:uuid:1234  ", Title: "Title", Link: "http://example.com"}
+	if got := withGUID.Identity(); got != "urn:uuid:1234" {
+		t.Errorf("Identity() = %q, want the trimmed GUID", got)
+	}
+
+	a := Post{Title: "Title", Link: "http://example.com"}
+	b := Post{Title: " Title ", Link: "http://example.com "}
+	c := Post{Title: "Other", Link: "http://example.com"}
+	if !strings.HasPrefix(a.Identity(), "sha256:") {
+		t.Errorf("Identity() = %q, want a sha256 fallback", a.Identity())
+	}
+	if a.Identity() != b.Identity() {
+		t.Errorf("surrounding whitespace changed the identity")
+	}
+	if a.Identity() == c.Identity() {
+		t.Errorf("different titles share an identity")
+	}
+
+}
+
+func TestPostContentHash(t *testing.T) {
+	a := Post{Title: "Title", Content: "<p>Body</p>", Link: "http://example.com/a"}
+	b := Post{Title: " Title", Content: "<p>Body</p>\n", Link: "http://example.com/b", Read: true}
+	c := Post{Title: "Title", Content: "<p>Body, edited</p>"}
+
+	if a.ContentHash() != b.ContentHash() {
+		t.Errorf("link, read state or surrounding whitespace changed the hash")
+	}
+	if a.ContentHash() == c.ContentHash() {
+		t.Errorf("edited content kept the same hash")
+	}
+}
+
+// TestPostByline tests authors are listed for display
+func TestPostByline(t *testing.T) {
+	tests := []struct {
+		authors []string
+		want    string
+	}{
+		{nil, ""},
+		{[]string{"Ada Lovelace"}, "Ada Lovelace"},
+		{[]string{"Marie Curie", "Pierre Curie"}, "Marie Curie, Pierre Curie"},
+	}
+	for _, tt := range tests {
+		p := Post{Authors: tt.authors}
+		if got := p.Byline(); got != tt.want {
+			t.Errorf("Byline() with %q = %q, want %q", tt.authors, got, tt.want)
+		}
+	}
+}
+
+// TestFeedHealthStale tests when a feed counts as gone quiet
+func TestFeedHealthStale(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	month := 30 * 24 * time.Hour
+	tests := []struct {
+		name   string
+		health FeedHealth
+		want   bool
+	}{
+		{name: "Never fetched", health: FeedHealth{}, want: false},
+		{name: "Fetches fine, never posted", health: FeedHealth{LastSuccessAt: now}, want: true},
+		{name: "Posted recently", health: FeedHealth{LastNewPostAt: now.Add(-time.Hour)}, want: false},
+		{name: "Quiet for two months", health: FeedHealth{LastNewPostAt: now.Add(-2 * month)}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.health.Stale(now, month); got != tt.want {
+				t.Errorf("Stale() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFeedScheduleDue(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		next time.Time
+		want bool
+	}{
+		{name: "Never scheduled", want: true},
+		{name: "Due earlier", next: now.Add(-time.Minute), want: true},
+		{name: "Due now", next: now, want: true},
+		{name: "Due later", next: now.Add(time.Minute), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (FeedSchedule{NextDueAt: tt.next}).Due(now); got != tt.want {
+				t.Errorf("Due() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
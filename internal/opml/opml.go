@@ -0,0 +1,269 @@
+// Package opml reads and writes OPML subscription lists so feeds can move
+// between warss and other readers.
+package opml
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+
+	"github.com/pixel-87/warss/internal/models"
+	"github.com/pixel-87/warss/internal/storage"
+)
+
+// Document is an OPML 1.0 or 2.0 file
+type Document struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    Head     `xml:"head"`
+	Body    Body     `xml:"body"`
+}
+
+type Head struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type Body struct {
+	Outlines []Outline `xml:"outline"`
+}
+
+// Outline is either a feed (it has an xmlUrl) or a folder holding more outlines
+type Outline struct {
+	Text    string `xml:"text,attr"`
+	Title   string `xml:"title,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	XMLURL  string `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL string `xml:"htmlUrl,attr,omitempty"`
+	URL     string `xml:"url,attr,omitempty"` // some OPML 1.0 writers use url instead of xmlUrl
+	// Description and Language are OPML 2.0 subscription attributes
+	Description string    `xml:"description,attr,omitempty"`
+	Language    string    `xml:"language,attr,omitempty"`
+	Outlines    []Outline `xml:"outline"`
+}
+
+// Subscription is a feed found in an OPML file, Folder is the path of
+// enclosing outlines from the top level down, empty for top level feeds
+type Subscription struct {
+	Title  string
+	URL    string
+	Folder []string
+}
+
+// Parse reads an OPML document
+func Parse(r io.Reader) (*Document, error) {
+	var doc Document
+	dec := xml.NewDecoder(r)
+	// OPML in the wild is often declared as something other than UTF-8,
+	// feed readers like to export ISO-8859-1 or windows-1252
+	dec.CharsetReader = charset.NewReaderLabel
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed parsing OPML: %w", err)
+	}
+	return &doc, nil
+}
+
+// Subscriptions flattens the outline tree into feeds, remembering which folders each sat in
+func (d *Document) Subscriptions() []Subscription {
+	var subs []Subscription
+	var walk func(outlines []Outline, folder []string)
+	walk = func(outlines []Outline, folder []string) {
+		for _, o := range outlines {
+			url := strings.TrimSpace(o.XMLURL)
+			if url == "" && o.Type == "rss" {
+				url = strings.TrimSpace(o.URL)
+			}
+			if url != "" {
+				subs = append(subs, Subscription{
+					Title:  o.title(),
+					URL:    url,
+					Folder: folder,
+				})
+				continue
+			}
+			// Anything without a feed url is a folder
+			walk(o.Outlines, append(folder[:len(folder):len(folder)], o.title()))
+		}
+	}
+	walk(d.Body.Outlines, nil)
+	return subs
+}
+
+func (o Outline) title() string {
+	if o.Text != "" {
+		return strings.TrimSpace(o.Text)
+	}
+	return strings.TrimSpace(o.Title)
+}
+
+// ImportResult reports what Import did with each subscription
+type ImportResult struct {
+	Added      []Subscription
+	Duplicates []Subscription
+}
+
+// Import adds every feed in an OPML document to the database. Feeds that are
+// already subscribed to are reported as duplicates rather than failing the import.
+func Import(ctx context.Context, db *storage.DB, r io.Reader) (ImportResult, error) {
+	doc, err := Parse(r)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	subs := doc.Subscriptions()
+	feeds := make([]models.Feed, len(subs))
+	for i, s := range subs {
+		feeds[i] = models.Feed{Title: s.Title, URL: s.URL}
+	}
+
+	added, err := db.AddFeeds(ctx, feeds)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	var res ImportResult
+	for i, s := range subs {
+		if added[i] {
+			res.Added = append(res.Added, s)
+		} else {
+			res.Duplicates = append(res.Duplicates, s)
+		}
+	}
+	if err := fileSubscriptions(ctx, db, subs); err != nil {
+		return ImportResult{}, err
+	}
+	return res, nil
+}
+
+// fileSubscriptions puts each subscription in the category its folders name,
+// creating categories as needed. Duplicates are filed too, so importing into
+// an existing database organises feeds that were already there.
+func fileSubscriptions(ctx context.Context, db *storage.DB, subs []Subscription) error {
+	feeds, err := db.GetFeedsContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list feeds for import: %w", err)
+	}
+	ids := make(map[string]int, len(feeds))
+	for _, f := range feeds {
+		ids[f.URL] = f.ID
+	}
+
+	categories := make(map[string]int)
+	for _, s := range subs {
+		feedID, ok := ids[s.URL]
+		if len(s.Folder) == 0 || !ok {
+			continue
+		}
+		path := strings.Join(s.Folder, "\x00")
+		categoryID, ok := categories[path]
+		if !ok {
+			if categoryID, err = db.EnsureCategoryPath(ctx, s.Folder); err != nil {
+				return err
+			}
+			categories[path] = categoryID
+		}
+		if err := db.AddFeedToCategory(ctx, feedID, categoryID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write encodes feeds as an OPML 2.0 document
+func Write(w io.Writer, title string, feeds []models.Feed) error {
+	outlines := make([]Outline, len(feeds))
+	for i, f := range feeds {
+		outlines[i] = feedOutline(f)
+	}
+	return write(w, title, outlines)
+}
+
+// WriteTree encodes feeds as an OPML 2.0 document with a folder for each
+// category. Feeds in several categories appear in each, feeds in none at the top.
+func WriteTree(w io.Writer, title string, feeds []models.Feed, categories []models.Category) error {
+	children := make(map[int][]models.Category)
+	for _, c := range categories {
+		children[c.ParentID] = append(children[c.ParentID], c)
+	}
+	filed := make(map[int][]models.Feed)
+	for _, f := range feeds {
+		for _, id := range f.CategoryIDs {
+			filed[id] = append(filed[id], f)
+		}
+	}
+
+	var folder func(id int) []Outline
+	folder = func(id int) []Outline {
+		var outlines []Outline
+		for _, c := range children[id] {
+			outlines = append(outlines, Outline{Text: c.Name, Title: c.Name, Outlines: folder(c.ID)})
+		}
+		for _, f := range filed[id] {
+			outlines = append(outlines, feedOutline(f))
+		}
+		return outlines
+	}
+
+	outlines := folder(0)
+	for _, f := range feeds {
+		if len(f.CategoryIDs) == 0 {
+			outlines = append(outlines, feedOutline(f))
+		}
+	}
+	return write(w, title, outlines)
+}
+
+func feedOutline(f models.Feed) Outline {
+	return Outline{
+		Text:        f.Title,
+		Title:       f.Title,
+		Type:        "rss",
+		XMLURL:      f.URL,
+		HTMLURL:     f.SiteURL,
+		Description: f.Description,
+		Language:    f.Language,
+	}
+}
+
+func write(w io.Writer, title string, outlines []Outline) error {
+	doc := Document{
+		Version: "2.0",
+		Head: Head{
+			Title:       title,
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+		Body: Body{Outlines: outlines},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("failed writing OPML: %w", err)
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed writing OPML: %w", err)
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return fmt.Errorf("failed writing OPML: %w", err)
+	}
+	return nil
+}
+
+// Export writes every subscription in the database as OPML, in folders
+// following their categories
+func Export(ctx context.Context, w io.Writer, db *storage.DB) error {
+	feeds, err := db.GetFeedsContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list feeds for export: %w", err)
+	}
+	categories, err := db.GetCategories(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list categories for export: %w", err)
+	}
+	return WriteTree(w, "warss subscriptions", feeds, categories)
+}
@@ -0,0 +1,188 @@
+package opml
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pixel-87/warss/internal/models"
+	"github.com/pixel-87/warss/internal/storage"
+)
+
+func TestSubscriptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		want     []Subscription
+		wantErr  bool
+	}{
+		{
+			name:     "Nested OPML 2.0",
+			filename: "nested.opml",
+			want: []Subscription{
+				{Title: "Ed's Blog", URL: "https://ed-thomas.dev/rss.xml"},
+				{Title: "Go Blog", URL: "https://go.dev/blog/feed.atom", Folder: []string{"Tech"}},
+				{Title: "SQLite News", URL: "https://sqlite.org/news.rss", Folder: []string{"Tech", "Databases"}},
+				{Title: "Show", URL: "https://example.com/podcast.xml", Folder: []string{"Podcasts"}},
+			},
+		},
+		{
+			name:     "OPML 1.0 with url attribute",
+			filename: "opml1.opml",
+			want: []Subscription{
+				{Title: "Legacy Feed", URL: "https://example.com/legacy.xml"},
+				{Title: "Modern Feed", URL: "https://example.com/modern.xml"},
+			},
+		},
+		{
+			name:     "Declared as ISO-8859-1",
+			filename: "latin1.opml",
+			want: []Subscription{
+				{Title: "Café crème", URL: "https://example.fr/café.xml", Folder: []string{"Actualités"}},
+				{Title: "Été à Zürich", URL: "https://example.ch/feed.xml"},
+			},
+		},
+		{
+			name:     "Malformed XML",
+			filename: "malformed.opml",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := os.Open(filepath.Join("testdata", tt.filename))
+			if err != nil {
+				t.Fatalf("couldn't open test file %s: %v", tt.filename, err)
+			}
+			defer func() { _ = file.Close() }()
+
+			doc, err := Parse(file)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Parse() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			got := doc.Subscriptions()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Subscriptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWriteRoundTrip checks exported OPML parses back to the same feeds
+func TestWriteRoundTrip(t *testing.T) {
+	feeds := []models.Feed{
+		{Title: "Ed's Blog", URL: "https://ed-thomas.dev/rss.xml"},
+		{Title: "<Escaped> & \"quoted\"", URL: "https://example.com/feed?a=1&b=2"},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, "Export", feeds); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	doc, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("Parse() of exported OPML error = %v", err)
+	}
+	if doc.Version != "2.0" || doc.Head.Title != "Export" {
+		t.Errorf("got version %q title %q, want 2.0 and Export", doc.Version, doc.Head.Title)
+	}
+
+	subs := doc.Subscriptions()
+	if len(subs) != len(feeds) {
+		t.Fatalf("got %d subscriptions, want %d", len(subs), len(feeds))
+	}
+	for i, s := range subs {
+		if s.Title != feeds[i].Title || s.URL != feeds[i].URL {
+			t.Errorf("subscription %d = %q %q, want %q %q", i, s.Title, s.URL, feeds[i].Title, feeds[i].URL)
+		}
+	}
+}
+
+// TestImport checks feeds are added and duplicates reported instead of failing
+func TestImport(t *testing.T) {
+	db, err := storage.NewDB(filepath.Join(t.TempDir(), "rss.db"))
+	if err != nil {
+		t.Fatalf("failed to create test database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	if err := db.AddFeed("https://go.dev/blog/feed.atom", "Already Here"); err != nil {
+		t.Fatalf("failed to add feed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join("testdata", "nested.opml"))
+	if err != nil {
+		t.Fatalf("couldn't read test file: %v", err)
+	}
+
+	res, err := Import(context.Background(), db, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if len(res.Added) != 3 {
+		t.Errorf("Import() added %d feeds, want 3", len(res.Added))
+	}
+	if len(res.Duplicates) != 1 || res.Duplicates[0].URL != "https://go.dev/blog/feed.atom" {
+		t.Errorf("Import() duplicates = %+v, want the Go blog", res.Duplicates)
+	}
+
+	// Importing the same file again only finds duplicates
+	res, err = Import(context.Background(), db, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("second Import() error = %v", err)
+	}
+	if len(res.Added) != 0 || len(res.Duplicates) != 4 {
+		t.Errorf("second Import() = %d added %d duplicates, want 0 and 4", len(res.Added), len(res.Duplicates))
+	}
+
+	// Folders became categories, the feed that was already there included,
+	// and importing twice didn't make them again
+	categories, err := db.GetCategories(context.Background())
+	if err != nil {
+		t.Fatalf("GetCategories() error = %v", err)
+	}
+	var names []string
+	for _, c := range categories {
+		names = append(names, c.Name)
+	}
+	if want := []string{"Databases", "Podcasts", "Tech"}; !slices.Equal(names, want) {
+		t.Errorf("categories = %v, want %v", names, want)
+	}
+
+	var buf bytes.Buffer
+	if err := Export(context.Background(), &buf, db); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	doc, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("Parse() of export error = %v", err)
+	}
+	folders := make(map[string]string)
+	for _, s := range doc.Subscriptions() {
+		folders[s.URL] = strings.Join(s.Folder, "/")
+	}
+	want := map[string]string{
+		"https://ed-thomas.dev/rss.xml":   "",
+		"https://go.dev/blog/feed.atom":   "Tech",
+		"https://sqlite.org/news.rss":     "Tech/Databases",
+		"https://example.com/podcast.xml": "Podcasts",
+	}
+	if !maps.Equal(folders, want) {
+		t.Errorf("exported folders = %v, want %v", folders, want)
+	}
+}
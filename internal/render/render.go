@@ -0,0 +1,502 @@
+// Package render turns the HTML found in feeds into wrapped, optionally
+// styled text for the terminal.
+package render
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// DefaultWidth is used when Options.Width is not set
+const DefaultWidth = 80
+
+// minWidth stops deeply nested quotes and lists from collapsing to nothing
+const minWidth = 10
+
+// Options control how HTML is laid out
+type Options struct {
+	// Width is the column to wrap at, DefaultWidth if zero
+	Width int
+	// Color enables ANSI bold, italic and friends, otherwise output is plain text
+	Color bool
+	// BaseURL resolves relative links and images, usually the post's own link
+	BaseURL string
+}
+
+// SGR parameters for the inline styles we support
+const (
+	sgrBold      = "1"
+	sgrDim       = "2"
+	sgrItalic    = "3"
+	sgrUnderline = "4"
+	sgrReverse   = "7"
+)
+
+type renderer struct {
+	opts  Options
+	base  *url.URL
+	links []string
+}
+
+// HTML renders feed HTML as terminal text. Links are numbered inline and
+// listed at the end, images become placeholders showing their alt text.
+func HTML(src string, opts Options) string {
+	if opts.Width <= 0 {
+		opts.Width = DefaultWidth
+	}
+	r := &renderer{opts: opts}
+	if opts.BaseURL != "" {
+		if u, err := url.Parse(opts.BaseURL); err == nil {
+			r.base = u
+		}
+	}
+
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(src), body)
+	if err != nil {
+		// The tokenizer only fails on reader errors, which strings.Reader never has
+		return src
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+
+	out := r.container(body, opts.Width)
+	if len(r.links) > 0 {
+		refs := make([]string, len(r.links))
+		for i, link := range r.links {
+			refs[i] = fmt.Sprintf("[%d]: %s", i+1, link)
+		}
+		out = append(out, r.style(strings.Join(refs, "\n"), sgrDim))
+	}
+	return strings.Join(out, "\n\n")
+}
+
+// container renders the children of n as a list of blocks. Runs of inline
+// content between block elements become wrapped paragraphs.
+func (r *renderer) container(n *html.Node, width int) []string {
+	width = max(width, minWidth)
+	var (
+		blocks []string
+		inline strings.Builder
+	)
+	flush := func() {
+		if text := r.paragraph(inline.String(), width); text != "" {
+			blocks = append(blocks, text)
+		}
+		inline.Reset()
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && isBlock(c.DataAtom) {
+			flush()
+			if b := r.block(c, width); b != "" {
+				blocks = append(blocks, b)
+			}
+			continue
+		}
+		r.inline(c, &inline, nil)
+	}
+	flush()
+	return blocks
+}
+
+func isBlock(a atom.Atom) bool {
+	switch a {
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer,
+		atom.Main, atom.Aside, atom.Nav, atom.Figure, atom.Figcaption, atom.Details, atom.Summary,
+		atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6,
+		atom.Ul, atom.Ol, atom.Li, atom.Dl, atom.Dt, atom.Dd,
+		atom.Blockquote, atom.Pre, atom.Table, atom.Hr,
+		atom.Script, atom.Style, atom.Head, atom.Template:
+		return true
+	}
+	return false
+}
+
+// block renders a single block level element
+func (r *renderer) block(n *html.Node, width int) string {
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Head, atom.Template:
+		return ""
+
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		var b strings.Builder
+		r.inlineChildren(n, &b, []string{sgrBold})
+		prefix := strings.Repeat("#", level) + " "
+		text := r.paragraph(b.String(), width-len(prefix))
+		if text == "" {
+			return ""
+		}
+		return indent(text, r.style(prefix, sgrBold), strings.Repeat(" ", len(prefix)))
+
+	case atom.Ul, atom.Ol:
+		return r.list(n, width)
+
+	case atom.Blockquote:
+		inner := strings.Join(r.container(n, width-2), "\n\n")
+		bar := r.style("│ ", sgrDim)
+		return indent(inner, bar, bar)
+
+	case atom.Pre:
+		return r.pre(n)
+
+	case atom.Table:
+		return r.table(n, width)
+
+	case atom.Hr:
+		return r.style(strings.Repeat("─", width), sgrDim)
+
+	case atom.Dl:
+		var blocks []string
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.DataAtom {
+			case atom.Dt:
+				var b strings.Builder
+				r.inlineChildren(c, &b, []string{sgrBold})
+				blocks = append(blocks, r.paragraph(b.String(), width))
+			case atom.Dd:
+				inner := strings.Join(r.container(c, width-4), "\n\n")
+				blocks = append(blocks, indent(inner, "    ", "    "))
+			}
+		}
+		return strings.Join(blocks, "\n")
+	}
+
+	return strings.Join(r.container(n, width), "\n\n")
+}
+
+// list renders ul/ol items with bullets or numbers, nested lists indent further
+func (r *renderer) list(n *html.Node, width int) string {
+	ordered := n.DataAtom == atom.Ol
+	num := 1
+	if start := attr(n, "start"); start != "" {
+		_, _ = fmt.Sscanf(start, "%d", &num)
+	}
+
+	var items []string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+			continue
+		}
+		marker := "• "
+		if ordered {
+			marker = fmt.Sprintf("%d. ", num)
+			num++
+		}
+		inner := strings.Join(r.container(c, width-len(marker)), "\n")
+		items = append(items, indent(inner, marker, strings.Repeat(" ", ansi.StringWidth(marker))))
+	}
+	return strings.Join(items, "\n")
+}
+
+// pre keeps code exactly as written, only tabs are expanded
+func (r *renderer) pre(n *html.Node) string {
+	var b strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+
+	code := strings.ReplaceAll(b.String(), "\t", "    ")
+	code = strings.TrimPrefix(code, "\n")
+	code = strings.TrimRight(code, "\n ")
+	if code == "" {
+		return ""
+	}
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		lines[i] = "    " + r.style(line, sgrDim)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// table lays cells out in aligned columns, wrapping cells when the table
+// would be wider than the available width
+func (r *renderer) table(n *html.Node, width int) string {
+	var (
+		rows   [][]string
+		header = -1
+	)
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.DataAtom {
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				walk(c)
+			case atom.Tr:
+				var cells []string
+				allHeader := true
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.DataAtom != atom.Td && cell.DataAtom != atom.Th {
+						continue
+					}
+					var b strings.Builder
+					var style []string
+					if cell.DataAtom == atom.Th {
+						style = []string{sgrBold}
+					} else {
+						allHeader = false
+					}
+					r.inlineChildren(cell, &b, style)
+					cells = append(cells, collapse(b.String()))
+				}
+				if len(cells) == 0 {
+					continue
+				}
+				if allHeader && len(rows) == 0 {
+					header = 0
+				}
+				rows = append(rows, cells)
+			case atom.Caption:
+				// captions are rare in feeds, keep them as a plain first row
+				var b strings.Builder
+				r.inlineChildren(c, &b, []string{sgrItalic})
+				rows = append(rows, []string{collapse(b.String())})
+			}
+		}
+	}
+	walk(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	cols := 0
+	for _, row := range rows {
+		cols = max(cols, len(row))
+	}
+	widths := make([]int, cols)
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], ansi.StringWidth(cell))
+		}
+	}
+
+	// Shrink the widest columns until everything fits beside the separators
+	sep := " │ "
+	avail := width - (cols-1)*len([]rune(sep))
+	for sum(widths) > avail {
+		widest := 0
+		for i := range widths {
+			if widths[i] > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= 3 {
+			break
+		}
+		widths[widest]--
+	}
+
+	var lines []string
+	for ri, row := range rows {
+		wrapped := make([][]string, cols)
+		height := 1
+		for i := range cols {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			wrapped[i] = strings.Split(ansi.Wrap(cell, widths[i], ""), "\n")
+			height = max(height, len(wrapped[i]))
+		}
+		for line := range height {
+			parts := make([]string, cols)
+			for i := range cols {
+				text := ""
+				if line < len(wrapped[i]) {
+					text = wrapped[i][line]
+				}
+				parts[i] = text + strings.Repeat(" ", max(widths[i]-ansi.StringWidth(text), 0))
+			}
+			lines = append(lines, strings.TrimRight(strings.Join(parts, r.style(sep, sgrDim)), " "))
+		}
+		if ri == header {
+			rule := make([]string, cols)
+			for i, w := range widths {
+				rule[i] = strings.Repeat("─", w)
+			}
+			lines = append(lines, r.style(strings.Join(rule, "─┼─"), sgrDim))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// inline writes the text of an inline node to b, styled with the SGR codes in style
+func (r *renderer) inline(n *html.Node, b *strings.Builder, style []string) {
+	switch n.Type {
+	case html.TextNode:
+		b.WriteString(r.style(collapseSpace(n.Data), style...))
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		b.WriteString("\n")
+	case atom.Img:
+		alt := strings.TrimSpace(attr(n, "alt"))
+		placeholder := "[image]"
+		if alt != "" {
+			placeholder = "[image: " + alt + "]"
+		}
+		b.WriteString(r.style(placeholder, with(style, sgrDim)...))
+	case atom.Strong, atom.B:
+		r.inlineChildren(n, b, with(style, sgrBold))
+	case atom.Em, atom.I, atom.Cite:
+		r.inlineChildren(n, b, with(style, sgrItalic))
+	case atom.U, atom.Ins:
+		r.inlineChildren(n, b, with(style, sgrUnderline))
+	case atom.Code, atom.Kbd, atom.Samp:
+		if r.opts.Color {
+			r.inlineChildren(n, b, with(style, sgrReverse))
+		} else {
+			b.WriteString("`")
+			r.inlineChildren(n, b, style)
+			b.WriteString("`")
+		}
+	case atom.A:
+		r.inlineChildren(n, b, with(style, sgrUnderline))
+		if href := r.resolve(attr(n, "href")); href != "" {
+			r.links = append(r.links, href)
+			b.WriteString(r.style(fmt.Sprintf("[%d]", len(r.links)), sgrDim))
+		}
+	case atom.Script, atom.Style:
+	default:
+		r.inlineChildren(n, b, style)
+	}
+}
+
+func (r *renderer) inlineChildren(n *html.Node, b *strings.Builder, style []string) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && isBlock(c.DataAtom) {
+			// Blocks inside inline elements (a <p> in an <a>) are flattened
+			b.WriteString(" ")
+			r.inlineChildren(c, b, style)
+			b.WriteString(" ")
+			continue
+		}
+		r.inline(c, b, style)
+	}
+}
+
+// paragraph collapses whitespace in inline text and wraps it to width,
+// explicit <br> line breaks are kept
+func (r *renderer) paragraph(text string, width int) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = collapse(line)
+		lines = append(lines, ansi.Wrap(line, max(width, minWidth), ""))
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// resolve makes href absolute against BaseURL, fragments and scripts are dropped
+func (r *renderer) resolve(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	if r.base != nil {
+		u = r.base.ResolveReference(u)
+	}
+	return u.String()
+}
+
+// style wraps s in SGR codes when colour is enabled. Surrounding spaces stay
+// outside the codes so they can still be collapsed and trimmed.
+func (r *renderer) style(s string, codes ...string) string {
+	core := strings.Trim(s, " ")
+	if !r.opts.Color || len(codes) == 0 || core == "" {
+		return s
+	}
+	lead := s[:strings.Index(s, core)]
+	trail := s[len(lead)+len(core):]
+	return lead + "\x1b[" + strings.Join(codes, ";") + "m" + core + "\x1b[0m" + trail
+}
+
+// with returns a copy of style with code added, so siblings never share a backing array
+func with(style []string, code string) []string {
+	return append(style[:len(style):len(style)], code)
+}
+
+// indent prefixes the first line of s with first and the rest with rest
+func indent(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		p := rest
+		if i == 0 {
+			p = first
+		}
+		if line == "" {
+			lines[i] = strings.TrimRight(p, " ")
+			continue
+		}
+		lines[i] = p + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// collapseSpace turns every run of HTML whitespace into a single space
+func collapseSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// collapse is collapseSpace across styled segments, also trimming the ends
+func collapse(s string) string {
+	for strings.Contains(s, "  ") {
+		s = strings.ReplaceAll(s, "  ", " ")
+	}
+	return strings.TrimSpace(s)
+}
+
+func sum(xs []int) int {
+	total := 0
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
@@ -0,0 +1,133 @@
+package render
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// TestGoldenFeeds renders every post of the rss package's test feeds and
+// compares the result with testdata/<feed>.golden
+func TestGoldenFeeds(t *testing.T) {
+	feeds := []string{
+		"test_feed.xml",
+		"test_fallback.xml",
+		"unicode_feed.xml",
+		"large_feed.xml",
+		"rich_content.xml",
+	}
+
+	for _, name := range feeds {
+		t.Run(name, func(t *testing.T) {
+			file, err := os.Open(filepath.Join("..", "rss", "testdata", name))
+			if err != nil {
+				t.Fatalf("couldn't open test feed: %v", err)
+			}
+			defer func() { _ = file.Close() }()
+
+			feed, err := gofeed.NewParser().Parse(file)
+			if err != nil {
+				t.Fatalf("couldn't parse test feed: %v", err)
+			}
+
+			var b strings.Builder
+			for _, item := range feed.Items {
+				content := item.Content
+				if content == "" {
+					content = item.Description
+				}
+				b.WriteString("=== " + item.Title + " ===\n")
+				b.WriteString(HTML(content, Options{Width: 60, BaseURL: item.Link}))
+				b.WriteString("\n\n")
+			}
+			got := b.String()
+
+			golden := filepath.Join("testdata", strings.TrimSuffix(name, ".xml")+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatalf("couldn't write golden file: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("couldn't read golden file (run with -update to create it): %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("render mismatch for %s\n--- got ---\n%s\n--- want ---\n%s", name, got, want)
+			}
+		})
+	}
+}
+
+func TestHTMLInline(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		opts Options
+		want string
+	}{
+		{
+			name: "Whitespace collapses",
+			src:  "<p>  lots   of\n\n space </p>",
+			want: "lots of space",
+		},
+		{
+			name: "Paragraphs are separated by a blank line",
+			src:  "<p>One</p><p>Two</p>",
+			want: "One\n\nTwo",
+		},
+		{
+			name: "Wraps at width",
+			src:  "<p>aaa bbb ccc ddd eee</p>",
+			opts: Options{Width: 10},
+			want: "aaa bbb\nccc ddd\neee",
+		},
+		{
+			name: "Links are numbered and listed",
+			src:  `<a href="https://a.example">A</a> and <a href="/b">B</a>`,
+			opts: Options{BaseURL: "https://site.example/post"},
+			want: "A[1] and B[2]\n\n[1]: https://a.example\n[2]: https://site.example/b",
+		},
+		{
+			name: "Image placeholder",
+			src:  `<img src="x.png" alt=" A diagram ">`,
+			want: "[image: A diagram]",
+		},
+		{
+			name: "Color styles bold",
+			src:  "<b>hi</b> there",
+			opts: Options{Color: true},
+			want: "\x1b[1mhi\x1b[0m there",
+		},
+		{
+			name: "Nested styles combine",
+			src:  "<i>a <b>b</b> c</i>",
+			opts: Options{Color: true},
+			want: "\x1b[3ma\x1b[0m \x1b[3;1mb\x1b[0m \x1b[3mc\x1b[0m",
+		},
+		{
+			name: "Plain text passes through",
+			src:  "Hello World",
+			want: "Hello World",
+		},
+		{
+			name: "Empty",
+			src:  "",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HTML(tt.src, tt.opts); got != tt.want {
+				t.Errorf("HTML() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package rss
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// maxFutureSkew is how far ahead of our clock a post may be dated before it's
+// treated as misdated. Feeds that schedule posts or get their zone wrong would
+// otherwise pin those posts to the top of every list.
+const maxFutureSkew = 10 * time.Minute
+
+// dateLayouts are the formats parseDate tries, RFC 822 style dates from RSS
+// and ISO 8601 ones from Atom and Dublin Core. Layouts without an offset
+// parse as UTC.
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"Mon, 2 Jan 2006 15:04 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC822Z,
+	time.RFC822,
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC850,
+	time.UnixDate,
+	time.RubyDate,
+	time.ANSIC,
+}
+
+// zoneOffsets are abbreviations feeds use in place of a numeric offset. Go
+// only knows the abbreviations of the local zone and reads the rest as UTC,
+// so they're swapped for the offset before parsing. Abbreviations shared by
+// zones far apart, like IST (India, Ireland, Israel) or BST (Britain,
+// Bangladesh), are left out rather than guessed at. CST stays as RFC 822's
+// US Central time.
+var zoneOffsets = map[string]string{
+	"EST": "-0500", "EDT": "-0400",
+	"CST": "-0600", "CDT": "-0500",
+	"MST": "-0700", "MDT": "-0600",
+	"PST": "-0800", "PDT": "-0700",
+	"AKST": "-0900", "AKDT": "-0800",
+	"HST": "-1000",
+	"CET": "+0100", "CEST": "+0200",
+	"EET": "+0200", "EEST": "+0300",
+	"MSK": "+0300",
+	"JST": "+0900", "KST": "+0900",
+	"AEST": "+1000", "AEDT": "+1100",
+	"NZST": "+1200", "NZDT": "+1300",
+}
+
+// utcZones are the abbreviations that really do mean a zero offset
+var utcZones = []string{"UTC", "GMT", "UT", "Z"}
+
+// errUnknownZone means a date's zone abbreviation isn't one we can place
+var errUnknownZone = errors.New("unknown time zone")
+
+// numericZone replaces a trailing zone abbreviation with its offset
+func numericZone(s string) string {
+	i := strings.LastIndexByte(s, ' ')
+	if i < 0 {
+		return s
+	}
+	if offset, ok := zoneOffsets[strings.ToUpper(s[i+1:])]; ok {
+		return s[:i+1] + offset
+	}
+	return s
+}
+
+// parseDate reads a feed date into UTC. A date in a zone abbreviation that's
+// neither in zoneOffsets nor UTC is an error rather than hours out.
+func parseDate(s string) (time.Time, error) {
+	s = numericZone(strings.TrimSpace(s))
+	for _, layout := range dateLayouts {
+		// In UTC rather than the local zone, so which abbreviations are known
+		// doesn't depend on where warss runs
+		t, err := time.ParseInLocation(layout, s, time.UTC)
+		if err != nil {
+			continue
+		}
+		// Go reads an abbreviation it doesn't know as a zero offset
+		if name, offset := t.Zone(); strings.Contains(layout, "MST") && offset == 0 && !slices.Contains(utcZones, name) {
+			return time.Time{}, fmt.Errorf("%w %q in %q", errUnknownZone, name, s)
+		}
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date %q", s)
+}
+
+// itemDate reads one of an item's dates. gofeed has already parsed most of
+// them, but into UTC with any abbreviated zone taken as UTC, so the raw text
+// comes first and gofeed's reading is the fallback for formats we don't know.
+// A zone we can't place isn't read at all, gofeed would take it as UTC too.
+func itemDate(raw string, parsed *time.Time) (time.Time, bool) {
+	if raw == "" {
+		return time.Time{}, false
+	}
+	t, err := parseDate(raw)
+	switch {
+	case err == nil:
+		return t, true
+	case errors.Is(err, errUnknownZone):
+		return time.Time{}, false
+	}
+	if parsed != nil && !parsed.IsZero() {
+		return parsed.UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// dcDate is the item's Dublin Core dc:date, empty without one
+func dcDate(item *gofeed.Item) string {
+	if item.DublinCoreExt != nil && len(item.DublinCoreExt.Date) > 0 {
+		return item.DublinCoreExt.Date[0]
+	}
+	// Only RSS items get DublinCoreExt filled in, Atom keeps it raw
+	if dates := item.Extensions["dc"]["date"]; len(dates) > 0 {
+		return dates[0].Value
+	}
+	return ""
+}
+
+// resolveDate picks the date a post is filed under: the item's published
+// date, then its updated date, then Dublin Core dc:date, then now, the time
+// we first saw it. Dates too far in the future are replaced with now as well.
+func resolveDate(item *gofeed.Item, now time.Time) (time.Time, models.DateSource) {
+	now = now.UTC()
+	candidates := []struct {
+		raw    string
+		parsed *time.Time
+		source models.DateSource
+	}{
+		{item.Published, item.PublishedParsed, models.DatePublished},
+		{item.Updated, item.UpdatedParsed, models.DateUpdated},
+		{dcDate(item), nil, models.DateDC},
+	}
+	for _, c := range candidates {
+		at, ok := itemDate(c.raw, c.parsed)
+		if !ok {
+			continue
+		}
+		if at.After(now.Add(maxFutureSkew)) {
+			return now, models.DateFirstSeen
+		}
+		return at, c.source
+	}
+	return now, models.DateFirstSeen
+}
+
+// updatedDate is an item's updated date in UTC, zero when it has none and
+// clamped to now when it claims to be from the future
+func updatedDate(item *gofeed.Item, now time.Time) time.Time {
+	at, ok := itemDate(item.Updated, item.UpdatedParsed)
+	if !ok {
+		return time.Time{}
+	}
+	if at.After(now.Add(maxFutureSkew)) {
+		return now.UTC()
+	}
+	return at
+}
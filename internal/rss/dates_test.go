@@ -0,0 +1,157 @@
+package rss
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// TestParseFeedDates checks where each fixture item's date is taken from
+func TestParseFeedDates(t *testing.T) {
+	type want struct {
+		published time.Time
+		source    models.DateSource
+	}
+	// A zero published time means now, the time the feed was parsed
+	tests := []struct {
+		filename string
+		want     map[string]want
+	}{
+		{
+			filename: "dates.xml",
+			want: map[string]want{
+				"Pub date":            {time.Date(2024, 3, 5, 13, 30, 0, 0, time.UTC), models.DatePublished},
+				"Dublin Core only":    {time.Date(2024, 3, 4, 7, 15, 0, 0, time.UTC), models.DateDC},
+				"Unreadable pub date": {time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC), models.DateDC},
+				"Zone abbreviation":   {time.Date(2024, 3, 4, 15, 0, 0, 0, time.UTC), models.DatePublished},
+				"Unknown zone":        {time.Date(2024, 3, 6, 3, 30, 0, 0, time.UTC), models.DateDC},
+				"No offset":           {time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC), models.DatePublished},
+				"From the future":     {time.Time{}, models.DateFirstSeen},
+				"No date":             {time.Time{}, models.DateFirstSeen},
+			},
+		},
+		{
+			filename: "dates_atom.xml",
+			want: map[string]want{
+				"Published and updated": {time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC), models.DatePublished},
+				"Updated only":          {time.Date(2024, 3, 2, 13, 15, 0, 0, time.UTC), models.DateUpdated},
+				"No date":               {time.Time{}, models.DateFirstSeen},
+			},
+		},
+	}
+
+	f := NewFetcher(nil)
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			content, err := os.ReadFile(filepath.Join("testdata", tt.filename))
+			if err != nil {
+				t.Fatalf("couldn't read test file %s: %v", tt.filename, err)
+			}
+			before := time.Now().UTC()
+			res, err := f.parseFeed("http://test.com", content)
+			if err != nil {
+				t.Fatalf("parseFeed() unexpected error: %v", err)
+			}
+			after := time.Now().UTC()
+
+			if len(res.Posts) != len(tt.want) {
+				t.Fatalf("got %d posts, want %d", len(res.Posts), len(tt.want))
+			}
+			for _, p := range res.Posts {
+				w, ok := tt.want[p.Title]
+				if !ok {
+					t.Errorf("unexpected post %q", p.Title)
+					continue
+				}
+				if p.DateSource != w.source {
+					t.Errorf("%q date source = %q, want %q", p.Title, p.DateSource, w.source)
+				}
+				if p.PublishedAt.Location() != time.UTC {
+					t.Errorf("%q published in %v, want UTC", p.Title, p.PublishedAt.Location())
+				}
+				if w.published.IsZero() {
+					if p.PublishedAt.Before(before) || p.PublishedAt.After(after) {
+						t.Errorf("%q published %v, want the time it was parsed", p.Title, p.PublishedAt)
+					}
+					continue
+				}
+				if !p.PublishedAt.Equal(w.published) {
+					t.Errorf("%q published %v, want %v", p.Title, p.PublishedAt, w.published)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "Mon, 01 Jul 2024 09:00:00 PDT", want: time.Date(2024, 7, 1, 16, 0, 0, 0, time.UTC)},
+		{in: "Mon, 1 Jul 2024 09:00:00 +0100", want: time.Date(2024, 7, 1, 8, 0, 0, 0, time.UTC)},
+		{in: "Mon, 01 Jul 2024 09:00:00 GMT", want: time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)},
+		{in: "2024-07-01T09:00:00.5+02:00", want: time.Date(2024, 7, 1, 7, 0, 0, 5e8, time.UTC)},
+		{in: "2024-07-01 09:00:00", want: time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)},
+		{in: "2024-07-01", want: time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{in: "Mon, 01 Jul 2024 09:00:00 IST", wantErr: true},
+		{in: "Mon, 01 Jul 2024 09:00:00 BST", wantErr: true},
+		{in: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseDate(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseDate(%q) = %v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDate(%q) error = %v", tt.in, err)
+			}
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("parseDate(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNumericZoneAmbiguous checks abbreviations that mean different offsets in
+// different countries aren't swapped for one of them
+func TestNumericZoneAmbiguous(t *testing.T) {
+	for _, in := range []string{"Mon, 01 Jul 2024 09:00:00 IST", "Mon, 01 Jul 2024 09:00:00 BST"} {
+		if got := numericZone(in); got != in {
+			t.Errorf("numericZone(%q) = %q, want it unchanged", in, got)
+		}
+	}
+}
+
+func TestUpdatedDate(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		updated string
+		want    time.Time
+	}{
+		{name: "None", updated: "", want: time.Time{}},
+		{name: "A minute ahead is kept", updated: "2024-03-01T12:01:00Z", want: now.Add(time.Minute)},
+		{name: "A day ahead is clamped", updated: "2024-03-02T12:00:00Z", want: now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := updatedDate(&gofeed.Item{Updated: tt.updated}, now); !got.Equal(tt.want) {
+				t.Errorf("updatedDate(%q) = %v, want %v", tt.updated, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package rss
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+)
+
+// ErrNoFeedFound is returned by Discover when a page neither is nor links to a feed
+var ErrNoFeedFound = errors.New("no feed found")
+
+// maxPageSize caps how much of a web page Discover reads
+const maxPageSize = 5 << 20
+
+// feedTypes are the <link type> values that point at a feed
+var feedTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/atom+xml":  true,
+	"application/feed+json": true,
+}
+
+// commonFeedPaths are tried against the site root when a page has no <link> tags
+var commonFeedPaths = []string{
+	"/feed",
+	"/rss.xml",
+	"/atom.xml",
+	"/feed.xml",
+	"/index.xml",
+	"/rss",
+	"/feed.json",
+}
+
+// Candidate is a feed found while looking at a URL
+type Candidate struct {
+	URL   string
+	Title string
+}
+
+// Discover finds the feeds a URL offers. A URL that already is a feed is the
+// only candidate. For an HTML page its <link rel="alternate"> tags are used,
+// falling back to probing common feed paths on the same site.
+func (f *Fetcher) Discover(ctx context.Context, pageURL string) ([]Candidate, error) {
+	body, final, err := f.fetchPage(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if gofeed.DetectFeedType(bytes.NewReader(body)) != gofeed.FeedTypeUnknown {
+		feed, err := f.parseFeed(pageURL, body)
+		if err != nil {
+			return nil, err
+		}
+		return []Candidate{{URL: pageURL, Title: feed.Title}}, nil
+	}
+
+	candidates, err := linkedFeeds(body, final)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		candidates = f.probeFeeds(ctx, final)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w at %s", ErrNoFeedFound, pageURL)
+	}
+	return candidates, nil
+}
+
+// fetchPage downloads a page without conditional headers and reports the URL
+// it ended up at after redirects, which relative links resolve against
+func (f *Fetcher) fetchPage(ctx context.Context, pageURL string) ([]byte, *url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build request for %s: %w", pageURL, err)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch URL %s: %w", pageURL, err)
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			fmt.Printf("error closing response body %v", cerr)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, fmt.Errorf("unexpected status fetching %s: %s", pageURL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return body, resp.Request.URL, nil
+}
+
+// linkedFeeds reads the feed <link> tags out of an HTML page
+func linkedFeeds(body []byte, page *url.URL) ([]Candidate, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing page %s: %w", page, err)
+	}
+
+	base := page
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u, err := page.Parse(href); err == nil {
+			base = u
+		}
+	}
+
+	var candidates []Candidate
+	seen := make(map[string]bool)
+	doc.Find("link[href]").Each(func(_ int, s *goquery.Selection) {
+		rel := strings.Fields(strings.ToLower(s.AttrOr("rel", "")))
+		if !slices.Contains(rel, "alternate") && !slices.Contains(rel, "feed") {
+			return
+		}
+		typ := strings.ToLower(strings.TrimSpace(s.AttrOr("type", "")))
+		if !feedTypes[typ] {
+			return
+		}
+		u, err := base.Parse(strings.TrimSpace(s.AttrOr("href", "")))
+		if err != nil || seen[u.String()] {
+			return
+		}
+		seen[u.String()] = true
+		candidates = append(candidates, Candidate{
+			URL:   u.String(),
+			Title: strings.TrimSpace(s.AttrOr("title", "")),
+		})
+	})
+	return candidates, nil
+}
+
+// probeFeeds tries the usual feed locations on the page's site, keeping the ones that parse
+func (f *Fetcher) probeFeeds(ctx context.Context, page *url.URL) []Candidate {
+	var candidates []Candidate
+	for _, path := range commonFeedPaths {
+		if ctx.Err() != nil {
+			return candidates
+		}
+		u := page.ResolveReference(&url.URL{Path: path}).String()
+		body, _, err := f.fetchPage(ctx, u)
+		if err != nil || gofeed.DetectFeedType(bytes.NewReader(body)) == gofeed.FeedTypeUnknown {
+			continue
+		}
+		feed, err := f.parseFeed(u, body)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, Candidate{URL: u, Title: feed.Title})
+		// /feed and /rss usually serve the same thing as the .xml names, one is enough
+		break
+	}
+	return candidates
+}
@@ -0,0 +1,149 @@
+package rss
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiscover(t *testing.T) {
+	feed, err := os.ReadFile(filepath.Join("testdata", "test_feed.xml"))
+	if err != nil {
+		t.Fatalf("couldn't read test file: %v", err)
+	}
+
+	pages := map[string]string{
+		"/blog/": `<html><head>
+			<title>Blog</title>
+			<link rel="stylesheet" href="/style.css">
+			<link rel="alternate" type="application/rss+xml" title="Posts" href="posts.xml">
+			<link rel="alternate" type="application/atom+xml" title="Atom" href="/atom.xml">
+			<link rel="alternate" type="application/feed+json" title="JSON" href="https://elsewhere.example/feed.json">
+			<link rel="alternate" type="text/html" hreflang="fr" href="/fr/">
+			<link rel="alternate" type="application/rss+xml" title="Dupe" href="/blog/posts.xml">
+		</head><body></body></html>`,
+		"/based/": `<html><head>
+			<base href="/assets/">
+			<link rel="alternate" type="application/rss+xml" href="feed.rss">
+		</head></html>`,
+		"/bare/": `<html><head><title>No links</title></head><body>hi</body></html>`,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if page, ok := pages[r.URL.Path]; ok {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(page))
+			return
+		}
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/direct.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(feed)
+	})
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/blog/", http.StatusMovedPermanently)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		want    []Candidate
+		wantErr error
+	}{
+		{
+			name: "URL is already a feed",
+			path: "/direct.xml",
+			want: []Candidate{{URL: srv.URL + "/direct.xml", Title: "Test Blog"}},
+		},
+		{
+			name: "Link tags",
+			path: "/blog/",
+			want: []Candidate{
+				{URL: srv.URL + "/blog/posts.xml", Title: "Posts"},
+				{URL: srv.URL + "/atom.xml", Title: "Atom"},
+				{URL: "https://elsewhere.example/feed.json", Title: "JSON"},
+			},
+		},
+		{
+			name: "Relative to the page after a redirect",
+			path: "/old",
+			want: []Candidate{
+				{URL: srv.URL + "/blog/posts.xml", Title: "Posts"},
+				{URL: srv.URL + "/atom.xml", Title: "Atom"},
+				{URL: "https://elsewhere.example/feed.json", Title: "JSON"},
+			},
+		},
+		{
+			name: "Base href",
+			path: "/based/",
+			want: []Candidate{{URL: srv.URL + "/assets/feed.rss"}},
+		},
+		{
+			name:    "Nothing to find",
+			path:    "/bare/",
+			wantErr: ErrNoFeedFound,
+		},
+	}
+
+	f := NewFetcher(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := f.Discover(context.Background(), srv.URL+tt.path)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Discover() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Discover() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Discover() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDiscoverProbesCommonPaths checks /rss.xml style paths are tried when a page has no links
+func TestDiscoverProbesCommonPaths(t *testing.T) {
+	feed, err := os.ReadFile(filepath.Join("testdata", "test_feed.xml"))
+	if err != nil {
+		t.Fatalf("couldn't read test file: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`<html><body>home page</body></html>`))
+	})
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		// Not a feed, some sites serve HTML here
+		_, _ = w.Write([]byte(`<html><body>nope</body></html>`))
+	})
+	mux.HandleFunc("/rss.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(feed)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	got, err := NewFetcher(nil).Discover(context.Background(), srv.URL+"/")
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	want := []Candidate{{URL: srv.URL + "/rss.xml", Title: "Test Blog"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Discover() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+package rss
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// itemEnclosures returns the files attached to an item. iTunes gives one
+// duration per item, it's taken to be the duration of each of them.
+func itemEnclosures(item *gofeed.Item) []models.Enclosure {
+	var duration time.Duration
+	if item.ITunesExt != nil {
+		duration = parseDuration(item.ITunesExt.Duration)
+	}
+
+	var enclosures []models.Enclosure
+	seen := make(map[string]bool)
+	for _, enc := range item.Enclosures {
+		if enc == nil {
+			continue
+		}
+		url := strings.TrimSpace(enc.URL)
+		if url == "" || seen[url] {
+			continue
+		}
+		seen[url] = true
+		// Feeds put all sorts in length, a bad value just means unknown
+		length, err := strconv.ParseInt(strings.TrimSpace(enc.Length), 10, 64)
+		if err != nil || length < 0 {
+			length = 0
+		}
+		enclosures = append(enclosures, models.Enclosure{
+			URL:      url,
+			Type:     strings.TrimSpace(enc.Type),
+			Length:   length,
+			Duration: duration,
+		})
+	}
+	return enclosures
+}
+
+// parseDuration reads an itunes:duration, which is either a number of seconds
+// or [[HH:]MM:]SS. Anything else is 0.
+func parseDuration(s string) time.Duration {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0
+	}
+	var seconds float64
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second)
+}
@@ -0,0 +1,69 @@
+package rss
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+func TestParseFeedEnclosures(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "podcast.xml"))
+	if err != nil {
+		t.Fatalf("couldn't read test file: %v", err)
+	}
+	res, err := NewFetcher(nil).parseFeed("http://test.com", content)
+	if err != nil {
+		t.Fatalf("parseFeed() unexpected error: %v", err)
+	}
+
+	want := map[string][]models.Enclosure{
+		"Episode 2": {{URL: "https://cdn.example.com/ep2.mp3", Type: "audio/mpeg", Length: 34567890, Duration: time.Hour + 2*time.Minute + 3*time.Second}},
+		"Episode 1": {{URL: "https://cdn.example.com/ep1.m4a", Type: "audio/x-m4a", Duration: 45*time.Minute + 10*time.Second}},
+		"Trailer":   {{URL: "https://cdn.example.com/trailer.mp3", Type: "audio/mpeg", Duration: 90 * time.Second}},
+	}
+	if len(res.Posts) != len(want) {
+		t.Fatalf("got %d posts, want %d", len(res.Posts), len(want))
+	}
+	for _, p := range res.Posts {
+		if !reflect.DeepEqual(p.Enclosures, want[p.Title]) {
+			t.Errorf("%q enclosures = %+v, want %+v", p.Title, p.Enclosures, want[p.Title])
+		}
+	}
+
+	// Atom enclosure links come through the same way
+	atom := `<?xml version="1.0"?><feed xmlns="http://www.w3.org/2005/Atom"><title>Test</title><entry><title>Post</title><id>1</id>` +
+		`<link rel="enclosure" href="https://example.com/talk.webm" type="video/webm" length="1000"/></entry></feed>`
+	res, err = NewFetcher(nil).parseFeed("http://test.com", []byte(atom))
+	if err != nil {
+		t.Fatalf("parseFeed() unexpected error: %v", err)
+	}
+	wantAtom := []models.Enclosure{{URL: "https://example.com/talk.webm", Type: "video/webm", Length: 1000}}
+	if !reflect.DeepEqual(res.Posts[0].Enclosures, wantAtom) {
+		t.Errorf("atom enclosures = %+v, want %+v", res.Posts[0].Enclosures, wantAtom)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"2710", 45*time.Minute + 10*time.Second},
+		{"45:10", 45*time.Minute + 10*time.Second},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second},
+		{" 00:00:59.6 ", time.Minute},
+		{"1:2:3:4", 0},
+		{"an hour", 0},
+		{"-5", 0},
+	}
+	for _, tt := range tests {
+		if got := parseDuration(tt.in); got != tt.want {
+			t.Errorf("parseDuration(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package rss
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+const (
+	// backoffAfter is how many fetches in a row have to fail before a feed
+	// is left alone for a while, one failure is usually just a bad moment
+	backoffAfter = 2
+	backoffBase  = 30 * time.Minute
+	backoffMax   = 24 * time.Hour
+)
+
+// backoff is how long to wait before fetching a feed that has failed this
+// many times in a row: nothing at first, then doubling up to a day
+func backoff(failures int) time.Duration {
+	if failures < backoffAfter {
+		return 0
+	}
+	wait := backoffBase
+	for range failures - backoffAfter {
+		wait *= 2
+		if wait >= backoffMax {
+			return backoffMax
+		}
+	}
+	return wait
+}
+
+// nextHealth is a feed's health after a fetch at now that ended with err
+func nextHealth(prev models.FeedHealth, info fetchInfo, err error, now time.Time) models.FeedHealth {
+	h := prev
+	h.LastFetchAt = now
+	h.LastStatus = info.status
+	h.RedirectedToPage = info.toPage
+	h.MovedTo = info.movedTo
+	h.MovedCount = 0
+	if err == nil || errors.Is(err, ErrNotModified) {
+		// Only a redirect to somewhere that works counts towards moving
+		if h.MovedTo != "" {
+			h.MovedCount = 1
+			if prev.MovedTo == h.MovedTo {
+				h.MovedCount = prev.MovedCount + 1
+			}
+		}
+		h.LastSuccessAt = now
+		h.Errors = 0
+		h.LastError = ""
+		h.RetryAt = time.Time{}
+		return h
+	}
+
+	h.Errors++
+	h.LastError = err.Error()
+	h.RetryAt = time.Time{}
+	// A server asking us to wait is listened to, within reason
+	if wait := max(backoff(h.Errors), min(info.retryAfter, maxHint)); wait > 0 {
+		h.RetryAt = now.Add(wait)
+	}
+	return h
+}
@@ -0,0 +1,147 @@
+package rss
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+	"github.com/pixel-87/warss/internal/storage"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 1, want: 0},
+		{failures: 2, want: 30 * time.Minute},
+		{failures: 3, want: time.Hour},
+		{failures: 4, want: 2 * time.Hour},
+		{failures: 7, want: 16 * time.Hour},
+		{failures: 8, want: 24 * time.Hour},
+		{failures: 500, want: 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestNextHealth(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	worked := now.Add(-48 * time.Hour)
+	failing := models.FeedHealth{LastSuccessAt: worked, Errors: 1, LastError: "boom", LastStatus: 500}
+
+	h := nextHealth(failing, fetchInfo{status: 404}, errors.New("not found"), now)
+	if h.Errors != 2 || h.LastError != "not found" || h.LastStatus != 404 || !h.LastFetchAt.Equal(now) {
+		t.Errorf("after a second failure health = %+v", h)
+	}
+	if !h.LastSuccessAt.Equal(worked) || !h.RetryAt.Equal(now.Add(30*time.Minute)) {
+		t.Errorf("after a second failure last worked %v, retry at %v", h.LastSuccessAt, h.RetryAt)
+	}
+
+	// Not modified is the feed working
+	h = nextHealth(h, fetchInfo{status: 304}, ErrNotModified, now)
+	if h.Errors != 0 || h.LastError != "" || !h.RetryAt.IsZero() || !h.LastSuccessAt.Equal(now) || h.LastStatus != 304 {
+		t.Errorf("after recovering health = %+v", h)
+	}
+}
+
+// healthDB returns a database subscribed to url and the subscription
+func healthDB(t *testing.T, url string) (*storage.DB, models.Feed) {
+	t.Helper()
+	db, err := storage.NewDB(filepath.Join(t.TempDir(), "rss.db"))
+	if err != nil {
+		t.Fatalf("failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := db.AddFeed(url, "Feed"); err != nil {
+		t.Fatalf("failed to add feed: %v", err)
+	}
+	return db, reload(t, db)
+}
+
+func reload(t *testing.T, db *storage.DB) models.Feed {
+	t.Helper()
+	subs, err := db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	return subs[0]
+}
+
+// TestRefreshAllBackoff checks failures are recorded, a feed that keeps
+// failing is skipped until it's due again and recovering clears it all
+func TestRefreshAllBackoff(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "test_feed.xml"))
+	if err != nil {
+		t.Fatalf("couldn't read test file: %v", err)
+	}
+	var (
+		broken   atomic.Bool
+		requests atomic.Int32
+	)
+	broken.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if broken.Load() {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(content)
+	}))
+	defer srv.Close()
+
+	db, sub := healthDB(t, srv.URL)
+	f := NewFetcher(db)
+	refresh := func() RefreshResult {
+		t.Helper()
+		var last RefreshResult
+		for res := range f.RefreshAll(context.Background(), []models.Feed{sub}) {
+			last = res
+		}
+		sub = reload(t, db)
+		return last
+	}
+
+	if res := refresh(); res.Status != StatusFailed {
+		t.Fatalf("first refresh status = %v, want failed", res.Status)
+	}
+	if sub.Health.Errors != 1 || sub.Health.LastStatus != 404 || !sub.Health.RetryAt.IsZero() {
+		t.Errorf("after one failure health = %+v, want 404 and no backoff yet", sub.Health)
+	}
+
+	refresh()
+	if sub.Health.Errors != 2 || time.Until(sub.Health.RetryAt) < 25*time.Minute {
+		t.Errorf("after two failures health = %+v, want backing off", sub.Health)
+	}
+
+	// Backing off, the server isn't asked
+	before := requests.Load()
+	if res := refresh(); res.Status != StatusSkipped || res.Err != nil {
+		t.Errorf("refresh while backing off = %v, %v, want skipped", res.Status, res.Err)
+	}
+	if requests.Load() != before || sub.Health.Errors != 2 {
+		t.Errorf("skipped feed was fetched or recorded: %d requests, health %+v", requests.Load()-before, sub.Health)
+	}
+
+	// Forcing fetches it anyway, and a working fetch clears the failures
+	broken.Store(false)
+	f.SetForce(true)
+	if res := refresh(); res.Status != StatusUpdated {
+		t.Fatalf("forced refresh status = %v (%v), want updated", res.Status, res.Err)
+	}
+	if sub.Health.Errors != 0 || sub.Health.LastError != "" || !sub.Health.RetryAt.IsZero() ||
+		sub.Health.LastStatus != 200 || sub.Health.LastSuccessAt.IsZero() || sub.Health.LastNewPostAt.IsZero() {
+		t.Errorf("after recovering health = %+v", sub.Health)
+	}
+}
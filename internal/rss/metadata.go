@@ -0,0 +1,50 @@
+package rss
+
+import (
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// feedMetadata copies what a feed says about itself onto f
+func feedMetadata(f *models.Feed, raw *gofeed.Feed) {
+	f.SiteURL = raw.Link
+	f.Description = strings.TrimSpace(raw.Description)
+	f.Language = raw.Language
+	if raw.Image != nil {
+		f.LogoURL = raw.Image.URL
+	}
+	f.IconURL = raw.Custom[customIcon]
+}
+
+// postMetadata copies an item's bylines, tags, image, comments link and
+// language onto p. Items without a language get the feed's.
+func postMetadata(p *models.Post, item *gofeed.Item, feedLanguage string) {
+	for _, a := range item.Authors {
+		if a == nil {
+			continue
+		}
+		name := strings.TrimSpace(a.Name)
+		if name == "" {
+			name = strings.TrimSpace(a.Email)
+		}
+		if name != "" {
+			p.Authors = append(p.Authors, name)
+		}
+	}
+	for _, tag := range item.Categories {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			p.Tags = append(p.Tags, tag)
+		}
+	}
+	if item.Image != nil {
+		p.ImageURL = item.Image.URL
+	}
+	p.CommentsURL = item.Custom[customComments]
+	p.Language = item.Custom[customLanguage]
+	if p.Language == "" {
+		p.Language = feedLanguage
+	}
+}
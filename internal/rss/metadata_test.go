@@ -0,0 +1,123 @@
+package rss
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// TestParseFeedMetadata checks feed and post metadata from each feed format
+func TestParseFeedMetadata(t *testing.T) {
+	tests := []struct {
+		filename string
+		wantFeed models.Feed
+		// wantPosts holds only the metadata fields of each post
+		wantPosts []models.Post
+	}{
+		{
+			filename: "metadata.xml",
+			wantFeed: models.Feed{
+				SiteURL:     "https://example.com/",
+				Description: "Posts about things",
+				LogoURL:     "https://example.com/logo.png",
+				Language:    "en-gb",
+			},
+			wantPosts: []models.Post{
+				{
+					Summary:     "A short summary",
+					Authors:     []string{"Ada Lovelace"},
+					Tags:        []string{"maths", "engines"},
+					ImageURL:    "https://example.com/everything.jpg",
+					CommentsURL: "https://example.com/everything#comments",
+					Language:    "en-gb",
+				},
+				{Language: "en-gb"},
+			},
+		},
+		{
+			filename: "metadata_atom.xml",
+			wantFeed: models.Feed{
+				SiteURL:     "https://example.org/",
+				Description: "Un blog",
+				IconURL:     "https://example.org/favicon.ico",
+				LogoURL:     "https://example.org/logo.svg",
+				Language:    "fr",
+			},
+			wantPosts: []models.Post{
+				{
+					Summary:     "Résumé",
+					Authors:     []string{"Marie Curie", "pierre@example.org"},
+					Tags:        []string{"physique"},
+					CommentsURL: "https://example.org/entry/comments",
+					Language:    "fr",
+				},
+			},
+		},
+		{
+			filename: "metadata.json",
+			wantFeed: models.Feed{
+				SiteURL:     "https://example.net/",
+				Description: "A JSON feed",
+				IconURL:     "https://example.net/favicon-64.png",
+				LogoURL:     "https://example.net/icon-512.png",
+				Language:    "de",
+			},
+			wantPosts: []models.Post{
+				{
+					Summary:  "Kurz",
+					Authors:  []string{"Grace Hopper"},
+					Tags:     []string{"go", "feeds"},
+					ImageURL: "https://example.net/1.png",
+					Language: "en",
+				},
+				{Language: "de"},
+			},
+		},
+	}
+
+	f := NewFetcher(nil)
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			content, err := os.ReadFile(filepath.Join("testdata", tt.filename))
+			if err != nil {
+				t.Fatalf("couldn't read test file %s: %v", tt.filename, err)
+			}
+			res, err := f.parseFeed("http://test.com", content)
+			if err != nil {
+				t.Fatalf("parseFeed() unexpected error: %v", err)
+			}
+
+			got := models.Feed{
+				SiteURL:     res.SiteURL,
+				Description: res.Description,
+				IconURL:     res.IconURL,
+				LogoURL:     res.LogoURL,
+				Language:    res.Language,
+			}
+			if !reflect.DeepEqual(got, tt.wantFeed) {
+				t.Errorf("feed metadata = %+v, want %+v", got, tt.wantFeed)
+			}
+
+			if len(res.Posts) != len(tt.wantPosts) {
+				t.Fatalf("got %d posts, want %d", len(res.Posts), len(tt.wantPosts))
+			}
+			for i, p := range res.Posts {
+				got := models.Post{
+					Summary:     p.Summary,
+					Authors:     p.Authors,
+					Tags:        p.Tags,
+					ImageURL:    p.ImageURL,
+					CommentsURL: p.CommentsURL,
+					Language:    p.Language,
+				}
+				if !reflect.DeepEqual(got, tt.wantPosts[i]) {
+					t.Errorf("post %q metadata = %+v, want %+v", p.Title, got, tt.wantPosts[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package rss
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+)
+
+// moveAfter is how many fetches in a row have to be permanently redirected to
+// the same place before the feed's stored URL follows. One redirect could be a
+// misconfigured server, several in a row is a move.
+const moveAfter = 3
+
+// redirectsKey carries a *[]int collecting the status of each redirect a
+// request follows
+type redirectsKey struct{}
+
+// checkRedirect follows redirects like the default policy, noting each
+// redirect's status for the request that started the chain
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if hops, ok := req.Context().Value(redirectsKey{}).(*[]int); ok && req.Response != nil {
+		*hops = append(*hops, req.Response.StatusCode)
+	}
+	return nil
+}
+
+// permanent reports whether every redirect followed was permanent
+func permanent(hops []int) bool {
+	for _, status := range hops {
+		if status != http.StatusMovedPermanently && status != http.StatusPermanentRedirect {
+			return false
+		}
+	}
+	return len(hops) > 0
+}
+
+// isPage reports whether a response is a web page rather than a feed, going
+// by its Content-Type or, without one, by sniffing the body
+func isPage(contentType string, body []byte) bool {
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	media, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return media == "text/html" || media == "application/xhtml+xml"
+}
@@ -0,0 +1,165 @@
+package rss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// redirectServer serves the test feed at /feed and a web page at /page, with
+// redirects to them from elsewhere
+func redirectServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", "test_feed.xml"))
+	if err != nil {
+		t.Fatalf("couldn't read test file: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(content)
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte("<!doctype html><html><body>We stopped publishing a feed</body></html>"))
+	})
+	mux.Handle("/moved", http.RedirectHandler("/feed", http.StatusMovedPermanently))
+	mux.Handle("/moved-twice", http.RedirectHandler("/moved", http.StatusPermanentRedirect))
+	mux.Handle("/temporary", http.RedirectHandler("/feed", http.StatusFound))
+	mux.Handle("/mixed", http.RedirectHandler("/temporary", http.StatusMovedPermanently))
+	mux.Handle("/dropped", http.RedirectHandler("/page", http.StatusMovedPermanently))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSyncFeedRedirects(t *testing.T) {
+	srv := redirectServer(t)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/feed", want: ""},
+		{path: "/moved", want: srv.URL + "/feed"},
+		{path: "/moved-twice", want: srv.URL + "/feed"},
+		{path: "/temporary", want: ""},
+		{path: "/mixed", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			db, sub := healthDB(t, srv.URL+tt.path)
+			if _, err := NewFetcher(db).SyncFeed(context.Background(), sub); err != nil {
+				t.Fatalf("SyncFeed() error = %v", err)
+			}
+			if got := reload(t, db).Health.MovedTo; got != tt.want {
+				t.Errorf("moved to %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRefreshAllMovesFeed checks the stored URL only follows a permanent
+// redirect once it has been seen enough times in a row
+func TestRefreshAllMovesFeed(t *testing.T) {
+	srv := redirectServer(t)
+	db, sub := healthDB(t, srv.URL+"/moved")
+	f := NewFetcher(db)
+	// Each refresh leaves the feed not due for a while
+	f.SetForce(true)
+
+	for i := 1; i <= moveAfter; i++ {
+		var res RefreshResult
+		for r := range f.RefreshAll(context.Background(), []models.Feed{sub}) {
+			res = r
+		}
+		if res.Err != nil {
+			t.Fatalf("refresh %d error = %v", i, res.Err)
+		}
+		sub = reload(t, db)
+		if i < moveAfter {
+			if sub.URL != srv.URL+"/moved" || sub.Health.MovedCount != i || res.MovedFrom != "" {
+				t.Errorf("after %d redirects feed at %q seen %d times, moved from %q", i, sub.URL, sub.Health.MovedCount, res.MovedFrom)
+			}
+			continue
+		}
+		if sub.URL != srv.URL+"/feed" || res.Feed.URL != sub.URL || res.MovedFrom != srv.URL+"/moved" {
+			t.Errorf("after %d redirects feed at %q, result at %q moved from %q", i, sub.URL, res.Feed.URL, res.MovedFrom)
+		}
+		if len(sub.Aliases) != 1 || sub.Aliases[0] != srv.URL+"/moved" {
+			t.Errorf("aliases = %q, want the old url", sub.Aliases)
+		}
+		if sub.Health.MovedTo != "" || sub.Health.MovedCount != 0 {
+			t.Errorf("health after moving = %+v, want the move cleared", sub.Health)
+		}
+	}
+}
+
+func TestRefreshAllTemporaryRedirectNeverMoves(t *testing.T) {
+	srv := redirectServer(t)
+	db, sub := healthDB(t, srv.URL+"/temporary")
+	f := NewFetcher(db)
+	for range moveAfter + 1 {
+		for res := range f.RefreshAll(context.Background(), []models.Feed{sub}) {
+			if res.Err != nil || res.MovedFrom != "" {
+				t.Fatalf("refresh = %+v", res)
+			}
+		}
+		sub = reload(t, db)
+	}
+	if sub.URL != srv.URL+"/temporary" || len(sub.Aliases) != 0 {
+		t.Errorf("feed at %q with aliases %q, want it left alone", sub.URL, sub.Aliases)
+	}
+}
+
+func TestSyncFeedRedirectToPage(t *testing.T) {
+	srv := redirectServer(t)
+	db, sub := healthDB(t, srv.URL+"/dropped")
+	f := NewFetcher(db)
+
+	for range moveAfter {
+		_, err := f.SyncFeed(context.Background(), sub)
+		if err == nil || !strings.Contains(err.Error(), "redirects to a web page at "+srv.URL+"/page") {
+			t.Fatalf("SyncFeed() error = %v, want it to name the page", err)
+		}
+		sub = reload(t, db)
+	}
+	// A feed that became a web page isn't followed there
+	if !sub.Health.RedirectedToPage || sub.URL != srv.URL+"/dropped" || sub.Health.MovedCount != 0 {
+		t.Errorf("feed at %q with health %+v, want it flagged and not moved", sub.URL, sub.Health)
+	}
+
+	// A page served directly is just a parse error
+	db, sub = healthDB(t, srv.URL+"/page")
+	if _, err := NewFetcher(db).SyncFeed(context.Background(), sub); err == nil {
+		t.Fatal("SyncFeed() of a web page succeeded")
+	}
+	if reload(t, db).Health.RedirectedToPage {
+		t.Error("a page fetched without a redirect was flagged as one")
+	}
+}
+
+func TestIsPage(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        bool
+	}{
+		{contentType: "text/html; charset=utf-8", want: true},
+		{contentType: "application/xhtml+xml", want: true},
+		{contentType: "application/rss+xml", want: false},
+		{contentType: "", body: "<!DOCTYPE html><html></html>", want: true},
+		{contentType: "", body: `<?xml version="1.0"?><rss></rss>`, want: false},
+		{contentType: "not a media type;;", want: false},
+	}
+	for _, tt := range tests {
+		if got := isPage(tt.contentType, []byte(tt.body)); got != tt.want {
+			t.Errorf("isPage(%q, %q) = %t, want %t", tt.contentType, tt.body, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package rss
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+	"github.com/pixel-87/warss/internal/storage"
+)
+
+// RefreshStatus describes how refreshing a single feed went
+type RefreshStatus int
+
+const (
+	StatusUpdated RefreshStatus = iota
+	StatusUnchanged
+	StatusFailed
+	// StatusSkipped feeds aren't due yet, either going by their schedule or
+	// because they kept failing and are backing off
+	StatusSkipped
+)
+
+func (s RefreshStatus) String() string {
+	switch s {
+	case StatusUpdated:
+		return "updated"
+	case StatusUnchanged:
+		return "unchanged"
+	case StatusFailed:
+		return "failed"
+	case StatusSkipped:
+		return "skipped"
+	default:
+		return "unknown"
+	}
+}
+
+// RefreshResult is streamed by RefreshAll once per feed.
+// Feed is the subscription that was refreshed, or the freshly parsed feed
+// (carrying the subscription's ID) when Status is StatusUpdated. With a
+// database its health and schedule are the ones this refresh left it with.
+// Stats is only filled in when the fetcher has a database to sync into.
+type RefreshResult struct {
+	Feed   models.Feed
+	Status RefreshStatus
+	Stats  storage.SyncStats
+	Err    error
+	// MovedFrom is the feed's old URL when this refresh moved it to Feed.URL
+	MovedFrom string
+}
+
+// RefreshAll fetches feeds in parallel, using at most the configured number of
+// workers, and streams one result per feed as it finishes. With a database
+// every feed goes through SyncFeed, so its posts are stored as well. Feeds
+// that aren't due yet are skipped unless the fetcher is forced. The channel
+// is closed once every feed is done. Cancelling ctx aborts in-flight requests,
+// stops handing out new feeds and closes the channel without sending results
+// for the feeds that were skipped.
+func (f *Fetcher) RefreshAll(ctx context.Context, feeds []models.Feed) <-chan RefreshResult {
+	results := make(chan RefreshResult)
+	jobs := make(chan models.Feed)
+
+	var wg sync.WaitGroup
+	for range min(f.workers, max(len(feeds), 1)) {
+		wg.Go(func() {
+			for sub := range jobs {
+				res := f.refreshOne(ctx, sub)
+				if ctx.Err() != nil {
+					return
+				}
+				select {
+				case results <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+
+	go func() {
+		defer close(jobs)
+		for _, sub := range feeds {
+			select {
+			case jobs <- sub:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+func (f *Fetcher) refreshOne(ctx context.Context, sub models.Feed) RefreshResult {
+	if !f.force && !sub.Schedule.Due(time.Now()) {
+		return RefreshResult{Feed: sub, Status: StatusSkipped}
+	}
+
+	var (
+		feed  models.Feed
+		stats storage.SyncStats
+		err   error
+	)
+	if f.db != nil {
+		feed, stats, err = f.syncFeed(ctx, sub)
+	} else {
+		feed, _, err = f.getFeed(ctx, sub.URL)
+		if err == nil {
+			f.rememberValidators(sub.URL, validators{etag: feed.ETag, lastModified: feed.LastModified})
+			feed.ID = sub.ID
+		}
+	}
+
+	var res RefreshResult
+	switch {
+	case errors.Is(err, ErrNotModified):
+		res = RefreshResult{Feed: sub, Status: StatusUnchanged}
+		if feed.URL != "" {
+			res.Feed = feed
+		}
+	case err != nil:
+		res = RefreshResult{Feed: sub, Status: StatusFailed, Err: err}
+		if feed.URL != "" {
+			res.Feed = feed
+		}
+	default:
+		res = RefreshResult{Feed: feed, Status: StatusUpdated, Stats: stats}
+	}
+	if res.Feed.URL != sub.URL {
+		res.MovedFrom = sub.URL
+	}
+	return res
+}
@@ -0,0 +1,196 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+	"github.com/pixel-87/warss/internal/storage"
+)
+
+// TestRefreshAll checks every feed gets exactly one result with the right status
+func TestRefreshAll(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "test_feed.xml"))
+	if err != nil {
+		t.Fatalf("couldn't read test file: %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/broken":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		case "/unchanged":
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			_, _ = w.Write(content)
+		}
+	}))
+	defer srv.Close()
+
+	feeds := []models.Feed{
+		{ID: 1, URL: srv.URL + "/ok1"},
+		{ID: 2, URL: srv.URL + "/broken"},
+		{ID: 3, URL: srv.URL + "/unchanged"},
+		{ID: 4, URL: srv.URL + "/ok2"},
+	}
+	want := map[int]RefreshStatus{
+		1: StatusUpdated,
+		2: StatusFailed,
+		3: StatusUnchanged,
+		4: StatusUpdated,
+	}
+
+	f := NewFetcher(nil)
+	f.SetWorkers(2)
+
+	got := make(map[int]RefreshStatus)
+	for res := range f.RefreshAll(context.Background(), feeds) {
+		if _, dup := got[res.Feed.ID]; dup {
+			t.Errorf("feed %d reported twice", res.Feed.ID)
+		}
+		got[res.Feed.ID] = res.Status
+		if (res.Err != nil) != (res.Status == StatusFailed) {
+			t.Errorf("feed %d: status %v with error %v", res.Feed.ID, res.Status, res.Err)
+		}
+		if res.Status == StatusUpdated && res.Feed.Title != "Test Blog" {
+			t.Errorf("feed %d: got title %q, want %q", res.Feed.ID, res.Feed.Title, "Test Blog")
+		}
+	}
+
+	for id, status := range want {
+		if got[id] != status {
+			t.Errorf("feed %d: got status %v, want %v", id, got[id], status)
+		}
+	}
+}
+
+// TestRefreshAllWorkerLimit verifies no more than the configured workers fetch at once
+func TestRefreshAllWorkerLimit(t *testing.T) {
+	const workers = 3
+	var inFlight, peak atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer srv.Close()
+
+	var feeds []models.Feed
+	for i := range 12 {
+		feeds = append(feeds, models.Feed{ID: i, URL: fmt.Sprintf("%s/%d", srv.URL, i)})
+	}
+
+	f := NewFetcher(nil)
+	f.SetWorkers(workers)
+
+	count := 0
+	for range f.RefreshAll(context.Background(), feeds) {
+		count++
+	}
+
+	if count != len(feeds) {
+		t.Errorf("got %d results, want %d", count, len(feeds))
+	}
+	if peak.Load() > workers {
+		t.Errorf("peak concurrency %d exceeds %d workers", peak.Load(), workers)
+	}
+}
+
+// TestRefreshAllCancel verifies cancelling aborts hanging requests and closes the channel
+func TestRefreshAllCancel(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	var feeds []models.Feed
+	for i := range 5 {
+		feeds = append(feeds, models.Feed{ID: i, URL: fmt.Sprintf("%s/%d", srv.URL, i)})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := NewFetcher(nil)
+	f.SetWorkers(2)
+	results := f.RefreshAll(ctx, feeds)
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	done := make(chan struct{})
+	go func() {
+		for range results {
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("RefreshAll did not close its channel after cancel")
+	}
+}
+
+// TestRefreshAllSync verifies posts are stored when the fetcher has a database
+func TestRefreshAllSync(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "large_feed.xml"))
+	if err != nil {
+		t.Fatalf("couldn't read test file: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(content)
+	}))
+	defer srv.Close()
+
+	db, err := storage.NewDB(filepath.Join(t.TempDir(), "rss.db"))
+	if err != nil {
+		t.Fatalf("failed to create test database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	if err := db.AddFeed(srv.URL, ""); err != nil {
+		t.Fatalf("failed to add feed: %v", err)
+	}
+	subs, err := db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+
+	f := NewFetcher(db)
+	for range 2 {
+		for res := range f.RefreshAll(context.Background(), subs) {
+			if res.Err != nil {
+				t.Fatalf("refresh error = %v", res.Err)
+			}
+			if res.Stats.New+res.Stats.Unchanged != 5 {
+				t.Errorf("got stats %+v, want 5 posts accounted for", res.Stats)
+			}
+		}
+	}
+
+	subs, err = db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	if subs[0].Title != "Large Feed" {
+		t.Errorf("feed title = %q, want %q", subs[0].Title, "Large Feed")
+	}
+}
@@ -1,10 +1,13 @@
 package rss
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mmcdole/gofeed"
@@ -13,44 +16,163 @@ import (
 	"github.com/pixel-87/warss/internal/storage"
 )
 
-// Allows for reuse of gofeed.Parser and http.client
+// ErrNotModified is returned by GetFeed when the server answers 304 Not Modified,
+// meaning nothing changed since the last successful fetch
+var ErrNotModified = errors.New("feed not modified")
+
+// HTTP cache validators from a feed's last successful fetch
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+// fetchInfo is what a fetch learned about the feed's URL, filled in as far as
+// the fetch got even when it failed
+type fetchInfo struct {
+	// status is the HTTP status of the final response, 0 without one
+	status int
+	// finalURL is where the request ended up, empty when it wasn't redirected
+	finalURL string
+	// movedTo is finalURL when every redirect on the way was permanent (301
+	// or 308), temporary ones don't say anything about where the feed lives
+	movedTo string
+	// toPage is set when a redirect led to a web page rather than a feed
+	toPage bool
+	// cacheFor is how long the server said the response stays fresh,
+	// retryAfter how long it asked us to wait before trying again
+	cacheFor   time.Duration
+	retryAfter time.Duration
+}
+
+// DefaultWorkers is how many feeds RefreshAll fetches at once unless told otherwise
+const DefaultWorkers = 8
+
+// Allows for reuse of gofeed.Parser and http.client.
+// A gofeed.Parser is not safe for concurrent use, so parsers are pooled.
 type Fetcher struct {
-	parser *gofeed.Parser
-	client *http.Client
-	db     *storage.DB
+	parsers sync.Pool
+	client  *http.Client
+	db      *storage.DB
+	workers int
+	// force fetches feeds even when they aren't due
+	force bool
+
+	mu         sync.Mutex
+	validators map[string]validators
 }
 
 func NewFetcher(db *storage.DB) *Fetcher {
 	return &Fetcher{
-		parser: gofeed.NewParser(),
+		parsers: sync.Pool{
+			New: func() any { return newParser() },
+		},
 		client: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:       10 * time.Second,
+			CheckRedirect: checkRedirect,
 		},
-		db: db,
+		db:         db,
+		workers:    DefaultWorkers,
+		validators: make(map[string]validators),
+	}
+}
+
+// SetWorkers sets how many feeds RefreshAll fetches in parallel, values below 1 mean 1
+func (f *Fetcher) SetWorkers(n int) {
+	f.workers = max(n, 1)
+}
+
+// SetForce makes RefreshAll fetch feeds that aren't due yet, including ones
+// backing off after failing
+func (f *Fetcher) SetForce(force bool) {
+	f.force = force
+}
+
+// getValidators returns the validators remembered for url, falling back to the database
+func (f *Fetcher) getValidators(ctx context.Context, url string) validators {
+	f.mu.Lock()
+	v, ok := f.validators[url]
+	f.mu.Unlock()
+	if ok || f.db == nil {
+		return v
+	}
+
+	etag, lastModified, err := f.db.GetFeedValidatorsContext(ctx, url)
+	if err != nil {
+		return validators{}
 	}
+	return validators{etag: etag, lastModified: lastModified}
 }
 
-func (f *Fetcher) fetchURL(url string) ([]byte, error) {
-	resp, err := f.client.Get(url)
+// rememberValidators keeps the validators for url in memory for the next fetch
+func (f *Fetcher) rememberValidators(url string, v validators) {
+	f.mu.Lock()
+	f.validators[url] = v
+	f.mu.Unlock()
+}
+
+func (f *Fetcher) fetchURL(ctx context.Context, url string) ([]byte, validators, fetchInfo, error) {
+	var (
+		info fetchInfo
+		hops []int
+	)
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, redirectsKey{}, &hops), http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch URL %s: %w", url, err)
+		return nil, validators{}, info, fmt.Errorf("failed to build request for %s: %w", url, err)
+	}
+
+	prev := f.getValidators(ctx, url)
+	if prev.etag != "" {
+		req.Header.Set("If-None-Match", prev.etag)
+	}
+	if prev.lastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.lastModified)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, validators{}, info, fmt.Errorf("failed to fetch URL %s: %w", url, err)
 	}
 	defer func() {
 		if cerr := resp.Body.Close(); cerr != nil {
 			fmt.Printf("error closing response body %v", cerr)
 		}
 	}()
+	info.status = resp.StatusCode
+	now := time.Now()
+	info.cacheFor = cacheLifetime(resp.Header, now)
+	info.retryAfter = retryAfter(resp.Header, now)
+	if len(hops) > 0 {
+		info.finalURL = resp.Request.URL.String()
+		if permanent(hops) {
+			info.movedTo = info.finalURL
+		}
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, prev, info, ErrNotModified
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, validators{}, info, fmt.Errorf("unexpected status fetching %s: %s", url, resp.Status)
+	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, validators{}, info, fmt.Errorf("failed to read response body: %w", err)
+	}
+	info.toPage = info.finalURL != "" && isPage(resp.Header.Get("Content-Type"), body)
+	next := validators{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
 	}
-	return body, nil
+	return body, next, info, nil
 }
 
 func (f *Fetcher) parseFeed(url string, data []byte) (models.Feed, error) {
+	parser := f.parsers.Get().(*gofeed.Parser)
+	defer f.parsers.Put(parser)
+
 	// Parses any feed into a universal gofeed.Feed, takes an io reader which reads xml/json data
-	rawFeed, err := f.parser.Parse(strings.NewReader(string(data)))
+	rawFeed, err := parser.Parse(strings.NewReader(string(data)))
 	if err != nil {
 		return models.Feed{}, fmt.Errorf("failed parsing %s: %w", url, err)
 	}
@@ -59,27 +181,160 @@ func (f *Fetcher) parseFeed(url string, data []byte) (models.Feed, error) {
 		Title: rawFeed.Title,
 		URL:   url,
 	}
+	feedMetadata(&myFeed, rawFeed)
+	myFeed.Schedule = feedSchedule(rawFeed)
+	now := time.Now()
 	for _, item := range rawFeed.Items {
-		content := item.Content
-		if content == "" {
-			content = item.Description
+		// Content is what the reader shows, the description stands in when
+		// there's nothing else and is kept as the summary when there is
+		content, summary := item.Content, item.Description
+		if content == "" || content == summary {
+			content, summary = summary, ""
 		}
-		myFeed.Posts = append(myFeed.Posts, models.Post{
+		published, source := resolveDate(item, now)
+		post := models.Post{
+			GUID:        item.GUID,
 			Title:       item.Title,
 			Link:        item.Link,
 			Content:     content,
-			PublishedAt: *item.PublishedParsed,
-			UpdatedAt:   *item.UpdatedParsed,
-		})
+			Summary:     summary,
+			PublishedAt: published,
+			UpdatedAt:   updatedDate(item, now),
+			DateSource:  source,
+			Enclosures:  itemEnclosures(item),
+		}
+		postMetadata(&post, item, myFeed.Language)
+		myFeed.Posts = append(myFeed.Posts, post)
 	}
+	myFeed.Schedule.PostInterval = postInterval(myFeed.Posts)
 	return myFeed, nil
 }
 
+// GetFeed is GetFeedContext without a context
 func (f *Fetcher) GetFeed(url string) (models.Feed, error) {
-	body, err := f.fetchURL(url)
+	return f.GetFeedContext(context.Background(), url)
+}
+
+// GetFeedContext fetches and parses the feed at url. If the server reports
+// the feed unchanged since the last successful fetch, ErrNotModified is
+// returned and the body is never parsed. Nothing is stored, so with a
+// database the validators are left for SyncFeed to save along with the
+// posts. Cancelling ctx aborts the request.
+func (f *Fetcher) GetFeedContext(ctx context.Context, url string) (models.Feed, error) {
+	feed, _, err := f.getFeed(ctx, url)
 	if err != nil {
 		return models.Feed{}, err
 	}
 
-	return f.parseFeed(url, body)
+	// Only remember validators once the body parsed, otherwise a broken
+	// response would be "not modified" forever. With a database they'd tell
+	// the next SyncFeed nothing changed before these posts were ever stored.
+	if f.db == nil {
+		f.rememberValidators(url, validators{etag: feed.ETag, lastModified: feed.LastModified})
+	}
+	return feed, nil
+}
+
+// getFeed fetches and parses url, the new validators are returned on the feed
+// but not remembered, that is up to the caller
+func (f *Fetcher) getFeed(ctx context.Context, url string) (models.Feed, fetchInfo, error) {
+	body, v, info, err := f.fetchURL(ctx, url)
+	if err != nil {
+		return models.Feed{}, info, err
+	}
+
+	feed, err := f.parseFeed(url, body)
+	if err != nil {
+		if info.toPage {
+			err = fmt.Errorf("%s redirects to a web page at %s, not a feed", url, info.finalURL)
+		}
+		return models.Feed{}, info, err
+	}
+	// Served as HTML but a feed all the same
+	info.toPage = false
+	feed.ETag = v.etag
+	feed.LastModified = v.lastModified
+	return feed, info, nil
+}
+
+// SyncFeed fetches a subscription and stores the result: the feed title is
+// updated and new posts are inserted in one transaction. ErrNotModified is
+// returned untouched when the server says nothing changed. How the fetch went
+// is recorded as the feed's health either way, along with when the feed is
+// next due, and a feed that has permanently redirected to the same place for
+// long enough is moved there.
+func (f *Fetcher) SyncFeed(ctx context.Context, sub models.Feed) (storage.SyncStats, error) {
+	_, stats, err := f.syncFeed(ctx, sub)
+	return stats, err
+}
+
+func (f *Fetcher) syncFeed(ctx context.Context, sub models.Feed) (models.Feed, storage.SyncStats, error) {
+	if f.db == nil {
+		return models.Feed{}, storage.SyncStats{}, errors.New("sync needs a database")
+	}
+
+	feed, stats, info, err := f.saveFeed(ctx, sub)
+	// A cancelled fetch says nothing about the feed
+	if ctx.Err() != nil {
+		if err == nil {
+			err = ctx.Err()
+		}
+		return models.Feed{}, storage.SyncStats{}, err
+	}
+	if err != nil {
+		feed = sub
+	}
+
+	// A new version of the feed brings its own hints, otherwise the last ones stand
+	now := time.Now()
+	health := nextHealth(sub.Health, info, err, now)
+	schedule := sub.Schedule
+	if err == nil {
+		schedule = feed.Schedule
+	}
+	schedule.NextDueAt = nextDue(schedule, health, info.cacheFor, now)
+	if health.MovedCount >= moveAfter {
+		switch merr := f.db.MoveFeed(ctx, sub.ID, health.MovedTo); {
+		case merr == nil:
+			feed.URL = health.MovedTo
+			health.MovedTo, health.MovedCount = "", 0
+		case errors.Is(merr, storage.ErrFeedExists):
+			// Already subscribed at the new address, warss health keeps
+			// listing it as moved until one of them is removed
+		case err == nil || errors.Is(err, ErrNotModified):
+			err = merr
+		}
+	}
+	if herr := f.db.SetFeedHealth(ctx, sub.ID, health); herr != nil && (err == nil || errors.Is(err, ErrNotModified)) {
+		err = herr
+	}
+	if serr := f.db.SetFeedSchedule(ctx, sub.ID, schedule); serr != nil && (err == nil || errors.Is(err, ErrNotModified)) {
+		err = serr
+	}
+	feed.Health, feed.Schedule = health, schedule
+	if err != nil {
+		return feed, storage.SyncStats{}, err
+	}
+	return feed, stats, nil
+}
+
+// saveFeed fetches sub and stores what it found
+func (f *Fetcher) saveFeed(ctx context.Context, sub models.Feed) (models.Feed, storage.SyncStats, fetchInfo, error) {
+	feed, info, err := f.getFeed(ctx, sub.URL)
+	if err != nil {
+		return models.Feed{}, storage.SyncStats{}, info, err
+	}
+	feed.ID = sub.ID
+	if feed.Title == "" {
+		feed.Title = sub.Title
+	}
+
+	// Validators are written in the same transaction as the posts, so a failed
+	// save can't leave us believing we already have this version
+	stats, err := f.db.SaveFeed(ctx, feed)
+	if err != nil {
+		return models.Feed{}, storage.SyncStats{}, info, err
+	}
+	f.rememberValidators(sub.URL, validators{etag: feed.ETag, lastModified: feed.LastModified})
+	return feed, stats, info, nil
 }
@@ -1,10 +1,15 @@
 package rss
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseFeed(t *testing.T) {
@@ -64,6 +69,13 @@ func TestParseFeed(t *testing.T) {
 			wantCount: 1,
 			wantErr:   false,
 		},
+		{
+			name:      "Rich HTML Content",
+			filename:  "rich_content.xml",
+			wantTitle: "Rich Content",
+			wantCount: 2,
+			wantErr:   false,
+		},
 		{
 			name:      "Large Feed with Multiple Items",
 			filename:  "large_feed.xml",
@@ -218,11 +230,13 @@ func TestParseFeedContentPriority(t *testing.T) {
 		name        string
 		data        string
 		wantContent string
+		wantSummary string
 	}{
 		{
 			name:        "Content Takes Priority Over Description",
 			data:        `<?xml version="1.0"?><rss version="2.0"><channel><title>Test</title><item><title>Post</title><link>http://example.com</link><content:encoded>Priority Content</content:encoded><description>Fallback Description</description></item></channel></rss>`,
 			wantContent: "Priority Content",
+			wantSummary: "Fallback Description",
 		},
 		{
 			name:        "Description Used When No Content",
@@ -257,6 +271,168 @@ func TestParseFeedContentPriority(t *testing.T) {
 			if res.Posts[0].Content != tt.wantContent {
 				t.Errorf("got content %q, want %q", res.Posts[0].Content, tt.wantContent)
 			}
+			if res.Posts[0].Summary != tt.wantSummary {
+				t.Errorf("got summary %q, want %q", res.Posts[0].Summary, tt.wantSummary)
+			}
+		})
+	}
+}
+
+// TestGetFeedConditional verifies validators are sent back and a 304 skips parsing
+func TestGetFeedConditional(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "test_feed.xml"))
+	if err != nil {
+		t.Fatalf("couldn't read test file: %v", err)
+	}
+
+	const etag = `"v1"`
+	const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		_, _ = w.Write(content)
+	}))
+	defer srv.Close()
+
+	f := NewFetcher(nil)
+
+	feed, err := f.GetFeed(srv.URL)
+	if err != nil {
+		t.Fatalf("first GetFeed() error = %v", err)
+	}
+	if feed.Title != "Test Blog" {
+		t.Errorf("got title %q, want %q", feed.Title, "Test Blog")
+	}
+	if feed.ETag != etag || feed.LastModified != lastModified {
+		t.Errorf("got validators %q, %q, want %q, %q", feed.ETag, feed.LastModified, etag, lastModified)
+	}
+
+	_, err = f.GetFeed(srv.URL)
+	if !errors.Is(err, ErrNotModified) {
+		t.Fatalf("second GetFeed() error = %v, want ErrNotModified", err)
+	}
+	if requests != 2 {
+		t.Errorf("got %d requests, want 2", requests)
+	}
+}
+
+// TestGetFeedThenSync checks fetching a feed without storing it doesn't make a
+// later sync think the posts are already stored
+func TestGetFeedThenSync(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "test_feed.xml"))
+	if err != nil {
+		t.Fatalf("couldn't read test file: %v", err)
+	}
+	const etag = `"v1"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write(content)
+	}))
+	defer srv.Close()
+
+	db, sub := healthDB(t, srv.URL)
+	f := NewFetcher(db)
+	if _, err := f.GetFeed(srv.URL); err != nil {
+		t.Fatalf("GetFeed() error = %v", err)
+	}
+	stats, err := f.SyncFeed(context.Background(), sub)
+	if err != nil {
+		t.Fatalf("SyncFeed() after GetFeed() error = %v", err)
+	}
+	if stats.New != 1 {
+		t.Errorf("SyncFeed() stats = %+v, want the post stored", stats)
+	}
+}
+
+// TestGetFeedBadStatus verifies error pages are not handed to the parser
+func TestGetFeedBadStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	f := NewFetcher(nil)
+	if _, err := f.GetFeed(srv.URL); err == nil {
+		t.Fatal("GetFeed() expected error for 404 but got none")
+	}
+}
+
+// TestGetFeedContextCancel checks cancelling the context aborts a request the
+// server is still sitting on, well before the client's own timeout
+func TestGetFeedContextCancel(t *testing.T) {
+	aborted := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(aborted)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := NewFetcher(nil).GetFeedContext(ctx, srv.URL)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetFeedContext() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("GetFeedContext() took %v to notice the cancel", elapsed)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Error("the server never saw the request go away")
+	}
+}
+
+// TestParseFeedGUID verifies RSS guid and Atom id are kept on the post
+func TestParseFeedGUID(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantGUID string
+	}{
+		{
+			name:     "RSS guid",
+			data:     `<?xml version="1.0"?><rss version="2.0"><channel><title>Test</title><item><title>Post</title><guid isPermaLink="false">urn:uuid:42</guid></item></channel></rss>`,
+			wantGUID: "urn:uuid:42",
+		},
+		{
+			name:     "Atom id",
+			data:     `<?xml version="1.0"?><feed xmlns="http://www.w3.org/2005/Atom"><title>Test</title><entry><title>Post</title><id>tag:example.com,2024:1</id></entry></feed>`,
+			wantGUID: "tag:example.com,2024:1",
+		},
+		{
+			name:     "No guid",
+			data:     `<?xml version="1.0"?><rss version="2.0"><channel><title>Test</title><item><title>Post</title><link>http://example.com</link></item></channel></rss>`,
+			wantGUID: "",
+		},
+	}
+
+	f := NewFetcher(nil)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := f.parseFeed("http://test.com", []byte(tt.data))
+			if err != nil {
+				t.Fatalf("parseFeed() unexpected error: %v", err)
+			}
+			if len(res.Posts) != 1 {
+				t.Fatalf("got %d posts, want 1", len(res.Posts))
+			}
+			if res.Posts[0].GUID != tt.wantGUID {
+				t.Errorf("got guid %q, want %q", res.Posts[0].GUID, tt.wantGUID)
+			}
 		})
 	}
 }
@@ -0,0 +1,188 @@
+package rss
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+const (
+	// minInterval and maxInterval bound how often a feed is fetched going by
+	// how often it posts, defaultInterval is used until that's known
+	minInterval     = 15 * time.Minute
+	defaultInterval = time.Hour
+	maxInterval     = 24 * time.Hour
+	// maxHint caps how long a feed or server can ask to be left alone, past
+	// a week it's more likely a mistake than a wish
+	maxHint = 7 * 24 * time.Hour
+	// recentPosts is how many of the newest posts the post interval is taken from
+	recentPosts = 10
+)
+
+// syPeriods are the sy:updatePeriod values and how long each one is
+var syPeriods = map[string]time.Duration{
+	"hourly":  time.Hour,
+	"daily":   24 * time.Hour,
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 30 * 24 * time.Hour,
+	"yearly":  365 * 24 * time.Hour,
+}
+
+// feedSchedule reads the hints a feed gives about when to fetch it: RSS
+// <ttl>, <skipHours> and <skipDays>, and the syndication module's
+// sy:updatePeriod and sy:updateFrequency. Hints that don't parse are ignored.
+func feedSchedule(raw *gofeed.Feed) models.FeedSchedule {
+	var s models.FeedSchedule
+	if ttl, err := strconv.Atoi(raw.Custom[customTTL]); err == nil && ttl > 0 {
+		s.TTL = time.Duration(ttl) * time.Minute
+	}
+
+	for _, v := range strings.Split(raw.Custom[customSkipHours], ",") {
+		hour, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || hour < 0 || hour > 24 {
+			continue
+		}
+		// Some feeds count 1-24 rather than 0-23, 24 can only mean midnight
+		s.SkipHours = append(s.SkipHours, hour%24)
+	}
+	slices.Sort(s.SkipHours)
+	s.SkipHours = slices.Compact(s.SkipHours)
+
+	for _, v := range strings.Split(raw.Custom[customSkipDays], ",") {
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if strings.EqualFold(strings.TrimSpace(v), day.String()) && !slices.Contains(s.SkipDays, day) {
+				s.SkipDays = append(s.SkipDays, day)
+			}
+		}
+	}
+	slices.Sort(s.SkipDays)
+
+	if period, ok := syPeriods[strings.ToLower(syValue(raw, "updatePeriod"))]; ok {
+		frequency := 1
+		if n, err := strconv.Atoi(syValue(raw, "updateFrequency")); err == nil && n > 0 {
+			frequency = n
+		}
+		s.UpdatePeriod = period / time.Duration(frequency)
+	}
+	return s
+}
+
+// syValue is the text of the feed's sy:name element, empty without one
+func syValue(raw *gofeed.Feed, name string) string {
+	if values := raw.Extensions["sy"][name]; len(values) > 0 {
+		return strings.TrimSpace(values[0].Value)
+	}
+	return ""
+}
+
+// postInterval is the median time between the newest posts that have a real
+// date, 0 when fewer than three do
+func postInterval(posts []models.Post) time.Duration {
+	var dates []time.Time
+	for _, p := range posts {
+		if !p.PublishedAt.IsZero() && p.DateSource != models.DateFirstSeen {
+			dates = append(dates, p.PublishedAt)
+		}
+	}
+	if len(dates) < 3 {
+		return 0
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].After(dates[j]) })
+	dates = dates[:min(len(dates), recentPosts)]
+
+	gaps := make([]time.Duration, 0, len(dates)-1)
+	for i := 1; i < len(dates); i++ {
+		gaps = append(gaps, dates[i-1].Sub(dates[i]))
+	}
+	slices.Sort(gaps)
+	return gaps[len(gaps)/2]
+}
+
+// interval is how long to wait before fetching a feed again: a quarter of
+// its usual time between posts, so new ones don't sit unseen for long, but
+// never sooner than the feed or the server asked
+func interval(s models.FeedSchedule, cacheFor time.Duration) time.Duration {
+	wait := defaultInterval
+	if s.PostInterval > 0 {
+		wait = min(max(s.PostInterval/4, minInterval), maxInterval)
+	}
+	return max(wait, min(max(s.TTL, s.UpdatePeriod, cacheFor), maxHint))
+}
+
+// skipWindows moves t past the hours and days the feed asked not to be
+// fetched in, both in UTC. A feed that skips every hour is taken at t anyway.
+func skipWindows(s models.FeedSchedule, t time.Time) time.Time {
+	if len(s.SkipHours) == 0 && len(s.SkipDays) == 0 {
+		return t
+	}
+	next := t.UTC()
+	for range 7 * 24 {
+		if !slices.Contains(s.SkipHours, next.Hour()) && !slices.Contains(s.SkipDays, next.Weekday()) {
+			return next
+		}
+		next = next.Truncate(time.Hour).Add(time.Hour)
+	}
+	return t
+}
+
+// nextDue is when a feed fetched at now should next be fetched. A failing
+// feed is due when its backoff ends, any other once its interval has passed
+// and it's out of its skip windows.
+func nextDue(s models.FeedSchedule, h models.FeedHealth, cacheFor time.Duration, now time.Time) time.Time {
+	if h.Failing() {
+		return h.RetryAt
+	}
+	return skipWindows(s, now.Add(interval(s, cacheFor)))
+}
+
+// cacheLifetime is how long a response may be reused going by its
+// Cache-Control, or failing that its Expires, header. 0 means not at all or
+// that the server didn't say.
+func cacheLifetime(header http.Header, now time.Time) time.Duration {
+	maxAge := -1
+	for _, directive := range strings.Split(strings.Join(header.Values("Cache-Control"), ","), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache":
+			return 0
+		case "max-age":
+			if n, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && n >= 0 {
+				maxAge = n
+			}
+		}
+	}
+	if maxAge >= 0 {
+		age, _ := strconv.Atoi(header.Get("Age"))
+		return max(time.Duration(maxAge-max(age, 0))*time.Second, 0)
+	}
+
+	// An Expires that doesn't parse means already expired
+	expires, err := http.ParseTime(header.Get("Expires"))
+	if err != nil {
+		return 0
+	}
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		now = date
+	}
+	return max(expires.Sub(now), 0)
+}
+
+// retryAfter is how long a Retry-After header, in seconds or as a date, asks
+// us to wait, 0 without one
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	v := strings.TrimSpace(header.Get("Retry-After"))
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
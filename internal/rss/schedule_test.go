@@ -0,0 +1,256 @@
+package rss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// TestParseFeedSchedule checks the hints a feed gives are read and bad ones ignored
+func TestParseFeedSchedule(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "schedule.xml"))
+	if err != nil {
+		t.Fatalf("couldn't read test file: %v", err)
+	}
+	feed, err := NewFetcher(nil).parseFeed("http://test.com", content)
+	if err != nil {
+		t.Fatalf("parseFeed() error = %v", err)
+	}
+
+	want := models.FeedSchedule{
+		TTL:          90 * time.Minute,
+		UpdatePeriod: 12 * time.Hour,
+		SkipHours:    []int{0, 2},
+		SkipDays:     []time.Weekday{time.Sunday, time.Saturday},
+		// Gaps of 2, 4 and 6 hours, the undated post doesn't count
+		PostInterval: 4 * time.Hour,
+	}
+	if !reflect.DeepEqual(feed.Schedule, want) {
+		t.Errorf("schedule = %+v, want %+v", feed.Schedule, want)
+	}
+}
+
+func TestPostInterval(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	dated := func(hours ...int) []models.Post {
+		var posts []models.Post
+		for _, h := range hours {
+			posts = append(posts, models.Post{PublishedAt: start.Add(time.Duration(h) * time.Hour), DateSource: models.DatePublished})
+		}
+		return posts
+	}
+
+	tests := []struct {
+		name  string
+		posts []models.Post
+		want  time.Duration
+	}{
+		{name: "none", want: 0},
+		{name: "too few", posts: dated(0, 5), want: 0},
+		{name: "median gap", posts: dated(0, 1, 11, 13), want: 2 * time.Hour},
+		{name: "order doesn't matter", posts: dated(13, 0, 11, 1), want: 2 * time.Hour},
+		{name: "only the newest", posts: dated(0, 100, 200, 201, 202, 203, 204, 205, 206, 207, 208, 209), want: time.Hour},
+		{
+			name:  "first seen dates ignored",
+			posts: append(dated(0, 24), models.Post{PublishedAt: start.Add(25 * time.Hour), DateSource: models.DateFirstSeen}),
+			want:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := postInterval(tt.posts); got != tt.want {
+				t.Errorf("postInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule models.FeedSchedule
+		cacheFor time.Duration
+		want     time.Duration
+	}{
+		{name: "nothing known", want: defaultInterval},
+		{name: "posts often", schedule: models.FeedSchedule{PostInterval: 10 * time.Minute}, want: minInterval},
+		{name: "posts daily", schedule: models.FeedSchedule{PostInterval: 24 * time.Hour}, want: 6 * time.Hour},
+		{name: "posts rarely", schedule: models.FeedSchedule{PostInterval: 30 * 24 * time.Hour}, want: maxInterval},
+		{name: "ttl", schedule: models.FeedSchedule{TTL: 3 * time.Hour}, want: 3 * time.Hour},
+		{name: "short ttl", schedule: models.FeedSchedule{TTL: time.Minute}, want: defaultInterval},
+		{name: "update period", schedule: models.FeedSchedule{UpdatePeriod: 12 * time.Hour, TTL: time.Hour}, want: 12 * time.Hour},
+		{name: "cache headers", cacheFor: 2 * time.Hour, want: 2 * time.Hour},
+		{name: "hints capped", schedule: models.FeedSchedule{UpdatePeriod: 365 * 24 * time.Hour}, want: maxHint},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := interval(tt.schedule, tt.cacheFor); got != tt.want {
+				t.Errorf("interval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipWindows(t *testing.T) {
+	// A Friday
+	at := time.Date(2024, 5, 3, 22, 30, 0, 0, time.UTC)
+	every := make([]int, 24)
+	for i := range every {
+		every[i] = i
+	}
+
+	tests := []struct {
+		name     string
+		schedule models.FeedSchedule
+		want     time.Time
+	}{
+		{name: "no windows", want: at},
+		{name: "outside the window", schedule: models.FeedSchedule{SkipHours: []int{3}}, want: at},
+		{name: "skipped hours", schedule: models.FeedSchedule{SkipHours: []int{22, 23, 0}}, want: time.Date(2024, 5, 4, 1, 0, 0, 0, time.UTC)},
+		{
+			name:     "skipped weekend",
+			schedule: models.FeedSchedule{SkipHours: []int{22, 23}, SkipDays: []time.Weekday{time.Saturday, time.Sunday}},
+			want:     time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC),
+		},
+		{name: "every hour skipped", schedule: models.FeedSchedule{SkipHours: every}, want: at},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := skipWindows(tt.schedule, at); !got.Equal(tt.want) {
+				t.Errorf("skipWindows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextDue(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := models.FeedSchedule{TTL: 2 * time.Hour}
+
+	if got := nextDue(s, models.FeedHealth{}, 0, now); !got.Equal(now.Add(2 * time.Hour)) {
+		t.Errorf("working feed due %v, want after its ttl", got)
+	}
+	failing := models.FeedHealth{Errors: 3, RetryAt: now.Add(time.Hour)}
+	if got := nextDue(s, failing, 0, now); !got.Equal(failing.RetryAt) {
+		t.Errorf("failing feed due %v, want when it's retried", got)
+	}
+	if got := nextDue(s, models.FeedHealth{Errors: 1}, 0, now); !got.IsZero() {
+		t.Errorf("feed failing once due %v, want right away", got)
+	}
+}
+
+func TestCacheHeaders(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		header    http.Header
+		cacheFor  time.Duration
+		retryWait time.Duration
+	}{
+		{name: "nothing", header: http.Header{}},
+		{name: "max-age", header: http.Header{"Cache-Control": {"public, max-age=3600"}}, cacheFor: time.Hour},
+		{name: "age", header: http.Header{"Cache-Control": {"max-age=3600"}, "Age": {"600"}}, cacheFor: 50 * time.Minute},
+		{name: "no-cache", header: http.Header{"Cache-Control": {"max-age=3600", "no-cache"}}},
+		{
+			name:     "max-age beats expires",
+			header:   http.Header{"Cache-Control": {"max-age=60"}, "Expires": {"Wed, 01 May 2024 14:00:00 GMT"}},
+			cacheFor: time.Minute,
+		},
+		{
+			name:     "expires",
+			header:   http.Header{"Expires": {"Wed, 01 May 2024 14:00:00 GMT"}, "Date": {"Wed, 01 May 2024 13:00:00 GMT"}},
+			cacheFor: time.Hour,
+		},
+		{name: "expires without date", header: http.Header{"Expires": {"Wed, 01 May 2024 14:00:00 GMT"}}, cacheFor: 2 * time.Hour},
+		{name: "bad expires", header: http.Header{"Expires": {"0"}}},
+		{name: "retry after seconds", header: http.Header{"Retry-After": {"120"}}, retryWait: 2 * time.Minute},
+		{name: "retry after date", header: http.Header{"Retry-After": {"Wed, 01 May 2024 15:00:00 GMT"}}, retryWait: 3 * time.Hour},
+		{name: "retry after past", header: http.Header{"Retry-After": {"Wed, 01 May 2024 11:00:00 GMT"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cacheLifetime(tt.header, now); got != tt.cacheFor {
+				t.Errorf("cacheLifetime() = %v, want %v", got, tt.cacheFor)
+			}
+			if got := retryAfter(tt.header, now); got != tt.retryWait {
+				t.Errorf("retryAfter() = %v, want %v", got, tt.retryWait)
+			}
+		})
+	}
+}
+
+// TestRefreshAllSchedule checks a fetched feed isn't fetched again until it's
+// due, unless forced, and that a server's Retry-After is honoured
+func TestRefreshAllSchedule(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "test_feed.xml"))
+	if err != nil {
+		t.Fatalf("couldn't read test file: %v", err)
+	}
+	var (
+		busy     atomic.Bool
+		requests atomic.Int32
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if busy.Load() {
+			w.Header().Set("Retry-After", "7200")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=10800")
+		_, _ = w.Write(content)
+	}))
+	defer srv.Close()
+
+	db, sub := healthDB(t, srv.URL)
+	f := NewFetcher(db)
+	refresh := func() RefreshResult {
+		t.Helper()
+		var last RefreshResult
+		for res := range f.RefreshAll(context.Background(), []models.Feed{sub}) {
+			last = res
+		}
+		sub = reload(t, db)
+		return last
+	}
+
+	if !sub.Schedule.Due(time.Now()) {
+		t.Fatalf("new feed not due, next due %v", sub.Schedule.NextDueAt)
+	}
+	res := refresh()
+	if res.Status != StatusUpdated {
+		t.Fatalf("first refresh = %v, %v, want updated", res.Status, res.Err)
+	}
+	if wait := time.Until(sub.Schedule.NextDueAt); wait < 2*time.Hour || wait > 3*time.Hour {
+		t.Errorf("next due in %v, want the 3 hours the server asked for", wait)
+	}
+	if !res.Feed.Schedule.NextDueAt.Equal(sub.Schedule.NextDueAt) {
+		t.Errorf("result next due %v, stored %v", res.Feed.Schedule.NextDueAt, sub.Schedule.NextDueAt)
+	}
+
+	before := requests.Load()
+	if res := refresh(); res.Status != StatusSkipped {
+		t.Errorf("refresh before due = %v, want skipped", res.Status)
+	}
+	if requests.Load() != before {
+		t.Errorf("feed that isn't due was fetched")
+	}
+
+	// Forced, it's fetched and the server asks for a pause
+	busy.Store(true)
+	f.SetForce(true)
+	if res := refresh(); res.Status != StatusFailed || requests.Load() != before+1 {
+		t.Errorf("forced refresh = %v after %d requests, want failed after one", res.Status, requests.Load()-before)
+	}
+	if wait := time.Until(sub.Health.RetryAt); wait < time.Hour || !sub.Schedule.NextDueAt.Equal(sub.Health.RetryAt) {
+		t.Errorf("after Retry-After retry in %v, due %v, want both in about 2 hours", wait, sub.Schedule.NextDueAt)
+	}
+}
@@ -0,0 +1,121 @@
+package rss
+
+import (
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/atom"
+	"github.com/mmcdole/gofeed/json"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// Keys in gofeed's Custom maps for what the universal feed has no field for.
+// The translators below fill them in and parseFeed reads them back.
+const (
+	customIcon     = "warss:icon"
+	customComments = "warss:comments"
+	customLanguage = "warss:language"
+	// RSS scheduling hints, the skip lists comma separated
+	customTTL       = "warss:ttl"
+	customSkipHours = "warss:skipHours"
+	customSkipDays  = "warss:skipDays"
+)
+
+// setCustom records value under key on a feed's or item's Custom map
+func setCustom(custom *map[string]string, key, value string) {
+	if value == "" {
+		return
+	}
+	if *custom == nil {
+		*custom = make(map[string]string)
+	}
+	(*custom)[key] = value
+}
+
+// rssTranslator stops gofeed passing dc:date off as an item's pubDate, so
+// resolveDate can tell which of the two the feed gave, and keeps comments links
+// and the channel's scheduling hints
+type rssTranslator struct {
+	gofeed.DefaultRSSTranslator
+}
+
+func (t *rssTranslator) Translate(feed any) (*gofeed.Feed, error) {
+	result, err := t.DefaultRSSTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+	raw := feed.(*rss.Feed)
+	setCustom(&result.Custom, customTTL, strings.TrimSpace(raw.TTL))
+	setCustom(&result.Custom, customSkipHours, strings.Join(raw.SkipHours, ","))
+	setCustom(&result.Custom, customSkipDays, strings.Join(raw.SkipDays, ","))
+	for i, item := range result.Items {
+		if i >= len(raw.Items) {
+			break
+		}
+		item.Published, item.PublishedParsed = raw.Items[i].PubDate, raw.Items[i].PubDateParsed
+		setCustom(&item.Custom, customComments, raw.Items[i].Comments)
+	}
+	return result, nil
+}
+
+// atomTranslator stops gofeed passing an entry's updated date off as its
+// published date, or the feed's icon off as its logo, and keeps replies links
+type atomTranslator struct {
+	gofeed.DefaultAtomTranslator
+}
+
+func (t *atomTranslator) Translate(feed any) (*gofeed.Feed, error) {
+	result, err := t.DefaultAtomTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+	raw := feed.(*atom.Feed)
+	if raw.Logo == "" {
+		result.Image = nil
+	}
+	setCustom(&result.Custom, customIcon, raw.Icon)
+	for i, item := range result.Items {
+		if i >= len(raw.Entries) {
+			break
+		}
+		entry := raw.Entries[i]
+		item.Published, item.PublishedParsed = entry.Published, entry.PublishedParsed
+		for _, link := range entry.Links {
+			if link.Rel == "replies" && (link.Type == "" || link.Type == "text/html") {
+				setCustom(&item.Custom, customComments, link.Href)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// jsonTranslator keeps the feed's favicon and each item's language
+type jsonTranslator struct {
+	gofeed.DefaultJSONTranslator
+}
+
+func (t *jsonTranslator) Translate(feed any) (*gofeed.Feed, error) {
+	result, err := t.DefaultJSONTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+	raw := feed.(*json.Feed)
+	setCustom(&result.Custom, customIcon, raw.Favicon)
+	for i, item := range result.Items {
+		if i < len(raw.Items) {
+			setCustom(&item.Custom, customLanguage, raw.Items[i].Language)
+		}
+	}
+	return result, nil
+}
+
+// newParser returns a gofeed.Parser that keeps what the translators above
+// rescue from the default ones
+func newParser() *gofeed.Parser {
+	p := gofeed.NewParser()
+	p.RSSTranslator = &rssTranslator{}
+	p.AtomTranslator = &atomTranslator{}
+	p.JSONTranslator = &jsonTranslator{}
+	return p
+}
@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// ErrCategoryExists is returned when a category already has a sibling of the same name
+var ErrCategoryExists = errors.New("category already exists")
+
+// ErrCategoryCycle is returned when moving a category would put it inside itself
+var ErrCategoryCycle = errors.New("category can't be moved inside itself")
+
+// splitIDs parses the comma separated ids GROUP_CONCAT produces
+func splitIDs(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var ids []int
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("bad id %q in list: %w", part, err)
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// nullParent stores top level categories with a NULL parent
+func nullParent(parentID int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(parentID), Valid: parentID != 0}
+}
+
+// AddCategory creates a category under parentID, 0 for the top level, and returns its id
+func (d *DB) AddCategory(ctx context.Context, name string, parentID int) (int, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, errors.New("category name can't be empty")
+	}
+	query := `INSERT OR IGNORE INTO categories (name, parent_id) VALUES (?, ?)`
+	res, err := d.conn.ExecContext(ctx, query, name, nullParent(parentID))
+	if err != nil {
+		return 0, fmt.Errorf("failed to add category %q: %w", name, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to add category %q: %w", name, err)
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%w: %q", ErrCategoryExists, name)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to add category %q: %w", name, err)
+	}
+	return int(id), nil
+}
+
+// EnsureCategoryPath returns the id of the category at path, a list of names
+// from the top level down, creating whatever part of it doesn't exist yet
+func (d *DB) EnsureCategoryPath(ctx context.Context, path []string) (int, error) {
+	var parentID int
+	err := d.withTx(ctx, func(tx *sql.Tx) error {
+		parentID = 0
+		for _, name := range path {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			query := `INSERT OR IGNORE INTO categories (name, parent_id) VALUES (?, ?)`
+			if _, err := tx.ExecContext(ctx, query, name, nullParent(parentID)); err != nil {
+				return fmt.Errorf("failed to add category %q: %w", name, err)
+			}
+			query = `SELECT id FROM categories WHERE COALESCE(parent_id, 0) = ? AND name = ?`
+			if err := tx.QueryRowContext(ctx, query, parentID, name).Scan(&parentID); err != nil {
+				return fmt.Errorf("failed to look up category %q: %w", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if parentID == 0 {
+		return 0, errors.New("category path is empty")
+	}
+	return parentID, nil
+}
+
+// GetCategories returns every category ordered by name, with unread counts
+// rolled up from the feeds filed under it and under its subcategories
+func (d *DB) GetCategories(ctx context.Context) ([]models.Category, error) {
+	rows, err := d.conn.QueryContext(ctx, `
+		SELECT id, name, COALESCE(parent_id, 0)
+		FROM categories
+		ORDER BY name COLLATE NOCASE, id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get categories: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("error closing rows: %v\n", err)
+		}
+	}()
+
+	var categories []models.Category
+	for rows.Next() {
+		var c models.Category
+		if err := rows.Scan(&c.ID, &c.Name, &c.ParentID); err != nil {
+			return nil, fmt.Errorf("failed to scan category: %w", err)
+		}
+		categories = append(categories, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating categories: %w", err)
+	}
+
+	feedsIn, err := d.categoryFeeds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	unread, err := d.UnreadCounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[int][]int)
+	for _, c := range categories {
+		children[c.ParentID] = append(children[c.ParentID], c.ID)
+	}
+	// A feed filed in two subcategories still only counts once for the parent
+	var collect func(id int, feeds map[int]bool)
+	collect = func(id int, feeds map[int]bool) {
+		for _, feedID := range feedsIn[id] {
+			feeds[feedID] = true
+		}
+		for _, child := range children[id] {
+			collect(child, feeds)
+		}
+	}
+	for i := range categories {
+		feeds := make(map[int]bool)
+		collect(categories[i].ID, feeds)
+		for feedID := range feeds {
+			categories[i].Unread += unread[feedID]
+		}
+	}
+	return categories, nil
+}
+
+// categoryFeeds maps category ids to the feeds filed directly under them
+func (d *DB) categoryFeeds(ctx context.Context) (map[int][]int, error) {
+	rows, err := d.conn.QueryContext(ctx, `SELECT category_id, feed_id FROM feed_categories`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get category feeds: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("error closing rows: %v\n", err)
+		}
+	}()
+
+	feeds := make(map[int][]int)
+	for rows.Next() {
+		var categoryID, feedID int
+		if err := rows.Scan(&categoryID, &feedID); err != nil {
+			return nil, fmt.Errorf("failed to scan category feed: %w", err)
+		}
+		feeds[categoryID] = append(feeds[categoryID], feedID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating category feeds: %w", err)
+	}
+	return feeds, nil
+}
+
+// UpdateCategory renames a category and moves it under c.ParentID
+func (d *DB) UpdateCategory(ctx context.Context, c models.Category) error {
+	name := strings.TrimSpace(c.Name)
+	if name == "" {
+		return errors.New("category name can't be empty")
+	}
+	return d.withTx(ctx, func(tx *sql.Tx) error {
+		// Walk up from the new parent, meeting c on the way means a cycle
+		for parent := c.ParentID; parent != 0; {
+			if parent == c.ID {
+				return ErrCategoryCycle
+			}
+			var next sql.NullInt64
+			err := tx.QueryRowContext(ctx, `SELECT parent_id FROM categories WHERE id = ?`, parent).Scan(&next)
+			if err != nil {
+				return fmt.Errorf("failed to look up category %d: %w", parent, err)
+			}
+			parent = int(next.Int64)
+		}
+
+		var taken int
+		query := `SELECT COUNT(*) FROM categories WHERE COALESCE(parent_id, 0) = ? AND name = ? AND id != ?`
+		if err := tx.QueryRowContext(ctx, query, c.ParentID, name, c.ID).Scan(&taken); err != nil {
+			return fmt.Errorf("failed to check category name %q: %w", name, err)
+		}
+		if taken > 0 {
+			return fmt.Errorf("%w: %q", ErrCategoryExists, name)
+		}
+
+		query = `UPDATE categories SET name = ?, parent_id = ? WHERE id = ?`
+		if _, err := tx.ExecContext(ctx, query, name, nullParent(c.ParentID), c.ID); err != nil {
+			return fmt.Errorf("failed to update category %d: %w", c.ID, err)
+		}
+		return nil
+	})
+}
+
+// DeleteCategory removes a category and its subcategories. The feeds filed
+// there stay subscribed.
+func (d *DB) DeleteCategory(ctx context.Context, id int) error {
+	if _, err := d.conn.ExecContext(ctx, `DELETE FROM categories WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete category %d: %w", id, err)
+	}
+	return nil
+}
+
+// AddFeedToCategory files a feed under a category, doing nothing if it already is
+func (d *DB) AddFeedToCategory(ctx context.Context, feedID, categoryID int) error {
+	query := `INSERT OR IGNORE INTO feed_categories (feed_id, category_id) VALUES (?, ?)`
+	if _, err := d.conn.ExecContext(ctx, query, feedID, categoryID); err != nil {
+		return fmt.Errorf("failed to add feed %d to category %d: %w", feedID, categoryID, err)
+	}
+	return nil
+}
+
+// RemoveFeedFromCategory takes a feed out of a category
+func (d *DB) RemoveFeedFromCategory(ctx context.Context, feedID, categoryID int) error {
+	query := `DELETE FROM feed_categories WHERE feed_id = ? AND category_id = ?`
+	if _, err := d.conn.ExecContext(ctx, query, feedID, categoryID); err != nil {
+		return fmt.Errorf("failed to remove feed %d from category %d: %w", feedID, categoryID, err)
+	}
+	return nil
+}
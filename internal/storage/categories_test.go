@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+func categoryByName(t *testing.T, db *DB, name string) models.Category {
+	t.Helper()
+	categories, err := db.GetCategories(context.Background())
+	if err != nil {
+		t.Fatalf("GetCategories() error = %v", err)
+	}
+	for _, c := range categories {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatalf("category %q not found", name)
+	return models.Category{}
+}
+
+func TestCategories(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	tech, err := db.AddCategory(ctx, "Tech", 0)
+	if err != nil {
+		t.Fatalf("AddCategory() error = %v", err)
+	}
+	if _, err := db.AddCategory(ctx, "Tech", 0); !errors.Is(err, ErrCategoryExists) {
+		t.Errorf("AddCategory() twice error = %v, want ErrCategoryExists", err)
+	}
+	golang, err := db.AddCategory(ctx, "Go", tech)
+	if err != nil {
+		t.Fatalf("AddCategory() nested error = %v", err)
+	}
+	// The same name is fine under another parent
+	if _, err := db.AddCategory(ctx, "Go", 0); err != nil {
+		t.Errorf("AddCategory() same name at the top error = %v", err)
+	}
+
+	// EnsureCategoryPath finds what exists and creates the rest
+	id, err := db.EnsureCategoryPath(ctx, []string{"Tech", "Go"})
+	if err != nil || id != golang {
+		t.Errorf("EnsureCategoryPath(Tech/Go) = %d, %v, want %d", id, err, golang)
+	}
+	rust, err := db.EnsureCategoryPath(ctx, []string{"Tech", "Rust"})
+	if err != nil {
+		t.Fatalf("EnsureCategoryPath(Tech/Rust) error = %v", err)
+	}
+	if c := categoryByName(t, db, "Rust"); c.ParentID != tech {
+		t.Errorf("Rust parent = %d, want %d", c.ParentID, tech)
+	}
+
+	// A category can't move below itself
+	err = db.UpdateCategory(ctx, models.Category{ID: tech, Name: "Tech", ParentID: golang})
+	if !errors.Is(err, ErrCategoryCycle) {
+		t.Errorf("UpdateCategory() into a child error = %v, want ErrCategoryCycle", err)
+	}
+	err = db.UpdateCategory(ctx, models.Category{ID: rust, Name: "Go", ParentID: tech})
+	if !errors.Is(err, ErrCategoryExists) {
+		t.Errorf("UpdateCategory() onto a sibling's name error = %v, want ErrCategoryExists", err)
+	}
+	if err := db.UpdateCategory(ctx, models.Category{ID: rust, Name: "Rustlang", ParentID: 0}); err != nil {
+		t.Fatalf("UpdateCategory() error = %v", err)
+	}
+	if c := categoryByName(t, db, "Rustlang"); c.ParentID != 0 {
+		t.Errorf("Rustlang parent = %d, want the top level", c.ParentID)
+	}
+
+	// Filing feeds shows up on GetFeeds
+	feed := addTestFeed(t, db, "https://go.dev/blog/feed.atom", "Go Blog")
+	for _, c := range []int{golang, rust, golang} {
+		if err := db.AddFeedToCategory(ctx, feed.ID, c); err != nil {
+			t.Fatalf("AddFeedToCategory(%d) error = %v", c, err)
+		}
+	}
+	addTestFeed(t, db, "https://example.com/feed.xml", "Uncategorized")
+	feeds, err := db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	if got := feeds[0].CategoryIDs; !slices.Equal(got, []int{golang, rust}) {
+		t.Errorf("CategoryIDs = %v, want %v", got, []int{golang, rust})
+	}
+	if got := feeds[1].CategoryIDs; got != nil {
+		t.Errorf("uncategorized feed CategoryIDs = %v, want none", got)
+	}
+
+	if err := db.RemoveFeedFromCategory(ctx, feeds[0].ID, rust); err != nil {
+		t.Fatalf("RemoveFeedFromCategory() error = %v", err)
+	}
+
+	// Deleting Tech takes Go with it but leaves the feed subscribed
+	if err := db.DeleteCategory(ctx, tech); err != nil {
+		t.Fatalf("DeleteCategory() error = %v", err)
+	}
+	categories, err := db.GetCategories(ctx)
+	if err != nil {
+		t.Fatalf("GetCategories() error = %v", err)
+	}
+	var names []string
+	for _, c := range categories {
+		names = append(names, c.Name)
+	}
+	if !slices.Equal(names, []string{"Go", "Rustlang"}) {
+		t.Errorf("categories after delete = %v, want [Go Rustlang]", names)
+	}
+	feeds, err = db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	if len(feeds) != 2 || feeds[0].CategoryIDs != nil {
+		t.Errorf("after delete got %d feeds, first filed under %v, want 2 and none", len(feeds), feeds[0].CategoryIDs)
+	}
+}
+
+func TestCategoryUnreadRollup(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	news, err := db.EnsureCategoryPath(ctx, []string{"News"})
+	if err != nil {
+		t.Fatalf("EnsureCategoryPath() error = %v", err)
+	}
+	world, err := db.EnsureCategoryPath(ctx, []string{"News", "World"})
+	if err != nil {
+		t.Fatalf("EnsureCategoryPath() error = %v", err)
+	}
+	local, err := db.EnsureCategoryPath(ctx, []string{"News", "Local"})
+	if err != nil {
+		t.Fatalf("EnsureCategoryPath() error = %v", err)
+	}
+
+	a := addTestFeed(t, db, "https://a.example.com/feed.xml", "A")
+	b := addTestFeed(t, db, "https://b.example.com/feed.xml", "B")
+	seedPosts(t, db, a, 3, start)
+	ids := seedPosts(t, db, b, 2, start)
+	if err := db.MarkRead(ctx, ids[0]); err != nil {
+		t.Fatalf("MarkRead() error = %v", err)
+	}
+
+	// A sits in both subcategories, it should only count once for News
+	links := []struct{ feed, category int }{{a.ID, world}, {a.ID, local}, {b.ID, local}}
+	for _, l := range links {
+		if err := db.AddFeedToCategory(ctx, l.feed, l.category); err != nil {
+			t.Fatalf("AddFeedToCategory() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		id   int
+		want int
+	}{
+		{news, 4},
+		{world, 3},
+		{local, 4},
+	}
+	categories, err := db.GetCategories(ctx)
+	if err != nil {
+		t.Fatalf("GetCategories() error = %v", err)
+	}
+	for _, tt := range tests {
+		i := slices.IndexFunc(categories, func(c models.Category) bool { return c.ID == tt.id })
+		if i < 0 {
+			t.Fatalf("category %d missing", tt.id)
+		}
+		if got := categories[i].Unread; got != tt.want {
+			t.Errorf("category %q unread = %d, want %d", categories[i].Name, got, tt.want)
+		}
+	}
+}
@@ -1,66 +1,103 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+// driverName is go-sqlite3 with the SQL functions the schema's triggers call
+const driverName = "sqlite3_warss"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("html_text", htmlText, true)
+		},
+	})
+}
+
 type DB struct {
 	conn *sql.DB
+	// search is false when SQLite was built without FTS5, see ensureSearchIndex
+	search bool
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx, so helpers can run in or out of a transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
-// init sqlite3 file and create tables if they don't exist
+// NewDB is NewDBContext without a context
 func NewDB(path string) (*DB, error) {
-	db, err := sql.Open("sqlite3", path)
+	return NewDBContext(context.Background(), path)
+}
+
+// NewDBContext opens the sqlite3 file at path and brings its schema up to date
+func NewDBContext(ctx context.Context, path string) (*DB, error) {
+	db, err := sql.Open(driverName, dsn(path))
 
 	if err != nil {
 		return nil, fmt.Errorf("error opening database: %w", err)
 	}
 
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
 		return nil, fmt.Errorf("error pinging database: %w", err)
 	}
 
-	if _, err := db.Exec(`PRAGMA foreign_keys = ON;`); err != nil {
-		return nil, fmt.Errorf("error enabling foreign keys %w", err)
+	if err := migrate(ctx, db); err != nil {
+		_ = db.Close()
+		return nil, err
 	}
 
-	query := `
-	CREATE TABLE IF NOT EXISTS feeds (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		url TEXT UNIQUE NOT NULL,
-		title TEXT
-	);`
-
-	if _, err := db.Exec(query); err != nil {
-		return nil, fmt.Errorf("error creating feed table: %w", err)
+	search, err := ensureSearchIndex(ctx, db)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
 	}
 
-	postQuery := `
-	CREATE TABLE IF NOT EXISTS posts (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		feed_id INTEGER NOT NULL,
-		title TEXT NOT NULL,
-		link TEXT UNIQUE NOT NULL,
-		content TEXT,
-		published_at DATETIME,
-		updated_at DATETIME,
-		read BOOLEAN DEFAULT 0,
-		FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
-	);
-	CREATE INDEX IF NOT EXISTS idx_post_feed_id ON posts(feed_id);
-	CREATE INDEX IF NOT EXISTS idx_post_feed_published ON posts(feed_id, published_at DESC);
-	`
-
-	if _, err := db.Exec(postQuery); err != nil {
-		return nil, fmt.Errorf("error creating posts table: %w", err)
-	}
+	return &DB{conn: db, search: search}, nil
+}
 
-	return &DB{conn: db}, nil
+// dsn adds the pragmas every pooled connection needs to path. A PRAGMA run
+// through database/sql only reaches one connection, so foreign keys (and the
+// cascades pruning relies on) go in the connection string instead.
+// auto_vacuum only takes effect on a new, empty file; older databases are
+// switched over by the first Vacuum.
+func dsn(path string) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + "_foreign_keys=on&_auto_vacuum=incremental"
 }
 
 func (d *DB) Close() error {
 	return d.conn.Close()
 }
+
+// withTx runs fn inside a transaction, committing if it succeeds and rolling back otherwise
+func (d *DB) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := d.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rerr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
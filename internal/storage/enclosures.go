@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// ErrNoEnclosure is returned when an enclosure id doesn't exist
+var ErrNoEnclosure = errors.New("no such enclosure")
+
+// EnclosureQuery selects enclosures for ListEnclosures, the zero value lists
+// every one
+type EnclosureQuery struct {
+	PostID int
+	FeedID int
+	// States limits enclosures to these download states when not empty
+	States []models.DownloadState
+}
+
+const enclosureColumns = `e.id, e.post_id, p.feed_id, e.url, COALESCE(e.type, ''), COALESCE(e.length, 0),
+	COALESCE(e.duration, 0), e.download_state, COALESCE(e.download_path, ''), e.downloaded,
+	COALESCE(e.download_error, '')`
+
+func scanEnclosure(row rowScanner) (models.Enclosure, error) {
+	var (
+		e       models.Enclosure
+		seconds int64
+	)
+	err := row.Scan(&e.ID, &e.PostID, &e.FeedID, &e.URL, &e.Type, &e.Length,
+		&seconds, &e.State, &e.Path, &e.Downloaded, &e.Error)
+	e.Duration = time.Duration(seconds) * time.Second
+	return e, err
+}
+
+// ListEnclosures returns the enclosures matching q, newest posts first and in
+// the order the feed listed them within a post
+func (d *DB) ListEnclosures(ctx context.Context, q EnclosureQuery) ([]models.Enclosure, error) {
+	query := `
+		SELECT ` + enclosureColumns + `
+		FROM enclosures e
+		JOIN posts p ON p.id = e.post_id
+		WHERE 1 = 1`
+	var args []any
+	if q.PostID != 0 {
+		query += ` AND e.post_id = ?`
+		args = append(args, q.PostID)
+	}
+	if q.FeedID != 0 {
+		query += ` AND p.feed_id = ?`
+		args = append(args, q.FeedID)
+	}
+	if len(q.States) > 0 {
+		query += ` AND e.download_state IN (?` + strings.Repeat(", ?", len(q.States)-1) + `)`
+		for _, s := range q.States {
+			args = append(args, s)
+		}
+	}
+	query += ` ORDER BY p.published_at DESC, p.id DESC, e.id`
+
+	rows, err := d.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list enclosures: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("error closing rows: %v\n", err)
+		}
+	}()
+
+	var enclosures []models.Enclosure
+	for rows.Next() {
+		e, err := scanEnclosure(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan enclosure: %w", err)
+		}
+		enclosures = append(enclosures, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating enclosures: %w", err)
+	}
+	return enclosures, nil
+}
+
+// GetEnclosure returns one enclosure, ErrNoEnclosure if there is none with id
+func (d *DB) GetEnclosure(ctx context.Context, id int) (models.Enclosure, error) {
+	query := `
+		SELECT ` + enclosureColumns + `
+		FROM enclosures e
+		JOIN posts p ON p.id = e.post_id
+		WHERE e.id = ?
+	`
+	e, err := scanEnclosure(d.conn.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Enclosure{}, fmt.Errorf("%w: %d", ErrNoEnclosure, id)
+	}
+	if err != nil {
+		return models.Enclosure{}, fmt.Errorf("failed to get enclosure %d: %w", id, err)
+	}
+	return e, nil
+}
+
+// UpdateDownload stores the download state of e: its state, path, bytes
+// downloaded and error. What the feed said about the file is left alone.
+func (d *DB) UpdateDownload(ctx context.Context, e models.Enclosure) error {
+	query := `
+		UPDATE enclosures
+		SET download_state = ?, download_path = NULLIF(?, ''), downloaded = ?, download_error = NULLIF(?, '')
+		WHERE id = ?
+	`
+	res, err := d.conn.ExecContext(ctx, query, e.State, e.Path, e.Downloaded, e.Error, e.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update download of enclosure %d: %w", e.ID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %d", ErrNoEnclosure, e.ID)
+	}
+	return nil
+}
+
+// downloadFiles returns what downloads of the posts postIDs selects may have
+// left on disk, finished files and part files alike. postIDs is a query
+// returning post ids, run with args.
+func downloadFiles(ctx context.Context, q querier, postIDs string, args ...any) ([]string, error) {
+	query := `SELECT download_path FROM enclosures WHERE download_path IS NOT NULL AND post_id IN (` + postIDs + `)`
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find downloads: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("error closing rows: %v\n", err)
+		}
+	}()
+
+	var files []string
+	for rows.Next() {
+		var e models.Enclosure
+		if err := rows.Scan(&e.Path); err != nil {
+			return nil, fmt.Errorf("failed to scan download path: %w", err)
+		}
+		files = append(files, e.Path, e.PartPath())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating downloads: %w", err)
+	}
+	return files, nil
+}
+
+// removeDownloads deletes the files of enclosures that are gone from the
+// database. It runs once they're gone for good, files that were never there
+// are fine.
+func removeDownloads(files []string) error {
+	var errs []error
+	for _, f := range files {
+		if err := os.Remove(f); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to remove downloads: %w", err)
+	}
+	return nil
+}
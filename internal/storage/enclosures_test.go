@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+func TestEnclosures(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	feed := addTestFeed(t, db, "https://example.com/podcast.xml", "Podcast")
+	other := addTestFeed(t, db, "https://example.com/other.xml", "Other")
+	published := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	episode := models.Enclosure{
+		URL:      "https://cdn.example.com/1.mp3",
+		Type:     "audio/mpeg",
+		Length:   12345678,
+		Duration: 42*time.Minute + 5*time.Second,
+	}
+	feed.Posts = []models.Post{
+		{GUID: "1", Title: "Episode 1", PublishedAt: published, Enclosures: []models.Enclosure{episode}},
+		{GUID: "2", Title: "Episode 2", PublishedAt: published.Add(time.Hour), Enclosures: []models.Enclosure{
+			{URL: "https://cdn.example.com/2.mp3", Type: "audio/mpeg"},
+			{URL: "https://cdn.example.com/2.jpg"},
+		}},
+	}
+	if _, err := db.SaveFeed(ctx, feed); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	other.Posts = []models.Post{{GUID: "x", Title: "Other", PublishedAt: published, Enclosures: []models.Enclosure{{URL: "https://example.com/x.pdf"}}}}
+	if _, err := db.SaveFeed(ctx, other); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+
+	all, err := db.ListEnclosures(ctx, EnclosureQuery{FeedID: feed.ID})
+	if err != nil {
+		t.Fatalf("ListEnclosures() error = %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("got %d enclosures, want 3", len(all))
+	}
+	// Newest post first, then in feed order
+	if all[0].URL != "https://cdn.example.com/2.mp3" || all[1].URL != "https://cdn.example.com/2.jpg" {
+		t.Errorf("enclosures in order %q, %q", all[0].URL, all[1].URL)
+	}
+	got := all[2]
+	if got.URL != episode.URL || got.Type != episode.Type || got.Length != episode.Length ||
+		got.Duration != episode.Duration || got.FeedID != feed.ID || got.State != models.DownloadNone {
+		t.Errorf("enclosure = %+v, want %+v in state none", got, episode)
+	}
+
+	// Download progress survives the feed being saved again with new details
+	got.State = models.DownloadDone
+	got.Path = "/tmp/1.mp3"
+	got.Downloaded = got.Length
+	if err := db.UpdateDownload(ctx, got); err != nil {
+		t.Fatalf("UpdateDownload() error = %v", err)
+	}
+	feed.Posts[0].Enclosures[0].Length = 999
+	if _, err := db.SaveFeed(ctx, feed); err != nil {
+		t.Fatalf("SaveFeed() again error = %v", err)
+	}
+	got, err = db.GetEnclosure(ctx, got.ID)
+	if err != nil {
+		t.Fatalf("GetEnclosure() error = %v", err)
+	}
+	if got.State != models.DownloadDone || got.Path != "/tmp/1.mp3" || got.Downloaded != 12345678 || got.Length != 999 {
+		t.Errorf("after resave enclosure = %+v", got)
+	}
+
+	done, err := db.ListEnclosures(ctx, EnclosureQuery{States: []models.DownloadState{models.DownloadDone, models.DownloadFailed}})
+	if err != nil {
+		t.Fatalf("ListEnclosures() by state error = %v", err)
+	}
+	if len(done) != 1 || done[0].ID != got.ID {
+		t.Errorf("done enclosures = %+v, want just %d", done, got.ID)
+	}
+
+	if _, err := db.GetEnclosure(ctx, 9999); !errors.Is(err, ErrNoEnclosure) {
+		t.Errorf("GetEnclosure(9999) error = %v, want ErrNoEnclosure", err)
+	}
+	if err := db.UpdateDownload(ctx, models.Enclosure{ID: 9999, State: models.DownloadQueued}); !errors.Is(err, ErrNoEnclosure) {
+		t.Errorf("UpdateDownload(9999) error = %v, want ErrNoEnclosure", err)
+	}
+
+	// Enclosures go with their post
+	if err := db.DeleteFeed(feed.ID); err != nil {
+		t.Fatalf("DeleteFeed() error = %v", err)
+	}
+	left, err := db.ListEnclosures(ctx, EnclosureQuery{})
+	if err != nil {
+		t.Fatalf("ListEnclosures() error = %v", err)
+	}
+	if len(left) != 1 || left[0].FeedID != other.ID {
+		t.Errorf("after deleting the feed %d enclosures are left, want only the other feed's", len(left))
+	}
+}
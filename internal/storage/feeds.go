@@ -1,24 +1,63 @@
 package storage
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/pixel-87/warss/internal/models"
 )
 
+// ErrFeedExists is returned when subscribing to a URL that's already
+// subscribed to, or was before the feed moved
+var ErrFeedExists = errors.New("already subscribed")
+
+// AddFeed is AddFeedContext without a context
 func (d *DB) AddFeed(url, title string) error {
+	return d.AddFeedContext(context.Background(), url, title)
+}
+
+// AddFeedContext subscribes to the feed at url, ErrFeedExists if it's
+// subscribed to already
+func (d *DB) AddFeedContext(ctx context.Context, url, title string) error {
+	id, err := feedByURL(ctx, d.conn, url)
+	if err != nil {
+		return err
+	}
+	if id != 0 {
+		return fmt.Errorf("%w: %s is feed %d", ErrFeedExists, url, id)
+	}
+
 	query := `INSERT INTO feeds (url, title) VALUES (?, ?)`
-	_, err := d.conn.Exec(query, url, title)
+	_, err = d.conn.ExecContext(ctx, query, url, title)
 	if err != nil {
 		return fmt.Errorf("failed to add feed %q: %w", url, err)
 	}
 	return nil
 }
 
+// GetFeeds is GetFeedsContext without a context
 func (d *DB) GetFeeds() ([]models.Feed, error) {
-	rows, err := d.conn.Query("SELECT id, url, title FROM feeds")
+	return d.GetFeedsContext(context.Background())
+}
+
+// GetFeedsContext returns every subscription with its unread count, categories,
+// health, schedule and old URLs
+func (d *DB) GetFeedsContext(ctx context.Context) ([]models.Feed, error) {
+	query := `
+		SELECT f.id, f.url, f.title, COALESCE(f.etag, ''), COALESCE(f.last_modified, ''),
+			COALESCE(f.site_url, ''), COALESCE(f.description, ''), COALESCE(f.icon_url, ''),
+			COALESCE(f.logo_url, ''), COALESCE(f.language, ''), ` + healthColumns + `, ` + scheduleColumns + `,
+			(SELECT COUNT(*) FROM posts p WHERE p.feed_id = f.id AND p.read = 0),
+			COALESCE((SELECT GROUP_CONCAT(category_id) FROM feed_categories fc WHERE fc.feed_id = f.id), ''),
+			COALESCE((SELECT GROUP_CONCAT(url, char(10)) FROM feed_aliases a WHERE a.feed_id = f.id), '')
+		FROM feeds f
+	`
+	rows, err := d.conn.QueryContext(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to get feeds: %w", err)
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
@@ -28,8 +67,26 @@ func (d *DB) GetFeeds() ([]models.Feed, error) {
 
 	var feeds []models.Feed
 	for rows.Next() {
-		var f models.Feed
-		if err := rows.Scan(&f.ID, &f.URL, &f.Title); err != nil {
+		var (
+			f          models.Feed
+			health     healthRow
+			schedule   scheduleRow
+			categories string
+			aliases    string
+		)
+		dest := []any{&f.ID, &f.URL, &f.Title, &f.ETag, &f.LastModified,
+			&f.SiteURL, &f.Description, &f.IconURL, &f.LogoURL, &f.Language}
+		dest = append(dest, health.dest()...)
+		dest = append(dest, schedule.dest()...)
+		if err := rows.Scan(append(dest, &f.Unread, &categories, &aliases)...); err != nil {
+			return nil, err
+		}
+		f.Health = health.health()
+		if f.Schedule, err = schedule.schedule(); err != nil {
+			return nil, err
+		}
+		f.Aliases = splitList(aliases)
+		if f.CategoryIDs, err = splitIDs(categories); err != nil {
 			return nil, err
 		}
 		feeds = append(feeds, f)
@@ -40,25 +97,213 @@ func (d *DB) GetFeeds() ([]models.Feed, error) {
 	return feeds, nil
 }
 
+// UpdateFeed is UpdateFeedContext without a context
 func (d *DB) UpdateFeed(f models.Feed) error {
+	return d.UpdateFeedContext(context.Background(), f)
+}
+
+// UpdateFeedContext stores a feed's url and title
+func (d *DB) UpdateFeedContext(ctx context.Context, f models.Feed) error {
+	return updateFeed(ctx, d.conn, f)
+}
+
+func updateFeed(ctx context.Context, q querier, f models.Feed) error {
 	query := `
 		UPDATE feeds
 		SET url = ?, title = ?
 		WHERE id = ?
 	`
 
-	_, err := d.conn.Exec(query, f.URL, f.Title, f.ID)
+	_, err := q.ExecContext(ctx, query, f.URL, f.Title, f.ID)
 	if err != nil {
 		return fmt.Errorf("failed to update feed %d: %w", f.ID, err)
 	}
 	return nil
 }
 
+// setFeedMetadata stores what the feed says about itself. Empty values clear
+// what was stored, a feed that drops its icon shouldn't keep showing the old one.
+func setFeedMetadata(ctx context.Context, q querier, f models.Feed) error {
+	query := `
+		UPDATE feeds
+		SET site_url = NULLIF(?, ''), description = NULLIF(?, ''), icon_url = NULLIF(?, ''),
+			logo_url = NULLIF(?, ''), language = NULLIF(?, '')
+		WHERE id = ?
+	`
+
+	_, err := q.ExecContext(ctx, query, f.SiteURL, f.Description, f.IconURL, f.LogoURL, f.Language, f.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update metadata of feed %d: %w", f.ID, err)
+	}
+	return nil
+}
+
+// DeleteFeed is DeleteFeedContext without a context
 func (d *DB) DeleteFeed(id int) error {
-	query := `DELETE FROM feeds WHERE id = ?`
-	_, err := d.conn.Exec(query, id)
+	return d.DeleteFeedContext(context.Background(), id)
+}
+
+// DeleteFeedContext unsubscribes from a feed, its posts and their downloaded
+// enclosures go with it
+func (d *DB) DeleteFeedContext(ctx context.Context, id int) error {
+	var files []string
+	err := d.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		if files, err = downloadFiles(ctx, tx, `SELECT id FROM posts WHERE feed_id = ?`, id); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM feeds WHERE id = ?`, id)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("could not delete feed: %w", err)
 	}
+	return removeDownloads(files)
+}
+
+// GetFeedValidators is GetFeedValidatorsContext without a context
+func (d *DB) GetFeedValidators(url string) (etag, lastModified string, err error) {
+	return d.GetFeedValidatorsContext(context.Background(), url)
+}
+
+// GetFeedValidatorsContext returns the ETag and Last-Modified values stored for a feed url.
+// Unknown feeds have no validators, so that is not an error.
+func (d *DB) GetFeedValidatorsContext(ctx context.Context, url string) (etag, lastModified string, err error) {
+	query := `
+		SELECT COALESCE(etag, ''), COALESCE(last_modified, '')
+		FROM feeds
+		WHERE url = ?
+	`
+
+	err = d.conn.QueryRowContext(ctx, query, url).Scan(&etag, &lastModified)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get validators for feed %q: %w", url, err)
+	}
+	return etag, lastModified, nil
+}
+
+// SetFeedValidators is SetFeedValidatorsContext without a context
+func (d *DB) SetFeedValidators(url, etag, lastModified string) error {
+	return d.SetFeedValidatorsContext(context.Background(), url, etag, lastModified)
+}
+
+// SetFeedValidatorsContext stores the ETag and Last-Modified values for a feed url
+func (d *DB) SetFeedValidatorsContext(ctx context.Context, url, etag, lastModified string) error {
+	return setFeedValidators(ctx, d.conn, url, etag, lastModified)
+}
+
+func setFeedValidators(ctx context.Context, q querier, url, etag, lastModified string) error {
+	query := `
+		UPDATE feeds
+		SET etag = ?, last_modified = ?
+		WHERE url = ?
+	`
+
+	_, err := q.ExecContext(ctx, query, etag, lastModified, url)
+	if err != nil {
+		return fmt.Errorf("failed to set validators for feed %q: %w", url, err)
+	}
+	return nil
+}
+
+// AddFeeds subscribes to many feeds in one transaction. Feeds whose url is
+// already stored, as a feed or the old URL of one, or repeated earlier in the
+// list are skipped rather than
+// failing the batch, added[i] reports whether feeds[i] was actually inserted.
+func (d *DB) AddFeeds(ctx context.Context, feeds []models.Feed) (added []bool, err error) {
+	added = make([]bool, len(feeds))
+	err = d.withTx(ctx, func(tx *sql.Tx) error {
+		// A feed that moved still owns its old URL
+		stmt, err := tx.PrepareContext(ctx, `
+			INSERT INTO feeds (url, title)
+			SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM feed_aliases WHERE url = ?)
+			ON CONFLICT(url) DO NOTHING
+		`)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := stmt.Close(); err != nil {
+				fmt.Printf("error closing statement: %v\n", err)
+			}
+		}()
+
+		for i, f := range feeds {
+			res, err := stmt.ExecContext(ctx, f.URL, f.Title, f.URL)
+			if err != nil {
+				return fmt.Errorf("failed to add feed %q: %w", f.URL, err)
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			added[i] = n == 1
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// feedByURL returns the id of the feed at url, or that used to be before it
+// moved, 0 if there is none
+func feedByURL(ctx context.Context, q querier, url string) (int, error) {
+	query := `
+		SELECT id FROM feeds WHERE url = ?
+		UNION ALL
+		SELECT feed_id FROM feed_aliases WHERE url = ?
+		LIMIT 1
+	`
+
+	var id int
+	err := q.QueryRowContext(ctx, query, url, url).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up feed %q: %w", url, err)
+	}
+	return id, nil
+}
+
+// MoveFeed changes a feed's URL, keeping the old one as an alias so the feed
+// is still found by it. ErrFeedExists is returned when another feed is
+// already at url.
+func (d *DB) MoveFeed(ctx context.Context, id int, url string) error {
+	err := d.withTx(ctx, func(tx *sql.Tx) error {
+		var old string
+		if err := tx.QueryRowContext(ctx, `SELECT url FROM feeds WHERE id = ?`, id).Scan(&old); err != nil {
+			return err
+		}
+		if old == url {
+			return nil
+		}
+		owner, err := feedByURL(ctx, tx, url)
+		if err != nil {
+			return err
+		}
+		if owner != 0 && owner != id {
+			return fmt.Errorf("%w: %s is feed %d", ErrFeedExists, url, owner)
+		}
+
+		// Moving back to an address it had before drops that alias
+		if _, err := tx.ExecContext(ctx, `DELETE FROM feed_aliases WHERE url = ?`, url); err != nil {
+			return err
+		}
+		query := `INSERT INTO feed_aliases (url, feed_id, moved_at) VALUES (?, ?, ?)`
+		if _, err := tx.ExecContext(ctx, query, old, id, time.Now().UTC()); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE feeds SET url = ? WHERE id = ?`, url, id)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move feed %d to %s: %w", id, url, err)
+	}
 	return nil
 }
@@ -1,15 +1,18 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/pixel-87/warss/internal/models"
 )
 
 // Helper to create a temporary test database
-func setupTestDB(t *testing.T) *DB {
+func setupTestDB(t testing.TB) *DB {
 	tmpFile := "test_feeds.db"
 	// Clean up any previous test file
 	_ = os.Remove(tmpFile)
@@ -501,3 +504,171 @@ func TestFeedTitleWithEmptyURL(t *testing.T) {
 		t.Fatalf("expected to find feed with empty URL and title %q in database", "No URL Feed")
 	}
 }
+
+// TestFeedValidators verifies ETag / Last-Modified round trip through the feeds table
+func TestFeedValidators(t *testing.T) {
+	db := setupTestDB(t)
+
+	url := "https://example.com/feed.xml"
+	if err := db.AddFeed(url, "Example"); err != nil {
+		t.Fatalf("failed to add feed: %v", err)
+	}
+
+	etag, lastModified, err := db.GetFeedValidators(url)
+	if err != nil {
+		t.Fatalf("GetFeedValidators() error = %v", err)
+	}
+	if etag != "" || lastModified != "" {
+		t.Errorf("new feed should have no validators, got %q, %q", etag, lastModified)
+	}
+
+	wantETag := `"abc123"`
+	wantLastModified := "Mon, 02 Jan 2006 15:04:05 GMT"
+	if err := db.SetFeedValidators(url, wantETag, wantLastModified); err != nil {
+		t.Fatalf("SetFeedValidators() error = %v", err)
+	}
+
+	etag, lastModified, err = db.GetFeedValidators(url)
+	if err != nil {
+		t.Fatalf("GetFeedValidators() error = %v", err)
+	}
+	if etag != wantETag || lastModified != wantLastModified {
+		t.Errorf("got validators %q, %q, want %q, %q", etag, lastModified, wantETag, wantLastModified)
+	}
+
+	feeds, err := db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	if feeds[0].ETag != wantETag || feeds[0].LastModified != wantLastModified {
+		t.Errorf("GetFeeds() validators = %q, %q, want %q, %q", feeds[0].ETag, feeds[0].LastModified, wantETag, wantLastModified)
+	}
+
+	// Unknown feeds just have no validators
+	etag, lastModified, err = db.GetFeedValidators("https://unknown.example.com/feed.xml")
+	if err != nil {
+		t.Fatalf("GetFeedValidators() unknown feed error = %v", err)
+	}
+	if etag != "" || lastModified != "" {
+		t.Errorf("unknown feed should have no validators, got %q, %q", etag, lastModified)
+	}
+}
+
+// TestAddFeeds verifies bulk inserts skip duplicates instead of failing
+func TestAddFeeds(t *testing.T) {
+	db := setupTestDB(t)
+
+	if err := db.AddFeed("https://example.com/existing.xml", "Existing"); err != nil {
+		t.Fatalf("failed to add feed: %v", err)
+	}
+
+	feeds := []models.Feed{
+		{URL: "https://example.com/new.xml", Title: "New"},
+		{URL: "https://example.com/existing.xml", Title: "Existing Again"},
+		{URL: "https://example.com/new.xml", Title: "New Again"},
+		{URL: "https://example.com/other.xml", Title: "Other"},
+	}
+	added, err := db.AddFeeds(context.Background(), feeds)
+	if err != nil {
+		t.Fatalf("AddFeeds() error = %v", err)
+	}
+
+	want := []bool{true, false, false, true}
+	for i := range want {
+		if added[i] != want[i] {
+			t.Errorf("added[%d] = %t, want %t", i, added[i], want[i])
+		}
+	}
+
+	stored, err := db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	if len(stored) != 3 {
+		t.Errorf("got %d feeds, want 3", len(stored))
+	}
+}
+
+// TestContextCancel checks a cancelled context stops queries and writes
+// before they touch the database
+func TestContextCancel(t *testing.T) {
+	db := setupTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := db.GetFeedsContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetFeedsContext() error = %v, want context.Canceled", err)
+	}
+	if err := db.AddFeedContext(ctx, "https://example.com/feed.xml", "Example"); !errors.Is(err, context.Canceled) {
+		t.Errorf("AddFeedContext() error = %v, want context.Canceled", err)
+	}
+	if _, err := db.AddPosts(ctx, 1, []models.Post{{GUID: "1"}}); !errors.Is(err, context.Canceled) {
+		t.Errorf("AddPosts() error = %v, want context.Canceled", err)
+	}
+	if _, err := NewDBContext(ctx, filepath.Join(t.TempDir(), "rss.db")); !errors.Is(err, context.Canceled) {
+		t.Errorf("NewDBContext() error = %v, want context.Canceled", err)
+	}
+
+	feeds, err := db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	if len(feeds) != 0 {
+		t.Errorf("got %d feeds after cancelled writes, want 0", len(feeds))
+	}
+}
+
+// TestMoveFeed checks a moved feed keeps its old URL as an alias that still
+// counts as subscribed
+func TestMoveFeed(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	const (
+		oldURL = "https://example.com/feed.xml"
+		newURL = "https://example.org/feed.xml"
+	)
+	feed := addTestFeed(t, db, oldURL, "Moving")
+	other := addTestFeed(t, db, "https://example.com/other.xml", "Other")
+
+	if err := db.MoveFeed(ctx, feed.ID, newURL); err != nil {
+		t.Fatalf("MoveFeed() error = %v", err)
+	}
+	feed = reloadFeed(t, db, feed.ID)
+	if feed.URL != newURL || len(feed.Aliases) != 1 || feed.Aliases[0] != oldURL {
+		t.Errorf("moved feed at %q with aliases %q, want %q with the old url", feed.URL, feed.Aliases, newURL)
+	}
+
+	for _, url := range []string{oldURL, newURL} {
+		if err := db.AddFeed(url, "Again"); !errors.Is(err, ErrFeedExists) {
+			t.Errorf("AddFeed(%q) error = %v, want ErrFeedExists", url, err)
+		}
+	}
+	added, err := db.AddFeeds(ctx, []models.Feed{{URL: oldURL}, {URL: "https://example.com/new.xml"}})
+	if err != nil {
+		t.Fatalf("AddFeeds() error = %v", err)
+	}
+	if added[0] || !added[1] {
+		t.Errorf("AddFeeds() added = %v, want the old url skipped", added)
+	}
+
+	if err := db.MoveFeed(ctx, feed.ID, other.URL); !errors.Is(err, ErrFeedExists) {
+		t.Errorf("MoveFeed() onto another feed error = %v, want ErrFeedExists", err)
+	}
+
+	// Moving back drops the alias it returns to
+	if err := db.MoveFeed(ctx, feed.ID, oldURL); err != nil {
+		t.Fatalf("MoveFeed() back error = %v", err)
+	}
+	feed = reloadFeed(t, db, feed.ID)
+	if feed.URL != oldURL || len(feed.Aliases) != 1 || feed.Aliases[0] != newURL {
+		t.Errorf("moved back feed at %q with aliases %q", feed.URL, feed.Aliases)
+	}
+
+	// Aliases go with the feed
+	if err := db.DeleteFeed(feed.ID); err != nil {
+		t.Fatalf("DeleteFeed() error = %v", err)
+	}
+	if err := db.AddFeed(newURL, "Fresh"); err != nil {
+		t.Errorf("AddFeed() of a deleted feed's old url error = %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// healthColumns are the feed health columns, read with healthRow
+const healthColumns = `f.last_fetch_at, f.last_success_at, f.fetch_errors, COALESCE(f.last_error, ''),
+	COALESCE(f.last_status, 0), f.retry_at, COALESCE(f.moved_to, ''), f.moved_count, f.redirected_to_page,
+	f.last_new_post_at`
+
+// healthRow scans healthColumns, the times are NULL until there's one to store
+type healthRow struct {
+	lastFetch, lastSuccess, retry, lastNewPost sql.NullTime
+	h                                          models.FeedHealth
+}
+
+func (r *healthRow) dest() []any {
+	return []any{&r.lastFetch, &r.lastSuccess, &r.h.Errors, &r.h.LastError,
+		&r.h.LastStatus, &r.retry, &r.h.MovedTo, &r.h.MovedCount, &r.h.RedirectedToPage, &r.lastNewPost}
+}
+
+func (r *healthRow) health() models.FeedHealth {
+	h := r.h
+	h.LastFetchAt = r.lastFetch.Time
+	h.LastSuccessAt = r.lastSuccess.Time
+	h.RetryAt = r.retry.Time
+	h.LastNewPostAt = r.lastNewPost.Time
+	return h
+}
+
+// nullTime stores the zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+// SetFeedHealth stores how the last fetch of a feed went. LastNewPostAt is
+// left alone, SaveFeed keeps that up to date.
+func (d *DB) SetFeedHealth(ctx context.Context, feedID int, h models.FeedHealth) error {
+	query := `
+		UPDATE feeds
+		SET last_fetch_at = ?, last_success_at = ?, fetch_errors = ?, last_error = NULLIF(?, ''),
+			last_status = NULLIF(?, 0), retry_at = ?, moved_to = NULLIF(?, ''), moved_count = ?,
+			redirected_to_page = ?
+		WHERE id = ?
+	`
+
+	_, err := d.conn.ExecContext(ctx, query, nullTime(h.LastFetchAt), nullTime(h.LastSuccessAt), h.Errors,
+		h.LastError, h.LastStatus, nullTime(h.RetryAt), h.MovedTo, h.MovedCount, h.RedirectedToPage, feedID)
+	if err != nil {
+		return fmt.Errorf("failed to set health of feed %d: %w", feedID, err)
+	}
+	return nil
+}
+
+// touchLastNewPost records that a fetch of the feed just brought new posts
+func touchLastNewPost(ctx context.Context, q querier, feedID int) error {
+	query := `UPDATE feeds SET last_new_post_at = ? WHERE id = ?`
+	if _, err := q.ExecContext(ctx, query, time.Now().UTC(), feedID); err != nil {
+		return fmt.Errorf("failed to update last new post of feed %d: %w", feedID, err)
+	}
+	return nil
+}
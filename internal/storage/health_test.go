@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+func TestFeedHealth(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	feed := addTestFeed(t, db, "https://example.com/feed.xml", "Feed")
+	if !feed.Health.LastFetchAt.IsZero() || feed.Health.Errors != 0 {
+		t.Errorf("new feed health = %+v, want nothing recorded", feed.Health)
+	}
+
+	fetched := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	want := models.FeedHealth{
+		LastFetchAt:   fetched,
+		LastSuccessAt: fetched.Add(-72 * time.Hour),
+		Errors:        3,
+		LastError:     "unexpected status: 404 Not Found",
+		LastStatus:    404,
+		RetryAt:       fetched.Add(2 * time.Hour),
+		MovedTo:       "https://example.org/feed.xml",
+	}
+	if err := db.SetFeedHealth(ctx, feed.ID, want); err != nil {
+		t.Fatalf("SetFeedHealth() error = %v", err)
+	}
+	got := reloadFeed(t, db, feed.ID)
+	if !got.Health.LastFetchAt.Equal(want.LastFetchAt) || !got.Health.LastSuccessAt.Equal(want.LastSuccessAt) ||
+		!got.Health.RetryAt.Equal(want.RetryAt) || got.Health.Errors != want.Errors ||
+		got.Health.LastError != want.LastError || got.Health.LastStatus != want.LastStatus ||
+		got.Health.MovedTo != want.MovedTo {
+		t.Errorf("health = %+v, want %+v", got.Health, want)
+	}
+
+	// Recovering clears the error and the retry time
+	if err := db.SetFeedHealth(ctx, feed.ID, models.FeedHealth{LastFetchAt: fetched, LastSuccessAt: fetched, LastStatus: 200}); err != nil {
+		t.Fatalf("SetFeedHealth() error = %v", err)
+	}
+	got = reloadFeed(t, db, feed.ID)
+	if got.Health.Errors != 0 || got.Health.LastError != "" || !got.Health.RetryAt.IsZero() || got.Health.MovedTo != "" {
+		t.Errorf("recovered health = %+v, want no error", got.Health)
+	}
+
+	// Only saves that bring new posts count as the feed being alive
+	got.Posts = []models.Post{{GUID: "1", Title: "One", PublishedAt: fetched}}
+	before := time.Now().Add(-time.Second)
+	if _, err := db.SaveFeed(ctx, got); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	got = reloadFeed(t, db, got.ID)
+	if got.Health.LastNewPostAt.Before(before) {
+		t.Errorf("last new post = %v, want just now", got.Health.LastNewPostAt)
+	}
+	first := got.Health.LastNewPostAt
+	if _, err := db.SaveFeed(ctx, got); err != nil {
+		t.Fatalf("SaveFeed() again error = %v", err)
+	}
+	if again := reloadFeed(t, db, got.ID); !again.Health.LastNewPostAt.Equal(first) {
+		t.Errorf("last new post moved to %v on a save with nothing new", again.Health.LastNewPostAt)
+	}
+}
+
+// reloadFeed reads a feed back from the database
+func reloadFeed(t *testing.T, db *DB, id int) models.Feed {
+	t.Helper()
+	feeds, err := db.GetFeedsContext(context.Background())
+	if err != nil {
+		t.Fatalf("GetFeedsContext() error = %v", err)
+	}
+	for _, f := range feeds {
+		if f.ID == id {
+			return f
+		}
+	}
+	t.Fatalf("feed %d not found", id)
+	return models.Feed{}
+}
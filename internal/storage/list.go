@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// SortOrder is the order ListPosts returns posts in, by published date
+type SortOrder int
+
+const (
+	NewestFirst SortOrder = iota
+	OldestFirst
+)
+
+// PostQuery selects posts for ListPosts. The zero value lists every post,
+// newest first.
+type PostQuery struct {
+	// FeedIDs limits posts to these feeds when not empty
+	FeedIDs []int
+	// CategoryID limits posts to feeds in this category or any below it
+	CategoryID int
+	Read       ReadFilter
+	// Starred only lists starred posts
+	Starred bool
+	// Since and Until bound published_at, each ignored when zero. They can be
+	// in any time zone.
+	Since time.Time
+	Until time.Time
+	Order SortOrder
+	// Limit defaults to 50, After continues from a cursor ListPosts returned
+	Limit int
+	After Cursor
+}
+
+// ListPosts returns a page of posts matching q and the cursor for the page
+// after it, empty once there are no more. Pages are keyed on published_at and
+// id rather than an offset, so deep pages of a long timeline cost the same as
+// the first and posts arriving between pages don't shift what comes next.
+func (d *DB) ListPosts(ctx context.Context, q PostQuery) ([]models.Post, Cursor, error) {
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts
+		WHERE 1 = 1`
+	var args []any
+
+	if len(q.FeedIDs) > 0 {
+		query += ` AND feed_id IN (?` + strings.Repeat(", ?", len(q.FeedIDs)-1) + `)`
+		for _, id := range q.FeedIDs {
+			args = append(args, id)
+		}
+	}
+	if q.CategoryID != 0 {
+		query += ` AND feed_id IN (
+			WITH RECURSIVE tree(id) AS (
+				SELECT ?
+				UNION
+				SELECT categories.id FROM categories JOIN tree ON categories.parent_id = tree.id
+			)
+			SELECT feed_id FROM feed_categories WHERE category_id IN (SELECT id FROM tree)
+		)`
+		args = append(args, q.CategoryID)
+	}
+	switch q.Read {
+	case UnreadPosts:
+		query += ` AND read = 0`
+	case ReadPosts:
+		query += ` AND read = 1`
+	}
+	if q.Starred {
+		query += ` AND starred_at IS NOT NULL`
+	}
+	if !q.Since.IsZero() {
+		query += ` AND published_at >= ?`
+		args = append(args, q.Since.UTC())
+	}
+	if !q.Until.IsZero() {
+		query += ` AND published_at < ?`
+		args = append(args, q.Until.UTC())
+	}
+
+	order, past := `DESC`, `<`
+	if q.Order == OldestFirst {
+		order, past = `ASC`, `>`
+	}
+	if q.After != "" {
+		at, id, err := q.After.decode()
+		if err != nil {
+			return nil, "", err
+		}
+		query += ` AND (published_at ` + past + ` ? OR (published_at = ? AND id ` + past + ` ?))`
+		args = append(args, at, at, id)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	// One extra row tells whether there's another page
+	query += ` ORDER BY published_at ` + order + `, id ` + order + ` LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := d.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list posts: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("error closing rows: %v\n", err)
+		}
+	}()
+
+	var posts []models.Post
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("error iterating posts: %w", err)
+	}
+
+	if len(posts) <= limit {
+		return posts, "", nil
+	}
+	posts = posts[:limit]
+	last := posts[limit-1]
+	return posts, newCursor(last.PublishedAt, last.ID), nil
+}
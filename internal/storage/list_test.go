@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// listAll pages through ListPosts two at a time and returns the post ids in order
+func listAll(t *testing.T, db *DB, q PostQuery) []int {
+	t.Helper()
+	q.Limit = 2
+	var ids []int
+	for page := 0; ; page++ {
+		posts, next, err := db.ListPosts(context.Background(), q)
+		if err != nil {
+			t.Fatalf("ListPosts() page %d error = %v", page, err)
+		}
+		for _, p := range posts {
+			ids = append(ids, p.ID)
+		}
+		if next == "" {
+			return ids
+		}
+		if page > 20 {
+			t.Fatal("ListPosts() never ran out of pages")
+		}
+		q.After = next
+	}
+}
+
+func TestListPosts(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	a := addTestFeed(t, db, "https://a.example.com/feed.xml", "A")
+	b := addTestFeed(t, db, "https://b.example.com/feed.xml", "B")
+	c := addTestFeed(t, db, "https://c.example.com/feed.xml", "C")
+	// Both feeds publish on the hour, so every page boundary falls on a tie
+	idsA := seedPosts(t, db, a, 3, start)
+	idsB := seedPosts(t, db, b, 3, start)
+	// Posts without a date sort as the oldest
+	c.Posts = []models.Post{{GUID: "undated", Title: "Undated"}}
+	if _, err := db.SaveFeed(ctx, c); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	var undated int
+	if err := db.conn.QueryRow(`SELECT id FROM posts WHERE guid = 'undated'`).Scan(&undated); err != nil {
+		t.Fatalf("failed to find undated post: %v", err)
+	}
+
+	if err := db.MarkRead(ctx, idsA[2]); err != nil {
+		t.Fatalf("MarkRead() error = %v", err)
+	}
+	if err := db.Star(ctx, idsB[0]); err != nil {
+		t.Fatalf("Star() error = %v", err)
+	}
+	parent, err := db.AddCategory(ctx, "Parent", 0)
+	if err != nil {
+		t.Fatalf("AddCategory() error = %v", err)
+	}
+	child, err := db.AddCategory(ctx, "Child", parent)
+	if err != nil {
+		t.Fatalf("AddCategory() error = %v", err)
+	}
+	if err := db.AddFeedToCategory(ctx, b.ID, child); err != nil {
+		t.Fatalf("AddFeedToCategory() error = %v", err)
+	}
+	empty, err := db.AddCategory(ctx, "Empty", 0)
+	if err != nil {
+		t.Fatalf("AddCategory() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query PostQuery
+		want  []int
+	}{
+		{
+			name:  "Everything newest first",
+			query: PostQuery{},
+			want:  []int{idsB[2], idsA[2], idsB[1], idsA[1], idsB[0], idsA[0], undated},
+		},
+		{
+			name:  "Everything oldest first",
+			query: PostQuery{Order: OldestFirst},
+			want:  []int{undated, idsA[0], idsB[0], idsA[1], idsB[1], idsA[2], idsB[2]},
+		},
+		{
+			name:  "One feed",
+			query: PostQuery{FeedIDs: []int{a.ID}},
+			want:  []int{idsA[2], idsA[1], idsA[0]},
+		},
+		{
+			name:  "Category includes subcategories",
+			query: PostQuery{CategoryID: parent},
+			want:  []int{idsB[2], idsB[1], idsB[0]},
+		},
+		{
+			name:  "Unread in a feed",
+			query: PostQuery{FeedIDs: []int{a.ID}, Read: UnreadPosts},
+			want:  []int{idsA[1], idsA[0]},
+		},
+		{
+			name:  "Read",
+			query: PostQuery{Read: ReadPosts},
+			want:  []int{idsA[2]},
+		},
+		{
+			name:  "Starred",
+			query: PostQuery{Starred: true},
+			want:  []int{idsB[0]},
+		},
+		{
+			name:  "Date range",
+			query: PostQuery{Since: start.Add(time.Hour), Until: start.Add(2 * time.Hour)},
+			want:  []int{idsB[1], idsA[1]},
+		},
+		{
+			name: "Date range in another zone",
+			query: PostQuery{
+				Since: start.Add(time.Hour).In(time.FixedZone("UTC-5", -5*60*60)),
+				Until: start.Add(2 * time.Hour).In(time.FixedZone("UTC+9", 9*60*60)),
+			},
+			want: []int{idsB[1], idsA[1]},
+		},
+		{
+			name:  "Empty category",
+			query: PostQuery{CategoryID: empty},
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := listAll(t, db, tt.query); !slices.Equal(got, tt.want) {
+				t.Errorf("ListPosts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := db.ListPosts(ctx, PostQuery{After: "nonsense"}); err == nil {
+		t.Error("ListPosts() accepted a bad cursor")
+	}
+}
@@ -0,0 +1,504 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when a database was written by a newer warss than this one
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of warss supports")
+
+// migration upgrades the schema by exactly one version
+type migration struct {
+	name string
+	up   func(ctx context.Context, tx *sql.Tx) error
+}
+
+// migrations[i] brings the schema from version i to version i+1, where the
+// version is stored in PRAGMA user_version. Shipped migrations must never be
+// edited or reordered, only appended to.
+var migrations = []migration{
+	{name: "baseline schema", up: migrateBaseline},
+	{name: "feed cache validators", up: migrateFeedValidators},
+	{name: "post identity by feed and guid", up: migratePostGUID},
+	{name: "post revisions", up: migratePostRevisions},
+	{name: "retention policy", up: migrateRetention},
+	{name: "starred posts", up: migrateStarred},
+	{name: "categories", up: migrateCategories},
+	{name: "post timeline index", up: migrateTimelineIndex},
+	{name: "post date source", up: migrateDateSource},
+	{name: "post and feed metadata", up: migrateMetadata},
+	{name: "enclosures", up: migrateEnclosures},
+	{name: "feed health", up: migrateFeedHealth},
+	{name: "feed moves", up: migrateFeedMoves},
+	{name: "feed schedule", up: migrateFeedSchedule},
+	{name: "pruned post sightings", up: migratePrunedSightings},
+	{name: "utc timestamps", up: migrateUTCTimestamps},
+}
+
+// schemaVersion is the version a fully migrated database is at
+func schemaVersion() int {
+	return len(migrations)
+}
+
+// migrate brings the database up to the latest schema version, applying each
+// pending migration in its own transaction so a failure leaves the database at
+// the last version that fully applied
+func migrate(ctx context.Context, db *sql.DB) error {
+	var current int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&current); err != nil {
+		return fmt.Errorf("error reading schema version: %w", err)
+	}
+	if current > schemaVersion() {
+		return fmt.Errorf("%w: database is at version %d, newest known is %d", ErrSchemaTooNew, current, schemaVersion())
+	}
+
+	for version := current; version < schemaVersion(); version++ {
+		m := migrations[version]
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("error starting migration %d: %w", version+1, err)
+		}
+		if err := m.up(ctx, tx); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("error applying migration %d (%s): %w", version+1, m.name, err)
+		}
+		// PRAGMA doesn't take bound parameters, version is our own int
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("error setting schema version %d: %w", version+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("error committing migration %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
+// columnExists reports whether table already has the named column
+func columnExists(ctx context.Context, q querier, table, column string) (bool, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return false, fmt.Errorf("error reading columns of %s: %w", table, err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("error closing rows: %v\n", err)
+		}
+	}()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// addColumn adds a column unless it is already there. Databases created before
+// versioning existed may already have columns that a migration introduces.
+func addColumn(ctx context.Context, tx *sql.Tx, table, column, definition string) error {
+	exists, err := columnExists(ctx, tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+	query := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition)
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error adding %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// 1: the schema as it was before migrations existed
+func migrateBaseline(ctx context.Context, tx *sql.Tx) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS feeds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT UNIQUE NOT NULL,
+		title TEXT
+	);`
+
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error creating feed table: %w", err)
+	}
+
+	postQuery := `
+	CREATE TABLE IF NOT EXISTS posts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		feed_id INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		link TEXT UNIQUE NOT NULL,
+		content TEXT,
+		published_at DATETIME,
+		updated_at DATETIME,
+		read BOOLEAN DEFAULT 0,
+		FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_post_feed_id ON posts(feed_id);
+	CREATE INDEX IF NOT EXISTS idx_post_feed_published ON posts(feed_id, published_at DESC);
+	`
+
+	if _, err := tx.ExecContext(ctx, postQuery); err != nil {
+		return fmt.Errorf("error creating posts table: %w", err)
+	}
+	return nil
+}
+
+// 2: ETag / Last-Modified for conditional GETs
+func migrateFeedValidators(ctx context.Context, tx *sql.Tx) error {
+	if err := addColumn(ctx, tx, "feeds", "etag", "TEXT"); err != nil {
+		return err
+	}
+	return addColumn(ctx, tx, "feeds", "last_modified", "TEXT")
+}
+
+// 3: posts are identified by (feed_id, guid) instead of a globally unique
+// link. SQLite can't drop a UNIQUE constraint, so the table is rebuilt.
+// Existing rows get a legacy guid built from their link, upsertPost swaps
+// it for the real one the next time the feed is fetched.
+func migratePostGUID(ctx context.Context, tx *sql.Tx) error {
+	query := `
+	CREATE TABLE posts_new (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		feed_id INTEGER NOT NULL,
+		guid TEXT NOT NULL,
+		title TEXT NOT NULL,
+		link TEXT NOT NULL DEFAULT '',
+		content TEXT,
+		published_at DATETIME,
+		updated_at DATETIME,
+		read BOOLEAN DEFAULT 0,
+		UNIQUE (feed_id, guid),
+		FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+	);
+	INSERT INTO posts_new (id, feed_id, guid, title, link, content, published_at, updated_at, read)
+		SELECT id, feed_id, 'legacy:' || link, title, link, content, published_at, updated_at, read
+		FROM posts;
+	DROP TABLE posts;
+	ALTER TABLE posts_new RENAME TO posts;
+	CREATE INDEX idx_post_feed_id ON posts(feed_id);
+	CREATE INDEX idx_post_feed_published ON posts(feed_id, published_at DESC);
+	`
+
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error rebuilding posts table: %w", err)
+	}
+	return nil
+}
+
+// 4: content hashes to notice edited posts, the flag for posts that changed
+// after being read, and the earlier versions they replaced. Existing rows have
+// no hash yet, upsertPost computes it from the stored copy when it's missing.
+func migratePostRevisions(ctx context.Context, tx *sql.Tx) error {
+	if err := addColumn(ctx, tx, "posts", "content_hash", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumn(ctx, tx, "posts", "updated_since_read", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	query := `
+	CREATE TABLE IF NOT EXISTS post_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post_id INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		link TEXT NOT NULL DEFAULT '',
+		content TEXT,
+		updated_at DATETIME,
+		replaced_at DATETIME NOT NULL,
+		FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_post_revisions_post_id ON post_revisions(post_id, id);
+	`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error creating post_revisions table: %w", err)
+	}
+	return nil
+}
+
+// 5: retention. The global policy lives in settings, per-feed overrides in
+// nullable feed columns (NULL inherits), and pruned_posts remembers what was
+// deleted so it isn't stored again while the feed still carries it.
+func migrateRetention(ctx context.Context, tx *sql.Tx) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS pruned_posts (
+		feed_id INTEGER NOT NULL,
+		guid TEXT NOT NULL,
+		pruned_at DATETIME NOT NULL,
+		PRIMARY KEY (feed_id, guid),
+		FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+	);
+	`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error creating retention tables: %w", err)
+	}
+
+	for _, column := range []string{"retain_max_age_days", "retain_max_posts", "retain_read_max_age_days"} {
+		if err := addColumn(ctx, tx, "feeds", column, "INTEGER"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 6: starred posts, starred_at is NULL for posts that aren't
+func migrateStarred(ctx context.Context, tx *sql.Tx) error {
+	if err := addColumn(ctx, tx, "posts", "starred_at", "DATETIME"); err != nil {
+		return err
+	}
+	query := `CREATE INDEX IF NOT EXISTS idx_post_starred ON posts(starred_at DESC, id DESC) WHERE starred_at IS NOT NULL`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error creating starred index: %w", err)
+	}
+	return nil
+}
+
+// 7: categories, nested through parent_id, and which feeds are filed where.
+// A feed can be in any number of categories.
+func migrateCategories(ctx context.Context, tx *sql.Tx) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS categories (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		parent_id INTEGER,
+		FOREIGN KEY (parent_id) REFERENCES categories(id) ON DELETE CASCADE
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_category_name ON categories(COALESCE(parent_id, 0), name);
+	CREATE TABLE IF NOT EXISTS feed_categories (
+		feed_id INTEGER NOT NULL,
+		category_id INTEGER NOT NULL,
+		PRIMARY KEY (feed_id, category_id),
+		FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE,
+		FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_feed_categories_category ON feed_categories(category_id);
+	`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error creating category tables: %w", err)
+	}
+	return nil
+}
+
+// 8: an index for listing posts across every feed by date, which
+// idx_post_feed_published can't serve
+func migrateTimelineIndex(ctx context.Context, tx *sql.Tx) error {
+	query := `CREATE INDEX IF NOT EXISTS idx_post_published ON posts(published_at DESC, id DESC)`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error creating timeline index: %w", err)
+	}
+	return nil
+}
+
+// 9: which part of the feed item a post's date came from, NULL for posts
+// stored before it was recorded
+func migrateDateSource(ctx context.Context, tx *sql.Tx) error {
+	return addColumn(ctx, tx, "posts", "date_source", "TEXT")
+}
+
+// 10: what feeds say about themselves and their posts beyond title and text.
+// Post authors and tags are newline separated lists.
+func migrateMetadata(ctx context.Context, tx *sql.Tx) error {
+	for _, column := range []string{"summary", "authors", "tags", "image_url", "comments_url", "language"} {
+		if err := addColumn(ctx, tx, "posts", column, "TEXT"); err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"site_url", "description", "icon_url", "logo_url", "language"} {
+		if err := addColumn(ctx, tx, "feeds", column, "TEXT"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 11: files attached to posts and how far downloading each has got.
+// duration is in seconds.
+func migrateEnclosures(ctx context.Context, tx *sql.Tx) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS enclosures (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post_id INTEGER NOT NULL,
+		url TEXT NOT NULL,
+		type TEXT,
+		length INTEGER,
+		duration INTEGER,
+		download_state TEXT NOT NULL DEFAULT 'none',
+		download_path TEXT,
+		downloaded INTEGER NOT NULL DEFAULT 0,
+		download_error TEXT,
+		UNIQUE (post_id, url),
+		FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_enclosure_state ON enclosures(download_state);
+	`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error creating enclosures table: %w", err)
+	}
+	return nil
+}
+
+// 12: how fetching each feed has been going. last_new_post_at starts from the
+// newest post already stored.
+func migrateFeedHealth(ctx context.Context, tx *sql.Tx) error {
+	columns := []struct{ name, definition string }{
+		{"last_fetch_at", "DATETIME"},
+		{"last_success_at", "DATETIME"},
+		{"fetch_errors", "INTEGER NOT NULL DEFAULT 0"},
+		{"last_error", "TEXT"},
+		{"last_status", "INTEGER"},
+		{"retry_at", "DATETIME"},
+		{"moved_to", "TEXT"},
+		{"last_new_post_at", "DATETIME"},
+	}
+	for _, c := range columns {
+		if err := addColumn(ctx, tx, "feeds", c.name, c.definition); err != nil {
+			return err
+		}
+	}
+	query := `UPDATE feeds SET last_new_post_at = (SELECT MAX(published_at) FROM posts WHERE posts.feed_id = feeds.id)`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error filling last_new_post_at: %w", err)
+	}
+	return nil
+}
+
+// 13: following feeds that move. feed_aliases keeps the URLs a feed was
+// subscribed at before it permanently redirected, moved_count how many fetches
+// in a row have seen the redirect in moved_to.
+func migrateFeedMoves(ctx context.Context, tx *sql.Tx) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS feed_aliases (
+		url TEXT PRIMARY KEY,
+		feed_id INTEGER NOT NULL,
+		moved_at DATETIME NOT NULL,
+		FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_feed_aliases_feed ON feed_aliases(feed_id);
+	`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error creating feed_aliases table: %w", err)
+	}
+	if err := addColumn(ctx, tx, "feeds", "moved_count", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	return addColumn(ctx, tx, "feeds", "redirected_to_page", "BOOLEAN NOT NULL DEFAULT 0")
+}
+
+// 14: when each feed is next due and the hints it was worked out from.
+// Durations are in seconds, skip_hours and skip_days comma separated numbers.
+// Feeds backing off start out due when their retry is.
+func migrateFeedSchedule(ctx context.Context, tx *sql.Tx) error {
+	columns := []struct{ name, definition string }{
+		{"ttl", "INTEGER NOT NULL DEFAULT 0"},
+		{"update_period", "INTEGER NOT NULL DEFAULT 0"},
+		{"skip_hours", "TEXT"},
+		{"skip_days", "TEXT"},
+		{"post_interval", "INTEGER NOT NULL DEFAULT 0"},
+		{"next_due_at", "DATETIME"},
+	}
+	for _, c := range columns {
+		if err := addColumn(ctx, tx, "feeds", c.name, c.definition); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE feeds SET next_due_at = retry_at`); err != nil {
+		return fmt.Errorf("error filling next_due_at: %w", err)
+	}
+	return nil
+}
+
+// 15: when each pruned post was last seen in its feed and when each feed was
+// last saved, so Prune can forget pruned posts the feed has stopped listing
+func migratePrunedSightings(ctx context.Context, tx *sql.Tx) error {
+	if err := addColumn(ctx, tx, "pruned_posts", "seen_at", "DATETIME"); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE pruned_posts SET seen_at = pruned_at`); err != nil {
+		return fmt.Errorf("error filling seen_at: %w", err)
+	}
+	return addColumn(ctx, tx, "feeds", "saved_at", "DATETIME")
+}
+
+// 16: timestamps written with a local offset before everything was stored in
+// UTC are rewritten in UTC. They're compared as text, so "10:00:00+02:00" and
+// "08:00:00+00:00" have to read the same.
+func migrateUTCTimestamps(ctx context.Context, tx *sql.Tx) error {
+	columns := []struct{ table, name string }{
+		{"posts", "published_at"},
+		{"posts", "updated_at"},
+		{"posts", "starred_at"},
+		{"post_revisions", "updated_at"},
+		{"post_revisions", "replaced_at"},
+		{"pruned_posts", "pruned_at"},
+		{"pruned_posts", "seen_at"},
+		{"feeds", "last_fetch_at"},
+		{"feeds", "last_success_at"},
+		{"feeds", "retry_at"},
+		{"feeds", "last_new_post_at"},
+		{"feeds", "next_due_at"},
+		{"feeds", "saved_at"},
+		{"feed_aliases", "moved_at"},
+	}
+	for _, c := range columns {
+		if err := utcColumn(ctx, tx, c.table, c.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// utcColumn rewrites the timestamps in a column that aren't in UTC. Values the
+// driver can't read as a time are left as they are.
+func utcColumn(ctx context.Context, tx *sql.Tx, table, column string) error {
+	query := fmt.Sprintf(`SELECT rowid, %[2]s FROM %[1]s
+		WHERE %[2]s IS NOT NULL AND %[2]s NOT LIKE '%%+00:00' AND %[2]s NOT LIKE '%%Z'`, table, column)
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error reading %s.%s: %w", table, column, err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("error closing rows: %v\n", err)
+		}
+	}()
+
+	local := make(map[int64]time.Time)
+	for rows.Next() {
+		var (
+			id    int64
+			value any
+		)
+		if err := rows.Scan(&id, &value); err != nil {
+			return fmt.Errorf("error reading %s.%s: %w", table, column, err)
+		}
+		if t, ok := value.(time.Time); ok {
+			local[id] = t
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading %s.%s: %w", table, column, err)
+	}
+
+	update := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE rowid = ?`, table, column)
+	for id, t := range local {
+		if _, err := tx.ExecContext(ctx, update, t.UTC(), id); err != nil {
+			return fmt.Errorf("error rewriting %s.%s in UTC: %w", table, column, err)
+		}
+	}
+	return nil
+}
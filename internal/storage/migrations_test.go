@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// fixtureDB writes a database at path from an SQL script in testdata
+func fixtureDB(t *testing.T, path, script string) {
+	t.Helper()
+	schema, err := os.ReadFile(filepath.Join("testdata", script))
+	if err != nil {
+		t.Fatalf("couldn't read fixture %s: %v", script, err)
+	}
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open fixture database: %v", err)
+	}
+	defer func() { _ = raw.Close() }()
+	if _, err := raw.Exec(string(schema)); err != nil {
+		t.Fatalf("failed to load fixture %s: %v", script, err)
+	}
+}
+
+func userVersion(t *testing.T, db *DB) int {
+	t.Helper()
+	var v int
+	if err := db.conn.QueryRow(`PRAGMA user_version`).Scan(&v); err != nil {
+		t.Fatalf("failed to read user_version: %v", err)
+	}
+	return v
+}
+
+// TestMigrateFreshDatabase checks a brand new file ends up at the latest version
+func TestMigrateFreshDatabase(t *testing.T) {
+	db := setupTestDB(t)
+
+	if got := userVersion(t, db); got != schemaVersion() {
+		t.Errorf("user_version = %d, want %d", got, schemaVersion())
+	}
+}
+
+// TestMigrateBaselineFixture upgrades a database written before versioning existed
+func TestMigrateBaselineFixture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rss.db")
+	fixtureDB(t, path, "baseline.sql")
+
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() on baseline fixture error = %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	if got := userVersion(t, db); got != schemaVersion() {
+		t.Errorf("user_version = %d, want %d", got, schemaVersion())
+	}
+
+	// Existing rows survive and the new columns are usable
+	feeds, err := db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	if len(feeds) != 2 {
+		t.Fatalf("got %d feeds, want 2", len(feeds))
+	}
+	if feeds[0].Title != "Ed's Blog" || feeds[0].Unread != 1 {
+		t.Errorf("feed 0 = %q with %d unread, want %q with 1 unread", feeds[0].Title, feeds[0].Unread, "Ed's Blog")
+	}
+	// Feeds start out as alive as their newest post
+	if want := time.Date(2024, 2, 2, 3, 4, 5, 0, time.UTC); !feeds[0].Health.LastNewPostAt.Equal(want) {
+		t.Errorf("feed 0 last new post = %v, want %v", feeds[0].Health.LastNewPostAt, want)
+	}
+	// and due for a refresh straight away
+	if !feeds[0].Schedule.Due(time.Now()) {
+		t.Errorf("feed 0 next due %v, want due now", feeds[0].Schedule.NextDueAt)
+	}
+	// The fixture predates the validator columns, existing installs get them
+	if err := db.SetFeedValidators(feeds[0].URL, `"v1"`, ""); err != nil {
+		t.Errorf("SetFeedValidators() on migrated database error = %v", err)
+	}
+	if etag, _, err := db.GetFeedValidators(feeds[0].URL); err != nil || etag != `"v1"` {
+		t.Errorf("GetFeedValidators() on migrated database = %q, %v, want %q", etag, err, `"v1"`)
+	}
+
+	post, err := db.GetPost(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("GetPost() error = %v", err)
+	}
+	if post.Title != "Hello" || !post.Read {
+		t.Errorf("post 1 = %q read=%t, want %q read=true", post.Title, post.Read, "Hello")
+	}
+
+	// The next fetch matches old rows by link and keeps their read state
+	feed := feeds[0]
+	feed.Posts = []models.Post{
+		{GUID: "tag:ed-thomas.dev,2024:hello", Title: "Hello", Link: "https://ed-thomas.dev/hello", UpdatedAt: post.UpdatedAt},
+		{Title: "Second", Link: "https://ed-thomas.dev/second", UpdatedAt: post.UpdatedAt},
+	}
+	stats, err := db.SaveFeed(context.Background(), feed)
+	if err != nil {
+		t.Fatalf("SaveFeed() on migrated database error = %v", err)
+	}
+	if stats.New != 0 {
+		t.Errorf("SaveFeed() stats = %+v, want no new posts", stats)
+	}
+	post, err = db.GetPost(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("GetPost() error = %v", err)
+	}
+	if post.GUID != "tag:ed-thomas.dev,2024:hello" || !post.Read {
+		t.Errorf("post 1 guid = %q read=%t, want the real guid and still read", post.GUID, post.Read)
+	}
+}
+
+// TestMigrateUTCTimestamps checks dates written with a local offset before
+// everything was stored in UTC compare correctly once migrated
+func TestMigrateUTCTimestamps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rss.db")
+	fixtureDB(t, path, "baseline.sql")
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open fixture database: %v", err)
+	}
+	_, err = raw.Exec(`INSERT INTO posts (feed_id, title, link, content, published_at, updated_at) VALUES
+		(2, 'Local', 'https://example.com/local', '', '2024-03-02 05:30:00+02:00', '2024-03-02 05:30:00+02:00')`)
+	_ = raw.Close()
+	if err != nil {
+		t.Fatalf("failed to add local post: %v", err)
+	}
+
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	var stored string
+	if err := db.conn.QueryRow(`SELECT CAST(published_at AS TEXT) FROM posts WHERE title = 'Local'`).Scan(&stored); err != nil {
+		t.Fatalf("failed to read published_at: %v", err)
+	}
+	if stored != "2024-03-02 03:30:00+00:00" {
+		t.Errorf("published_at = %q, want it in UTC", stored)
+	}
+
+	// 03:30 UTC is after the fixture's 03:04:05 post, as text too
+	posts, _, err := db.ListPosts(context.Background(), PostQuery{
+		FeedIDs: []int{2},
+		Since:   time.Date(2024, 3, 2, 3, 10, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("ListPosts() error = %v", err)
+	}
+	if len(posts) != 1 || posts[0].Title != "Local" {
+		t.Errorf("posts since 03:10 = %+v, want only the local one", posts)
+	}
+}
+
+// TestMigrateIsIdempotent reopens a migrated database without touching it again
+func TestMigrateIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rss.db")
+	for range 2 {
+		db, err := NewDB(path)
+		if err != nil {
+			t.Fatalf("NewDB() error = %v", err)
+		}
+		if got := userVersion(t, db); got != schemaVersion() {
+			t.Errorf("user_version = %d, want %d", got, schemaVersion())
+		}
+		_ = db.Close()
+	}
+}
+
+// TestMigrateRefusesNewerSchema makes sure we never write to a database from the future
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rss.db")
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if _, err := raw.Exec(`PRAGMA user_version = 9999`); err != nil {
+		t.Fatalf("failed to set user_version: %v", err)
+	}
+	_ = raw.Close()
+
+	_, err = NewDB(path)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("NewDB() error = %v, want ErrSchemaTooNew", err)
+	}
+}
+
+// TestMigrateUnversionedWithValidators covers files created after the validator
+// columns were added but before versioning, where the columns already exist
+func TestMigrateUnversionedWithValidators(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rss.db")
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = raw.Exec(`CREATE TABLE feeds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT UNIQUE NOT NULL,
+		title TEXT,
+		etag TEXT,
+		last_modified TEXT
+	)`)
+	_ = raw.Close()
+	if err != nil {
+		t.Fatalf("failed to create feeds table: %v", err)
+	}
+
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	if got := userVersion(t, db); got != schemaVersion() {
+		t.Errorf("user_version = %d, want %d", got, schemaVersion())
+	}
+}
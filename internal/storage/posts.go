@@ -2,60 +2,501 @@ package storage
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/pixel-87/warss/internal/models"
 )
 
-func (d *DB) AddPosts(feedID int, posts []models.Post) error {
-	query := `INSERT INTO posts (
-		feed_id,
-		title,
-		link,
-		content,
-		published_at,
-		updated_at
-	)
-	VALUES (?, ?, ?, ?, ?, ?)
-	ON CONFLICT(link) DO NOTHING;`
+// ErrNoPost is returned for a post id that isn't stored
+var ErrNoPost = errors.New("no such post")
+
+// postUpdated turns an update of post postID that matched no row into ErrNoPost
+func postUpdated(res sql.Result, postID int) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %d", ErrNoPost, postID)
+	}
+	return nil
+}
+
+// SyncStats counts what SaveFeed did with each of a feed's posts
+type SyncStats struct {
+	New       int
+	Updated   int
+	Unchanged int
+}
 
+// PostOutcome is what storing one post did
+type PostOutcome int
+
+const (
+	// PostSkipped means nothing changed: the post was stored as it is
+	// already, was pruned, or was an older copy than the one stored
+	PostSkipped PostOutcome = iota
+	PostInserted
+	PostUpdated
+)
+
+func (o PostOutcome) String() string {
+	switch o {
+	case PostInserted:
+		return "inserted"
+	case PostUpdated:
+		return "updated"
+	default:
+		return "skipped"
+	}
+}
+
+// SaveFeed stores a freshly fetched feed in a single transaction. The feed row
+// (title, url, cache validators and metadata) is updated, posts not seen before are
+// inserted and known posts whose text changed are updated in place.
+// Either all of it lands or none of it does.
+func (d *DB) SaveFeed(ctx context.Context, feed models.Feed) (SyncStats, error) {
+	var stats SyncStats
+	err := d.withTx(ctx, func(tx *sql.Tx) error {
+		stats = SyncStats{}
+		if err := updateFeed(ctx, tx, feed); err != nil {
+			return err
+		}
+		// Pruned posts this save doesn't list again are forgotten by Prune
+		now := time.Now().UTC()
+		if _, err := tx.ExecContext(ctx, `UPDATE feeds SET saved_at = ? WHERE id = ?`, now, feed.ID); err != nil {
+			return fmt.Errorf("failed to record save of feed %d: %w", feed.ID, err)
+		}
+		if err := setFeedValidators(ctx, tx, feed.URL, feed.ETag, feed.LastModified); err != nil {
+			return err
+		}
+		if err := setFeedMetadata(ctx, tx, feed); err != nil {
+			return err
+		}
+
+		outcomes, err := savePosts(ctx, tx, feed.ID, feed.Posts, now)
+		if err != nil {
+			return err
+		}
+		for _, o := range outcomes {
+			switch o {
+			case PostInserted:
+				stats.New++
+			case PostUpdated:
+				stats.Updated++
+			default:
+				stats.Unchanged++
+			}
+		}
+		if stats.New > 0 {
+			return touchLastNewPost(ctx, tx, feed.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return SyncStats{}, fmt.Errorf("failed to save feed %d: %w", feed.ID, err)
+	}
+	return stats, nil
+}
+
+// AddPosts stores posts for a feed in one transaction and reports what
+// happened to each, in the same order. New posts are inserted and known ones
+// updated as SaveFeed does, without touching the feed itself. If any post
+// fails none of them are stored.
+func (d *DB) AddPosts(ctx context.Context, feedID int, posts []models.Post) ([]PostOutcome, error) {
+	var outcomes []PostOutcome
+	err := d.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		outcomes, err = savePosts(ctx, tx, feedID, posts, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add posts to feed %d: %w", feedID, err)
+	}
+	return outcomes, nil
+}
+
+// savePosts upserts every post through one set of prepared statements, now
+// being when the feed listed them
+func savePosts(ctx context.Context, tx *sql.Tx, feedID int, posts []models.Post, now time.Time) ([]PostOutcome, error) {
+	w, err := preparePostWriter(ctx, tx, now)
+	if err != nil {
+		return nil, err
+	}
+	defer w.close()
+
+	outcomes := make([]PostOutcome, len(posts))
 	for i := range posts {
-		_, err := d.conn.Exec(
-			query,
-			feedID,
-			posts[i].Title,
-			posts[i].Link,
-			posts[i].Content,
-			posts[i].PublishedAt,
-			posts[i].UpdatedAt,
-		)
+		var postID int
+		if outcomes[i], postID, err = w.upsertPost(ctx, feedID, posts[i]); err != nil {
+			return nil, err
+		}
+		if postID == 0 {
+			continue
+		}
+		for _, e := range posts[i].Enclosures {
+			if err := w.upsertEnclosure(ctx, postID, e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return outcomes, nil
+}
+
+// legacyGUID is the identity posts stored before GUIDs existed were migrated with
+func legacyGUID(link string) string {
+	return "legacy:" + link
+}
+
+// postWriter holds the statements upsertPost runs, prepared once per
+// transaction rather than parsed again for every post of a feed
+type postWriter struct {
+	lookup    *sql.Stmt
+	pruned    *sql.Stmt
+	insert    *sql.Stmt
+	adoptGUID *sql.Stmt
+	touch     *sql.Stmt
+	revision  *sql.Stmt
+	update    *sql.Stmt
+	enclosure *sql.Stmt
+	// now is when the posts were listed, pruned ones are marked seen then
+	now time.Time
+}
+
+func preparePostWriter(ctx context.Context, tx *sql.Tx, now time.Time) (*postWriter, error) {
+	w := &postWriter{now: now}
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		// Rows from before GUIDs existed are matched on their link once, then
+		// take over the real identity
+		{&w.lookup, `
+			SELECT id, guid, title, link, content, updated_at, content_hash, ` + metadataColumns + `
+			FROM posts
+			WHERE feed_id = ? AND guid IN (?, ?)
+			ORDER BY guid = ? DESC
+			LIMIT 1
+		`},
+		{&w.pruned, `UPDATE pruned_posts SET seen_at = ? WHERE feed_id = ? AND guid = ?`},
+		{&w.insert, `
+			INSERT INTO posts (feed_id, guid, title, link, content, published_at, updated_at, date_source, content_hash,
+				summary, authors, tags, image_url, comments_url, language)
+			VALUES (?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?,
+				NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''))
+		`},
+		{&w.adoptGUID, `UPDATE posts SET guid = ? WHERE id = ?`},
+		{&w.touch, `
+			UPDATE posts
+			SET link = ?, updated_at = ?, content_hash = ?,
+				summary = NULLIF(?, ''), authors = NULLIF(?, ''), tags = NULLIF(?, ''),
+				image_url = NULLIF(?, ''), comments_url = NULLIF(?, ''), language = NULLIF(?, '')
+			WHERE id = ?
+		`},
+		{&w.revision, `
+			INSERT INTO post_revisions (post_id, title, link, content, updated_at, replaced_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`},
+		{&w.update, `
+			UPDATE posts
+			SET title = ?, link = ?, content = ?, updated_at = ?, content_hash = ?, updated_since_read = read,
+				summary = NULLIF(?, ''), authors = NULLIF(?, ''), tags = NULLIF(?, ''),
+				image_url = NULLIF(?, ''), comments_url = NULLIF(?, ''), language = NULLIF(?, '')
+			WHERE id = ?
+		`},
+		// Download state is left alone, the feed only knows about the file
+		{&w.enclosure, `
+			INSERT INTO enclosures (post_id, url, type, length, duration)
+			VALUES (?, ?, NULLIF(?, ''), NULLIF(?, 0), NULLIF(?, 0))
+			ON CONFLICT(post_id, url) DO UPDATE
+			SET type = excluded.type, length = excluded.length, duration = excluded.duration
+		`},
+	}
+	for _, s := range statements {
+		stmt, err := tx.PrepareContext(ctx, s.query)
+		if err != nil {
+			w.close()
+			return nil, fmt.Errorf("failed to prepare post statement: %w", err)
+		}
+		*s.stmt = stmt
+	}
+	return w, nil
+}
+
+func (w *postWriter) close() {
+	for _, stmt := range []*sql.Stmt{w.lookup, w.pruned, w.insert, w.adoptGUID, w.touch, w.revision, w.update, w.enclosure} {
+		if stmt != nil {
+			_ = stmt.Close()
+		}
+	}
+}
+
+// upsertPost inserts p if the feed has no post with its identity yet. A known
+// post whose title or content changed is updated in place, with the version
+// it replaces kept in post_revisions; a copy older than the stored one is
+// ignored. Posts that were already read get flagged as updated since.
+// The stored post's id is returned, 0 when it was pruned.
+func (w *postWriter) upsertPost(ctx context.Context, feedID int, p models.Post) (PostOutcome, int, error) {
+	guid := p.Identity()
+	hash := p.ContentHash()
+	meta := metadataOf(p)
+	// Dates are stored in UTC, whatever zone the feed gave them in, as they're
+	// compared as text
+	p.PublishedAt, p.UpdatedAt = p.PublishedAt.UTC(), p.UpdatedAt.UTC()
+	var (
+		stored     models.Post
+		storedGUID string
+		content    sql.NullString
+		updatedAt  sql.NullTime
+		storedHash sql.NullString
+		storedMeta metadata
+	)
+	dest := append([]any{
+		&stored.ID,
+		&storedGUID,
+		&stored.Title,
+		&stored.Link,
+		&content,
+		&updatedAt,
+		&storedHash,
+	}, storedMeta.dest()...)
+	err := w.lookup.QueryRowContext(ctx, feedID, guid, legacyGUID(p.Link), guid).Scan(dest...)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// Pruned posts stay gone while the feed keeps listing them
+		seen, err := w.pruned.ExecContext(ctx, w.now, feedID, guid)
+		if err != nil {
+			return PostSkipped, 0, fmt.Errorf("failed to look up pruned post %q: %w", guid, err)
+		}
+		if n, err := seen.RowsAffected(); err != nil || n > 0 {
+			return PostSkipped, 0, err
+		}
 
+		args := append([]any{feedID, guid, p.Title, p.Link, p.Content, p.PublishedAt, p.UpdatedAt, p.DateSource, hash}, meta.args()...)
+		res, err := w.insert.ExecContext(ctx, args...)
+		if err != nil {
+			return PostSkipped, 0, fmt.Errorf("failed to insert post %q for feed %d: %w", p.Title, feedID, err)
+		}
+		id, err := res.LastInsertId()
 		if err != nil {
-			return fmt.Errorf("failed to insert post %q for feed %d: %w", posts[i].Title, feedID, err)
+			return PostSkipped, 0, err
 		}
+		return PostInserted, int(id), nil
+	case err != nil:
+		return PostSkipped, 0, fmt.Errorf("failed to look up post %q: %w", guid, err)
+	}
+	stored.Content = content.String
+	stored.UpdatedAt = updatedAt.Time
+	// Rows stored before hashes existed are hashed on first sight
+	if !storedHash.Valid {
+		storedHash.String = stored.ContentHash()
+	}
+
+	if storedGUID != guid {
+		if _, err := w.adoptGUID.ExecContext(ctx, guid, stored.ID); err != nil {
+			return PostSkipped, 0, fmt.Errorf("failed to adopt guid for post %d: %w", stored.ID, err)
+		}
+	}
+
+	if hash == storedHash.String {
+		// Same text: still take a moved link, a bumped date or new metadata,
+		// but it's not an edit
+		if p.Link == stored.Link && !p.UpdatedAt.After(stored.UpdatedAt) && storedHash.Valid && meta == storedMeta {
+			return PostSkipped, stored.ID, nil
+		}
+		updatedAt := stored.UpdatedAt
+		if p.UpdatedAt.After(updatedAt) {
+			updatedAt = p.UpdatedAt
+		}
+		args := append(append([]any{p.Link, updatedAt, hash}, meta.args()...), stored.ID)
+		if _, err := w.touch.ExecContext(ctx, args...); err != nil {
+			return PostSkipped, 0, fmt.Errorf("failed to update post %d: %w", stored.ID, err)
+		}
+		return PostSkipped, stored.ID, nil
+	}
+
+	// A stale mirror or cache can serve an older copy than the one we have
+	if !p.UpdatedAt.IsZero() && p.UpdatedAt.Before(stored.UpdatedAt) {
+		return PostSkipped, stored.ID, nil
+	}
+
+	now := time.Now().UTC()
+	// Feeds that edit without saying so get the time we noticed
+	newUpdatedAt := p.UpdatedAt
+	if newUpdatedAt.IsZero() {
+		newUpdatedAt = now
+	}
+
+	if _, err := w.revision.ExecContext(ctx, stored.ID, stored.Title, stored.Link, content, updatedAt, now); err != nil {
+		return PostSkipped, 0, fmt.Errorf("failed to save revision of post %d: %w", stored.ID, err)
+	}
+	args := append(append([]any{p.Title, p.Link, p.Content, newUpdatedAt, hash}, meta.args()...), stored.ID)
+	if _, err := w.update.ExecContext(ctx, args...); err != nil {
+		return PostSkipped, 0, fmt.Errorf("failed to update post %d: %w", stored.ID, err)
+	}
+	return PostUpdated, stored.ID, nil
+}
+
+// upsertEnclosure stores an enclosure of the post, or refreshes what the feed
+// says about one already stored
+func (w *postWriter) upsertEnclosure(ctx context.Context, postID int, e models.Enclosure) error {
+	seconds := int64(e.Duration / time.Second)
+	if _, err := w.enclosure.ExecContext(ctx, postID, e.URL, e.Type, e.Length, seconds); err != nil {
+		return fmt.Errorf("failed to store enclosure %q of post %d: %w", e.URL, postID, err)
 	}
 	return nil
 }
 
-func (d *DB) GetPost(ctx context.Context, postID int) (models.Post, error) {
-	query := `
-		SELECT feed_id, title, link, content, published_at, updated_at
-		FROM posts
-		WHERE id = ?;
-	`
+// metadataColumns are the post columns metadata reads and writes, in order
+const metadataColumns = `COALESCE(summary, ''), COALESCE(authors, ''), COALESCE(tags, ''),
+	COALESCE(image_url, ''), COALESCE(comments_url, ''), COALESCE(language, '')`
+
+// metadata is a post's metadata as stored, lists joined by newlines so two
+// versions compare with ==
+type metadata struct {
+	summary, authors, tags, imageURL, commentsURL, language string
+}
+
+func metadataOf(p models.Post) metadata {
+	return metadata{
+		summary:     p.Summary,
+		authors:     joinList(p.Authors),
+		tags:        joinList(p.Tags),
+		imageURL:    p.ImageURL,
+		commentsURL: p.CommentsURL,
+		language:    p.Language,
+	}
+}
+
+// args are the values for the placeholders of the metadata columns
+func (m metadata) args() []any {
+	return []any{m.summary, m.authors, m.tags, m.imageURL, m.commentsURL, m.language}
+}
+
+// dest are scan destinations for metadataColumns
+func (m *metadata) dest() []any {
+	return []any{&m.summary, &m.authors, &m.tags, &m.imageURL, &m.commentsURL, &m.language}
+}
+
+// apply copies the metadata onto p
+func (m metadata) apply(p *models.Post) {
+	p.Summary = m.summary
+	p.Authors = splitList(m.authors)
+	p.Tags = splitList(m.tags)
+	p.ImageURL = m.imageURL
+	p.CommentsURL = m.commentsURL
+	p.Language = m.language
+}
 
-	var p models.Post
-	err := d.conn.QueryRowContext(ctx, query, postID).Scan(
+// joinList stores a list in one column. Entries are trimmed and blanks dropped,
+// so no entry can hold the separator.
+func joinList(items []string) string {
+	var kept []string
+	for _, item := range items {
+		item = strings.Join(strings.Fields(item), " ")
+		if item != "" {
+			kept = append(kept, item)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// splitList undoes joinList, nil for an empty column
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// postColumns is the column list scanPost expects, in order
+const postColumns = `id, feed_id, guid, title, link, content, published_at, updated_at, COALESCE(date_source, ''),
+	read, updated_since_read, starred_at, ` + metadataColumns
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanPost reads a row selected with postColumns, followed by any extra
+// columns the query added
+func scanPost(row rowScanner, extra ...any) (models.Post, error) {
+	var (
+		p         models.Post
+		starredAt sql.NullTime
+		meta      metadata
+	)
+	dest := append([]any{
+		&p.ID,
 		&p.FeedID,
+		&p.GUID,
 		&p.Title,
 		&p.Link,
 		&p.Content,
 		&p.PublishedAt,
 		&p.UpdatedAt,
-	)
+		&p.DateSource,
+		&p.Read,
+		&p.UpdatedSinceRead,
+		&starredAt,
+	}, meta.dest()...)
+	err := row.Scan(append(dest, extra...)...)
+	p.StarredAt = starredAt.Time
+	meta.apply(&p)
+	return p, err
+}
+
+func (d *DB) GetPost(ctx context.Context, postID int) (models.Post, error) {
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts
+		WHERE id = ?;
+	`
 
+	p, err := scanPost(d.conn.QueryRowContext(ctx, query, postID))
 	if err != nil {
 		return models.Post{}, fmt.Errorf("failed to get post id:%d, %w", postID, err)
 	}
 	return p, nil
 }
+
+// GetPostRevisions returns the earlier versions of a post, oldest first
+func (d *DB) GetPostRevisions(ctx context.Context, postID int) ([]models.PostRevision, error) {
+	query := `
+		SELECT id, post_id, title, link, COALESCE(content, ''), updated_at, replaced_at
+		FROM post_revisions
+		WHERE post_id = ?
+		ORDER BY id;
+	`
+
+	rows, err := d.conn.QueryContext(ctx, query, postID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revisions of post %d: %w", postID, err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("error closing rows: %v\n", err)
+		}
+	}()
+
+	var revisions []models.PostRevision
+	for rows.Next() {
+		var (
+			r         models.PostRevision
+			updatedAt sql.NullTime
+		)
+		if err := rows.Scan(&r.ID, &r.PostID, &r.Title, &r.Link, &r.Content, &updatedAt, &r.ReplacedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan revision: %w", err)
+		}
+		r.UpdatedAt = updatedAt.Time
+		revisions = append(revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating revisions: %w", err)
+	}
+	return revisions, nil
+}
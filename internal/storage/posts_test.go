@@ -0,0 +1,472 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// addTestFeed adds a feed and returns it with its ID filled in
+func addTestFeed(t testing.TB, db *DB, url, title string) models.Feed {
+	t.Helper()
+	if err := db.AddFeed(url, title); err != nil {
+		t.Fatalf("failed to add feed: %v", err)
+	}
+	feeds, err := db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	for _, f := range feeds {
+		if f.URL == url {
+			return f
+		}
+	}
+	t.Fatalf("feed %q not found after adding it", url)
+	return models.Feed{}
+}
+
+func TestSaveFeed(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	feed := addTestFeed(t, db, "https://example.com/feed.xml", "Old Title")
+
+	published := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	feed.Title = "New Title"
+	feed.ETag = `"v1"`
+	feed.Posts = []models.Post{
+		{GUID: "post-1", Title: "Post 1", Link: "https://example.com/1", Content: "One", PublishedAt: published, UpdatedAt: published},
+		{GUID: "post-2", Title: "Post 2", Link: "https://example.com/2", Content: "Two", PublishedAt: published, UpdatedAt: published},
+	}
+
+	stats, err := db.SaveFeed(ctx, feed)
+	if err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	if stats != (SyncStats{New: 2}) {
+		t.Errorf("first SaveFeed() stats = %+v, want 2 new", stats)
+	}
+
+	feeds, err := db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	if feeds[0].Title != "New Title" {
+		t.Errorf("feed title = %q, want %q", feeds[0].Title, "New Title")
+	}
+	if feeds[0].ETag != `"v1"` {
+		t.Errorf("feed etag = %q, want %q", feeds[0].ETag, `"v1"`)
+	}
+
+	// Same posts again, nothing to do
+	stats, err = db.SaveFeed(ctx, feed)
+	if err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	if stats != (SyncStats{Unchanged: 2}) {
+		t.Errorf("repeat SaveFeed() stats = %+v, want 2 unchanged", stats)
+	}
+
+	// Author edits post 2 and adds post 3
+	feed.Posts[1].Title = "Post 2 (edited)"
+	feed.Posts[1].UpdatedAt = published.Add(time.Hour)
+	feed.Posts = append(feed.Posts, models.Post{GUID: "post-3", Title: "Post 3", Link: "https://example.com/3", PublishedAt: published, UpdatedAt: published})
+
+	stats, err = db.SaveFeed(ctx, feed)
+	if err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	if stats != (SyncStats{New: 1, Updated: 1, Unchanged: 1}) {
+		t.Errorf("edited SaveFeed() stats = %+v, want 1 new, 1 updated, 1 unchanged", stats)
+	}
+
+	var title string
+	if err := db.conn.QueryRow(`SELECT title FROM posts WHERE link = ?`, "https://example.com/2").Scan(&title); err != nil {
+		t.Fatalf("failed to read back post: %v", err)
+	}
+	if title != "Post 2 (edited)" {
+		t.Errorf("post title = %q, want %q", title, "Post 2 (edited)")
+	}
+}
+
+func TestPostDateSource(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	feed := addTestFeed(t, db, "https://example.com/feed.xml", "Feed")
+	published := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	feed.Posts = []models.Post{
+		{GUID: "dc", Title: "DC", Link: "https://example.com/dc", PublishedAt: published, DateSource: models.DateDC},
+		{GUID: "unknown", Title: "Unknown", Link: "https://example.com/unknown", PublishedAt: published.Add(time.Hour)},
+	}
+	if _, err := db.SaveFeed(ctx, feed); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+
+	posts, _, err := db.ListPosts(ctx, PostQuery{FeedIDs: []int{feed.ID}})
+	if err != nil {
+		t.Fatalf("ListPosts() error = %v", err)
+	}
+	if len(posts) != 2 {
+		t.Fatalf("got %d posts, want 2", len(posts))
+	}
+	if posts[0].DateSource != "" {
+		t.Errorf("post without a source read back %q, want empty", posts[0].DateSource)
+	}
+	if posts[1].DateSource != models.DateDC {
+		t.Errorf("date source = %q, want %q", posts[1].DateSource, models.DateDC)
+	}
+}
+
+// TestSaveFeedUTC checks dates given in another zone are stored in UTC
+func TestSaveFeedUTC(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	feed := addTestFeed(t, db, "https://example.com/feed.xml", "Feed")
+	published := time.Date(2024, 1, 2, 5, 4, 5, 0, time.FixedZone("UTC+2", 2*60*60))
+
+	feed.Posts = []models.Post{{GUID: "1", Title: "One", Link: "https://example.com/1", PublishedAt: published, UpdatedAt: published}}
+	if _, err := db.SaveFeed(ctx, feed); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+
+	var stored string
+	if err := db.conn.QueryRow(`SELECT CAST(published_at AS TEXT) || ' ' || CAST(updated_at AS TEXT) FROM posts`).Scan(&stored); err != nil {
+		t.Fatalf("failed to read dates: %v", err)
+	}
+	if want := "2024-01-02 03:04:05+00:00 2024-01-02 03:04:05+00:00"; stored != want {
+		t.Errorf("stored dates = %q, want %q", stored, want)
+	}
+}
+
+// TestSaveFeedMetadata checks feed and post metadata are stored, and that new
+// metadata on an unchanged post is taken without counting as an edit
+func TestSaveFeedMetadata(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	feed := addTestFeed(t, db, "https://example.com/feed.xml", "Feed")
+	published := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	feed.SiteURL = "https://example.com/"
+	feed.Description = "About things"
+	feed.IconURL = "https://example.com/favicon.ico"
+	feed.Language = "en"
+	feed.Posts = []models.Post{{
+		GUID:        "post-1",
+		Title:       "Post 1",
+		Link:        "https://example.com/1",
+		Content:     "<p>Full text</p>",
+		Summary:     "Short",
+		PublishedAt: published,
+		Authors:     []string{"Ada Lovelace", " Charles\nBabbage "},
+		Tags:        []string{"maths", ""},
+		ImageURL:    "https://example.com/1.png",
+		CommentsURL: "https://example.com/1#comments",
+		Language:    "en",
+	}}
+	if _, err := db.SaveFeed(ctx, feed); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+
+	feeds, err := db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	got := feeds[0]
+	if got.SiteURL != feed.SiteURL || got.Description != feed.Description || got.IconURL != feed.IconURL ||
+		got.LogoURL != "" || got.Language != feed.Language {
+		t.Errorf("feed metadata = %q %q %q %q %q, want %q %q %q %q %q",
+			got.SiteURL, got.Description, got.IconURL, got.LogoURL, got.Language,
+			feed.SiteURL, feed.Description, feed.IconURL, "", feed.Language)
+	}
+
+	posts, _, err := db.ListPosts(ctx, PostQuery{FeedIDs: []int{feed.ID}})
+	if err != nil {
+		t.Fatalf("ListPosts() error = %v", err)
+	}
+	p := posts[0]
+	if p.Summary != "Short" || p.ImageURL != "https://example.com/1.png" ||
+		p.CommentsURL != "https://example.com/1#comments" || p.Language != "en" {
+		t.Errorf("post metadata = %q %q %q %q", p.Summary, p.ImageURL, p.CommentsURL, p.Language)
+	}
+	if want := []string{"Ada Lovelace", "Charles Babbage"}; !slices.Equal(p.Authors, want) {
+		t.Errorf("authors = %q, want %q", p.Authors, want)
+	}
+	if want := []string{"maths"}; !slices.Equal(p.Tags, want) {
+		t.Errorf("tags = %q, want %q", p.Tags, want)
+	}
+
+	// Retagging alone isn't an edit, but the new tags are kept
+	feed.Posts[0].Tags = []string{"maths", "history"}
+	stats, err := db.SaveFeed(ctx, feed)
+	if err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	if stats != (SyncStats{Unchanged: 1}) {
+		t.Errorf("retagged SaveFeed() stats = %+v, want 1 unchanged", stats)
+	}
+	p, err = db.GetPost(ctx, p.ID)
+	if err != nil {
+		t.Fatalf("GetPost() error = %v", err)
+	}
+	if want := []string{"maths", "history"}; !slices.Equal(p.Tags, want) {
+		t.Errorf("tags after retagging = %q, want %q", p.Tags, want)
+	}
+	if revisions, err := db.GetPostRevisions(ctx, p.ID); err != nil || len(revisions) != 0 {
+		t.Errorf("retagging left %d revisions (%v), want none", len(revisions), err)
+	}
+}
+
+// TestPostIdentity checks posts are told apart per feed by guid, not by link
+func TestPostIdentity(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	a := addTestFeed(t, db, "https://a.example.com/feed.xml", "A")
+	b := addTestFeed(t, db, "https://b.example.com/feed.xml", "B")
+
+	shared := models.Post{GUID: "same-guid", Title: "Shared article", Link: "https://news.example.com/story"}
+	noLink := models.Post{Title: "Status update without a link"}
+	a.Posts = []models.Post{shared, noLink}
+	b.Posts = []models.Post{shared}
+
+	for _, f := range []models.Feed{a, b} {
+		stats, err := db.SaveFeed(ctx, f)
+		if err != nil {
+			t.Fatalf("SaveFeed(%s) error = %v", f.Title, err)
+		}
+		if stats.New != len(f.Posts) {
+			t.Errorf("SaveFeed(%s) stats = %+v, want %d new", f.Title, stats, len(f.Posts))
+		}
+	}
+
+	// Without a guid the link and title are the identity
+	noLink.Title = "A different status"
+	a.Posts = []models.Post{shared, noLink}
+	stats, err := db.SaveFeed(ctx, a)
+	if err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	if stats != (SyncStats{New: 1, Unchanged: 1}) {
+		t.Errorf("SaveFeed() stats = %+v, want 1 new and 1 unchanged", stats)
+	}
+
+	posts, _, err := db.ListPosts(ctx, PostQuery{FeedIDs: []int{a.ID}})
+	if err != nil {
+		t.Fatalf("ListPosts() error = %v", err)
+	}
+	if len(posts) != 3 {
+		t.Errorf("feed A has %d posts, want 3", len(posts))
+	}
+}
+
+// TestPostRevisions checks edits are noticed by content, kept as revisions and
+// flagged on posts that were already read
+func TestPostRevisions(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	feed := addTestFeed(t, db, "https://example.com/feed.xml", "Feed")
+
+	published := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	post := models.Post{GUID: "post-1", Title: "Post", Link: "https://example.com/1", Content: "First draft", PublishedAt: published, UpdatedAt: published}
+	save := func(p models.Post) SyncStats {
+		t.Helper()
+		feed.Posts = []models.Post{p}
+		stats, err := db.SaveFeed(ctx, feed)
+		if err != nil {
+			t.Fatalf("SaveFeed() error = %v", err)
+		}
+		return stats
+	}
+	get := func() models.Post {
+		t.Helper()
+		posts, _, err := db.ListPosts(ctx, PostQuery{FeedIDs: []int{feed.ID}})
+		if err != nil || len(posts) != 1 {
+			t.Fatalf("ListPosts() = %d posts, %v", len(posts), err)
+		}
+		return posts[0]
+	}
+
+	save(post)
+	id := get().ID
+	if err := db.MarkRead(ctx, id); err != nil {
+		t.Fatalf("MarkRead() error = %v", err)
+	}
+
+	// A bumped date with the same text isn't an edit
+	post.UpdatedAt = published.Add(time.Hour)
+	if stats := save(post); stats != (SyncStats{Unchanged: 1}) {
+		t.Errorf("date-only change stats = %+v, want 1 unchanged", stats)
+	}
+
+	// Edited without touching the date still counts
+	post.Content = "Second draft"
+	if stats := save(post); stats != (SyncStats{Updated: 1}) {
+		t.Errorf("silent edit stats = %+v, want 1 updated", stats)
+	}
+	got := get()
+	if got.Content != "Second draft" || !got.Read || !got.UpdatedSinceRead {
+		t.Errorf("after edit post = %+v, want new content, read and updated since read", got)
+	}
+
+	// An older copy from a stale cache is ignored
+	stale := post
+	stale.Content = "Ancient draft"
+	stale.UpdatedAt = published
+	if stats := save(stale); stats != (SyncStats{Unchanged: 1}) {
+		t.Errorf("stale copy stats = %+v, want 1 unchanged", stats)
+	}
+
+	revisions, err := db.GetPostRevisions(ctx, id)
+	if err != nil {
+		t.Fatalf("GetPostRevisions() error = %v", err)
+	}
+	if len(revisions) != 1 {
+		t.Fatalf("got %d revisions, want 1", len(revisions))
+	}
+	if revisions[0].Content != "First draft" || !revisions[0].UpdatedAt.Equal(published.Add(time.Hour)) {
+		t.Errorf("revision = %+v, want the first draft as of the bumped date", revisions[0])
+	}
+
+	if err := db.MarkRead(ctx, id); err != nil {
+		t.Fatalf("MarkRead() error = %v", err)
+	}
+	if get().UpdatedSinceRead {
+		t.Error("MarkRead() didn't clear updated since read")
+	}
+
+	// Unread posts that change aren't flagged, they haven't been read yet
+	if err := db.MarkUnread(ctx, id); err != nil {
+		t.Fatalf("MarkUnread() error = %v", err)
+	}
+	post.Content = "Third draft"
+	post.UpdatedAt = published.Add(2 * time.Hour)
+	save(post)
+	if got := get(); got.UpdatedSinceRead {
+		t.Error("unread post flagged as updated since read")
+	}
+}
+
+func TestAddPosts(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	feed := addTestFeed(t, db, "https://example.com/feed.xml", "Example")
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	posts := []models.Post{
+		{GUID: "1", Title: "One", Content: "first", UpdatedAt: at},
+		{GUID: "2", Title: "Two", Content: "second", UpdatedAt: at},
+	}
+	outcomes, err := db.AddPosts(ctx, feed.ID, posts)
+	if err != nil {
+		t.Fatalf("AddPosts() error = %v", err)
+	}
+	if want := []PostOutcome{PostInserted, PostInserted}; !slices.Equal(outcomes, want) {
+		t.Errorf("AddPosts() = %v, want %v", outcomes, want)
+	}
+
+	posts[1].Content = "second, edited"
+	posts[1].UpdatedAt = at.Add(time.Hour)
+	posts = append(posts, models.Post{GUID: "3", Title: "Three", UpdatedAt: at})
+	outcomes, err = db.AddPosts(ctx, feed.ID, posts)
+	if err != nil {
+		t.Fatalf("second AddPosts() error = %v", err)
+	}
+	if want := []PostOutcome{PostSkipped, PostUpdated, PostInserted}; !slices.Equal(outcomes, want) {
+		t.Errorf("second AddPosts() = %v, want %v", outcomes, want)
+	}
+
+	// A failure part way through leaves nothing behind
+	_, err = db.conn.Exec(`
+		CREATE TRIGGER fail_boom BEFORE INSERT ON posts WHEN new.title = 'Boom'
+		BEGIN SELECT RAISE(ABORT, 'boom'); END
+	`)
+	if err != nil {
+		t.Fatalf("failed to create trigger: %v", err)
+	}
+	batch := []models.Post{
+		{GUID: "4", Title: "Four", UpdatedAt: at},
+		{GUID: "5", Title: "Boom", UpdatedAt: at},
+	}
+	if _, err := db.AddPosts(ctx, feed.ID, batch); err == nil {
+		t.Fatal("AddPosts() with a failing post succeeded")
+	}
+	var n int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM posts WHERE feed_id = ?`, feed.ID).Scan(&n); err != nil {
+		t.Fatalf("failed to count posts: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("after a failed batch the feed has %d posts, want 3", n)
+	}
+}
+
+// largeFeedPosts scales rss/testdata/large_feed.xml up to n items, with a
+// paragraph of content each like a typical blog feed
+func largeFeedPosts(n int) []models.Post {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	body := strings.Repeat("<p>Lorem ipsum dolor sit amet, consectetur adipiscing elit.</p>", 16)
+	posts := make([]models.Post, n)
+	for i := range posts {
+		posts[i] = models.Post{
+			Title:       fmt.Sprintf("Post %d", i+1),
+			Link:        fmt.Sprintf("http://example.com/%d", i+1),
+			Content:     fmt.Sprintf("<p>Content %d</p>", i+1) + body,
+			PublishedAt: at.Add(time.Duration(i) * time.Minute),
+			UpdatedAt:   at.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	return posts
+}
+
+// addPostsUnbatched is how AddPosts used to work, one autocommitted insert
+// per post, kept to compare against
+func addPostsUnbatched(db *DB, feedID int, posts []models.Post) error {
+	query := `INSERT INTO posts (feed_id, guid, title, link, content, published_at, updated_at, content_hash)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(feed_id, guid) DO NOTHING;`
+	for i := range posts {
+		_, err := db.conn.Exec(query, feedID, posts[i].Identity(), posts[i].Title, posts[i].Link,
+			posts[i].Content, posts[i].PublishedAt, posts[i].UpdatedAt, posts[i].ContentHash())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func BenchmarkAddPosts(b *testing.B) {
+	for _, n := range []int{50, 500, 5000} {
+		posts := largeFeedPosts(n)
+		paths := []struct {
+			name string
+			add  func(db *DB, feedID int) error
+		}{
+			{"unbatched", func(db *DB, feedID int) error {
+				return addPostsUnbatched(db, feedID, posts)
+			}},
+			{"batched", func(db *DB, feedID int) error {
+				_, err := db.AddPosts(context.Background(), feedID, posts)
+				return err
+			}},
+		}
+		for _, path := range paths {
+			b.Run(fmt.Sprintf("%s/%d", path.name, n), func(b *testing.B) {
+				db := setupTestDB(b)
+				feed := addTestFeed(b, db, "https://example.com/feed.xml", "Large Feed")
+				for b.Loop() {
+					b.StopTimer()
+					if _, err := db.conn.Exec(`DELETE FROM posts`); err != nil {
+						b.Fatalf("failed to clear posts: %v", err)
+					}
+					b.StartTimer()
+					if err := path.add(db, feed.ID); err != nil {
+						b.Fatalf("adding posts failed: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// MarkRead flags a single post as read, which also acknowledges any update
+// made to it since it was last read
+func (d *DB) MarkRead(ctx context.Context, postID int) error {
+	return d.setRead(ctx, postID, true)
+}
+
+// MarkUnread flags a single post as unread again
+func (d *DB) MarkUnread(ctx context.Context, postID int) error {
+	return d.setRead(ctx, postID, false)
+}
+
+func (d *DB) setRead(ctx context.Context, postID int, read bool) error {
+	query := `UPDATE posts SET read = ?, updated_since_read = 0 WHERE id = ?`
+	res, err := d.conn.ExecContext(ctx, query, read, postID)
+	if err != nil {
+		return fmt.Errorf("failed to set read=%t on post %d: %w", read, postID, err)
+	}
+	return postUpdated(res, postID)
+}
+
+// MarkFeedRead marks every post of a feed published before the given time as
+// read, so posts that arrived while the user was catching up stay unread.
+// It returns how many posts changed. before can be in any time zone.
+func (d *DB) MarkFeedRead(ctx context.Context, feedID int, before time.Time) (int, error) {
+	query := `
+		UPDATE posts
+		SET read = 1, updated_since_read = 0
+		WHERE feed_id = ? AND (read = 0 OR updated_since_read = 1) AND published_at < ?
+	`
+	// Dates are stored in UTC and compared as text, so the bound has to be too
+	res, err := d.conn.ExecContext(ctx, query, feedID, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark feed %d read: %w", feedID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count posts marked read: %w", err)
+	}
+	return int(n), nil
+}
+
+// MarkAllRead marks every post in every feed as read and returns how many changed
+func (d *DB) MarkAllRead(ctx context.Context) (int, error) {
+	res, err := d.conn.ExecContext(ctx, `UPDATE posts SET read = 1, updated_since_read = 0 WHERE read = 0 OR updated_since_read = 1`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark all posts read: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count posts marked read: %w", err)
+	}
+	return int(n), nil
+}
+
+// UnreadCounts returns the number of unread posts per feed id, counted in SQL
+// so callers don't need to load any posts. Feeds with nothing unread are omitted.
+func (d *DB) UnreadCounts(ctx context.Context) (map[int]int, error) {
+	query := `
+		SELECT feed_id, COUNT(*)
+		FROM posts
+		WHERE read = 0
+		GROUP BY feed_id
+	`
+	rows, err := d.conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count unread posts: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("error closing rows: %v\n", err)
+		}
+	}()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var feedID, n int
+		if err := rows.Scan(&feedID, &n); err != nil {
+			return nil, err
+		}
+		counts[feedID] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating unread counts: %w", err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// seedPosts stores posts published an hour apart, oldest first, and returns their IDs
+func seedPosts(t *testing.T, db *DB, feed models.Feed, n int, start time.Time) []int {
+	t.Helper()
+	ctx := context.Background()
+	for i := range n {
+		at := start.Add(time.Duration(i) * time.Hour)
+		feed.Posts = append(feed.Posts, models.Post{
+			Title:       "Post",
+			Link:        feed.URL + "/" + at.Format(time.RFC3339),
+			PublishedAt: at,
+			UpdatedAt:   at,
+		})
+	}
+	if _, err := db.SaveFeed(ctx, feed); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+
+	rows, err := db.conn.Query(`SELECT id FROM posts WHERE feed_id = ? ORDER BY published_at`, feed.ID)
+	if err != nil {
+		t.Fatalf("failed to list post ids: %v", err)
+	}
+	defer func() { _ = rows.Close() }()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("failed to scan post id: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestMarkReadUnread(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	feed := addTestFeed(t, db, "https://example.com/feed.xml", "Feed")
+	ids := seedPosts(t, db, feed, 2, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	if err := db.MarkRead(ctx, ids[0]); err != nil {
+		t.Fatalf("MarkRead() error = %v", err)
+	}
+	p, err := db.GetPost(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("GetPost() error = %v", err)
+	}
+	if p.ID != ids[0] {
+		t.Errorf("GetPost().ID = %d, want %d", p.ID, ids[0])
+	}
+	if !p.Read {
+		t.Errorf("post %d should be read", ids[0])
+	}
+
+	if err := db.MarkUnread(ctx, ids[0]); err != nil {
+		t.Fatalf("MarkUnread() error = %v", err)
+	}
+	p, err = db.GetPost(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("GetPost() error = %v", err)
+	}
+	if p.Read {
+		t.Errorf("post %d should be unread", ids[0])
+	}
+
+	if err := db.MarkRead(ctx, 999); !errors.Is(err, ErrNoPost) {
+		t.Errorf("MarkRead() on an unknown post error = %v, want ErrNoPost", err)
+	}
+}
+
+func TestMarkFeedRead(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	feed := addTestFeed(t, db, "https://example.com/a.xml", "A")
+	other := addTestFeed(t, db, "https://example.com/b.xml", "B")
+	seedPosts(t, db, feed, 4, start)
+	seedPosts(t, db, other, 3, start)
+
+	// Only the first two posts are older than the cutoff
+	n, err := db.MarkFeedRead(ctx, feed.ID, start.Add(90*time.Minute))
+	if err != nil {
+		t.Fatalf("MarkFeedRead() error = %v", err)
+	}
+	if n != 2 {
+		t.Errorf("MarkFeedRead() marked %d posts, want 2", n)
+	}
+
+	// A cutoff in a zone behind UTC is the same moment, the third post
+	// is the only one newly before it
+	behind := time.FixedZone("UTC-5", -5*60*60)
+	n, err = db.MarkFeedRead(ctx, feed.ID, start.Add(150*time.Minute).In(behind))
+	if err != nil {
+		t.Fatalf("MarkFeedRead() error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("MarkFeedRead() with a UTC-5 cutoff marked %d posts, want 1", n)
+	}
+
+	counts, err := db.UnreadCounts(ctx)
+	if err != nil {
+		t.Fatalf("UnreadCounts() error = %v", err)
+	}
+	if counts[feed.ID] != 1 || counts[other.ID] != 3 {
+		t.Errorf("UnreadCounts() = %v, want %d:1 %d:3", counts, feed.ID, other.ID)
+	}
+
+	feeds, err := db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	for _, f := range feeds {
+		if f.UnreadCount() != counts[f.ID] {
+			t.Errorf("feed %d UnreadCount() = %d, want %d", f.ID, f.UnreadCount(), counts[f.ID])
+		}
+	}
+
+	n, err = db.MarkAllRead(ctx)
+	if err != nil {
+		t.Fatalf("MarkAllRead() error = %v", err)
+	}
+	if n != 4 {
+		t.Errorf("MarkAllRead() marked %d posts, want 4", n)
+	}
+	counts, err = db.UnreadCounts(ctx)
+	if err != nil {
+		t.Fatalf("UnreadCounts() error = %v", err)
+	}
+	if len(counts) != 0 {
+		t.Errorf("UnreadCounts() after MarkAllRead = %v, want empty", counts)
+	}
+}
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Inherit in a feed's RetentionPolicy means the global setting applies
+const Inherit = -1
+
+// RetentionPolicy says how long posts are kept. Each rule is off when zero.
+type RetentionPolicy struct {
+	// MaxAgeDays removes posts published longer ago than this
+	MaxAgeDays int
+	// MaxPosts keeps only the newest posts of each feed
+	MaxPosts int
+	// ReadMaxAgeDays removes read posts published longer ago than this
+	ReadMaxAgeDays int
+}
+
+// Merge fills the rules p leaves as Inherit from global
+func (p RetentionPolicy) Merge(global RetentionPolicy) RetentionPolicy {
+	if p.MaxAgeDays == Inherit {
+		p.MaxAgeDays = global.MaxAgeDays
+	}
+	if p.MaxPosts == Inherit {
+		p.MaxPosts = global.MaxPosts
+	}
+	if p.ReadMaxAgeDays == Inherit {
+		p.ReadMaxAgeDays = global.ReadMaxAgeDays
+	}
+	return p
+}
+
+// IsZero reports whether the policy keeps everything
+func (p RetentionPolicy) IsZero() bool {
+	return p.MaxAgeDays <= 0 && p.MaxPosts <= 0 && p.ReadMaxAgeDays <= 0
+}
+
+// RetentionPolicy returns the global policy, which keeps everything until set
+func (d *DB) RetentionPolicy(ctx context.Context) (RetentionPolicy, error) {
+	var (
+		p   RetentionPolicy
+		err error
+	)
+	if p.MaxAgeDays, err = getIntSetting(ctx, d.conn, "retention.max_age_days", 0); err != nil {
+		return RetentionPolicy{}, err
+	}
+	if p.MaxPosts, err = getIntSetting(ctx, d.conn, "retention.max_posts", 0); err != nil {
+		return RetentionPolicy{}, err
+	}
+	if p.ReadMaxAgeDays, err = getIntSetting(ctx, d.conn, "retention.read_max_age_days", 0); err != nil {
+		return RetentionPolicy{}, err
+	}
+	return p, nil
+}
+
+// SetRetentionPolicy replaces the global policy
+func (d *DB) SetRetentionPolicy(ctx context.Context, p RetentionPolicy) error {
+	if p.MaxAgeDays < 0 || p.MaxPosts < 0 || p.ReadMaxAgeDays < 0 {
+		return fmt.Errorf("global retention can't inherit or be negative: %+v", p)
+	}
+	return d.withTx(ctx, func(tx *sql.Tx) error {
+		if err := setIntSetting(ctx, tx, "retention.max_age_days", p.MaxAgeDays); err != nil {
+			return err
+		}
+		if err := setIntSetting(ctx, tx, "retention.max_posts", p.MaxPosts); err != nil {
+			return err
+		}
+		return setIntSetting(ctx, tx, "retention.read_max_age_days", p.ReadMaxAgeDays)
+	})
+}
+
+// FeedRetentionPolicy returns a feed's own overrides, with Inherit for the
+// rules it leaves to the global policy
+func (d *DB) FeedRetentionPolicy(ctx context.Context, feedID int) (RetentionPolicy, error) {
+	query := `
+		SELECT retain_max_age_days, retain_max_posts, retain_read_max_age_days
+		FROM feeds
+		WHERE id = ?
+	`
+	var maxAge, maxPosts, readMaxAge sql.NullInt64
+	if err := d.conn.QueryRowContext(ctx, query, feedID).Scan(&maxAge, &maxPosts, &readMaxAge); err != nil {
+		return RetentionPolicy{}, fmt.Errorf("failed to get retention of feed %d: %w", feedID, err)
+	}
+	return RetentionPolicy{
+		MaxAgeDays:     inheritIfNull(maxAge),
+		MaxPosts:       inheritIfNull(maxPosts),
+		ReadMaxAgeDays: inheritIfNull(readMaxAge),
+	}, nil
+}
+
+// SetFeedRetentionPolicy stores a feed's overrides. Rules set to Inherit
+// follow the global policy, zero keeps that feed's posts regardless of it.
+func (d *DB) SetFeedRetentionPolicy(ctx context.Context, feedID int, p RetentionPolicy) error {
+	query := `
+		UPDATE feeds
+		SET retain_max_age_days = ?, retain_max_posts = ?, retain_read_max_age_days = ?
+		WHERE id = ?
+	`
+	_, err := d.conn.ExecContext(ctx, query,
+		nullIfInherit(p.MaxAgeDays), nullIfInherit(p.MaxPosts), nullIfInherit(p.ReadMaxAgeDays), feedID)
+	if err != nil {
+		return fmt.Errorf("failed to set retention of feed %d: %w", feedID, err)
+	}
+	return nil
+}
+
+func inheritIfNull(n sql.NullInt64) int {
+	if !n.Valid {
+		return Inherit
+	}
+	return int(n.Int64)
+}
+
+func nullIfInherit(n int) sql.NullInt64 {
+	if n < 0 {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(n), Valid: true}
+}
+
+// PruneResult is how many posts pruning removed from one feed
+type PruneResult struct {
+	FeedID int
+	Title  string
+	Posts  int
+}
+
+// Prune deletes the posts each feed's retention policy no longer keeps,
+// remembering their identity so the next refresh doesn't bring them back.
+// Posts pruned earlier are forgotten once their feed stops listing them.
+// Files downloaded for their enclosures are deleted too, if that fails the
+// posts are gone all the same and the results come back with the error.
+// With dryRun nothing is deleted and the results say what would have been.
+// Feeds that lose nothing are left out.
+func (d *DB) Prune(ctx context.Context, dryRun bool) ([]PruneResult, error) {
+	global, err := d.RetentionPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type feedPolicy struct {
+		PruneResult
+		policy RetentionPolicy
+	}
+	var feeds []feedPolicy
+	rows, err := d.conn.QueryContext(ctx, `
+		SELECT id, COALESCE(title, ''), retain_max_age_days, retain_max_posts, retain_read_max_age_days
+		FROM feeds
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read retention policies: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("error closing rows: %v\n", err)
+		}
+	}()
+	for rows.Next() {
+		var (
+			f                            feedPolicy
+			maxAge, maxPosts, readMaxAge sql.NullInt64
+		)
+		if err := rows.Scan(&f.FeedID, &f.Title, &maxAge, &maxPosts, &readMaxAge); err != nil {
+			return nil, fmt.Errorf("failed to scan retention policy: %w", err)
+		}
+		f.policy = RetentionPolicy{
+			MaxAgeDays:     inheritIfNull(maxAge),
+			MaxPosts:       inheritIfNull(maxPosts),
+			ReadMaxAgeDays: inheritIfNull(readMaxAge),
+		}.Merge(global)
+		feeds = append(feeds, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating feeds: %w", err)
+	}
+
+	now := time.Now().UTC()
+	var (
+		results []PruneResult
+		files   []string
+	)
+	err = d.withTx(ctx, func(tx *sql.Tx) error {
+		results, files = nil, nil
+		if !dryRun {
+			if err := forgetPruned(ctx, tx); err != nil {
+				return err
+			}
+		}
+		for _, f := range feeds {
+			if f.policy.IsZero() {
+				continue
+			}
+			n, downloads, err := pruneFeed(ctx, tx, f.FeedID, f.policy, now, dryRun)
+			if err != nil {
+				return err
+			}
+			files = append(files, downloads...)
+			if n > 0 {
+				f.Posts = n
+				results = append(results, f.PruneResult)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to prune posts: %w", err)
+	}
+	return results, removeDownloads(files)
+}
+
+// pruneFeed removes one feed's posts that p doesn't keep and returns how many
+// there were, along with the files their downloads left for the caller to
+// delete once the removal is committed. Posts without a date are only ever
+// pruned by MaxPosts.
+func pruneFeed(ctx context.Context, tx *sql.Tx, feedID int, p RetentionPolicy, now time.Time, dryRun bool) (int, []string, error) {
+	var (
+		rules []string
+		args  = []any{feedID}
+	)
+	if p.MaxPosts > 0 {
+		rules = append(rules, `rank > ?`)
+		args = append(args, p.MaxPosts)
+	}
+	if p.MaxAgeDays > 0 {
+		rules = append(rules, `(published_at > ? AND published_at < ?)`)
+		args = append(args, time.Time{}, now.AddDate(0, 0, -p.MaxAgeDays))
+	}
+	if p.ReadMaxAgeDays > 0 {
+		rules = append(rules, `(read = 1 AND published_at > ? AND published_at < ?)`)
+		args = append(args, time.Time{}, now.AddDate(0, 0, -p.ReadMaxAgeDays))
+	}
+
+	// The newest posts win MaxPosts. Starred posts are never pruned and
+	// don't take up a place.
+	selectQuery := `
+		SELECT id FROM (
+			SELECT id, read, published_at,
+				ROW_NUMBER() OVER (ORDER BY published_at DESC, id DESC) AS rank
+			FROM posts
+			WHERE feed_id = ? AND starred_at IS NULL
+		)
+		WHERE ` + strings.Join(rules, " OR ")
+
+	if dryRun {
+		var n int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM (`+selectQuery+`)`, args...).Scan(&n); err != nil {
+			return 0, nil, fmt.Errorf("failed to count prunable posts of feed %d: %w", feedID, err)
+		}
+		return n, nil, nil
+	}
+
+	files, err := downloadFiles(ctx, tx, selectQuery, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tombstones := `
+		INSERT OR IGNORE INTO pruned_posts (feed_id, guid, pruned_at, seen_at)
+		SELECT feed_id, guid, ?, ? FROM posts WHERE id IN (` + selectQuery + `)
+	`
+	if _, err := tx.ExecContext(ctx, tombstones, append([]any{now, now}, args...)...); err != nil {
+		return 0, nil, fmt.Errorf("failed to record pruned posts of feed %d: %w", feedID, err)
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM posts WHERE id IN (`+selectQuery+`)`, args...)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to prune posts of feed %d: %w", feedID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to count pruned posts: %w", err)
+	}
+	return int(n), files, nil
+}
+
+// forgetPruned drops the record of pruned posts the feed didn't list when it
+// was last saved. They can't come back from a feed that no longer has them.
+func forgetPruned(ctx context.Context, tx *sql.Tx) error {
+	query := `
+		DELETE FROM pruned_posts
+		WHERE seen_at < (SELECT saved_at FROM feeds WHERE feeds.id = pruned_posts.feed_id)
+	`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to forget pruned posts: %w", err)
+	}
+	return nil
+}
+
+// Vacuum hands the pages freed by pruning back to the filesystem. Databases
+// created before incremental auto_vacuum was enabled get one full VACUUM to
+// switch them over (every connection already asks for it, see dsn), which
+// rewrites the whole file.
+func (d *DB) Vacuum(ctx context.Context) error {
+	var mode int
+	if err := d.conn.QueryRowContext(ctx, `PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+		return fmt.Errorf("failed to read auto_vacuum mode: %w", err)
+	}
+	// 2 is INCREMENTAL
+	if mode != 2 {
+		if _, err := d.conn.ExecContext(ctx, `VACUUM`); err != nil {
+			return fmt.Errorf("failed to vacuum database: %w", err)
+		}
+		return nil
+	}
+	if _, err := d.conn.ExecContext(ctx, `PRAGMA incremental_vacuum`); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// postsDaysAgo gives a feed n posts, newest first, the i-th published i and a
+// half days ago so day based cutoffs never land on one
+func postsDaysAgo(feed models.Feed, n int) models.Feed {
+	now := time.Now().UTC()
+	feed.Posts = nil
+	for i := range n {
+		at := now.AddDate(0, 0, -(i + 1)).Add(12 * time.Hour)
+		feed.Posts = append(feed.Posts, models.Post{
+			GUID:        fmt.Sprintf("%s-%d", feed.Title, i+1),
+			Title:       fmt.Sprintf("Post %d", i+1),
+			PublishedAt: at,
+			UpdatedAt:   at,
+		})
+	}
+	return feed
+}
+
+func countPosts(t *testing.T, db *DB, feedID int) int {
+	t.Helper()
+	var n int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM posts WHERE feed_id = ?`, feedID).Scan(&n); err != nil {
+		t.Fatalf("failed to count posts: %v", err)
+	}
+	return n
+}
+
+func TestRetentionPolicies(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	feed := addTestFeed(t, db, "https://example.com/feed.xml", "Feed")
+
+	global, err := db.RetentionPolicy(ctx)
+	if err != nil {
+		t.Fatalf("RetentionPolicy() error = %v", err)
+	}
+	if !global.IsZero() {
+		t.Errorf("default global policy = %+v, want keep everything", global)
+	}
+	override, err := db.FeedRetentionPolicy(ctx, feed.ID)
+	if err != nil {
+		t.Fatalf("FeedRetentionPolicy() error = %v", err)
+	}
+	if want := (RetentionPolicy{Inherit, Inherit, Inherit}); override != want {
+		t.Errorf("default feed policy = %+v, want %+v", override, want)
+	}
+
+	global = RetentionPolicy{MaxAgeDays: 30, MaxPosts: 100}
+	if err := db.SetRetentionPolicy(ctx, global); err != nil {
+		t.Fatalf("SetRetentionPolicy() error = %v", err)
+	}
+	if err := db.SetRetentionPolicy(ctx, RetentionPolicy{MaxPosts: Inherit}); err == nil {
+		t.Error("SetRetentionPolicy() accepted Inherit for the global policy")
+	}
+	override = RetentionPolicy{MaxAgeDays: 0, MaxPosts: Inherit, ReadMaxAgeDays: 7}
+	if err := db.SetFeedRetentionPolicy(ctx, feed.ID, override); err != nil {
+		t.Fatalf("SetFeedRetentionPolicy() error = %v", err)
+	}
+
+	gotGlobal, err := db.RetentionPolicy(ctx)
+	if err != nil || gotGlobal != global {
+		t.Errorf("RetentionPolicy() = %+v, %v, want %+v", gotGlobal, err, global)
+	}
+	gotOverride, err := db.FeedRetentionPolicy(ctx, feed.ID)
+	if err != nil || gotOverride != override {
+		t.Errorf("FeedRetentionPolicy() = %+v, %v, want %+v", gotOverride, err, override)
+	}
+	if want := (RetentionPolicy{MaxAgeDays: 0, MaxPosts: 100, ReadMaxAgeDays: 7}); gotOverride.Merge(gotGlobal) != want {
+		t.Errorf("Merge() = %+v, want %+v", gotOverride.Merge(gotGlobal), want)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	a := postsDaysAgo(addTestFeed(t, db, "https://a.example.com/feed.xml", "A"), 5)
+	b := postsDaysAgo(addTestFeed(t, db, "https://b.example.com/feed.xml", "B"), 3)
+	for _, f := range []models.Feed{a, b} {
+		if _, err := db.SaveFeed(ctx, f); err != nil {
+			t.Fatalf("SaveFeed() error = %v", err)
+		}
+	}
+
+	// Nothing is pruned until a policy says so
+	results, err := db.Prune(ctx, false)
+	if err != nil || results != nil {
+		t.Fatalf("Prune() with no policy = %+v, %v, want nothing", results, err)
+	}
+
+	// A keeps its newest 3 by the global rule, B opts out of that but drops
+	// read posts after 2 days
+	if err := db.SetRetentionPolicy(ctx, RetentionPolicy{MaxPosts: 3}); err != nil {
+		t.Fatalf("SetRetentionPolicy() error = %v", err)
+	}
+	if err := db.SetFeedRetentionPolicy(ctx, b.ID, RetentionPolicy{MaxAgeDays: Inherit, MaxPosts: 0, ReadMaxAgeDays: 2}); err != nil {
+		t.Fatalf("SetFeedRetentionPolicy() error = %v", err)
+	}
+	if _, err := db.MarkAllRead(ctx); err != nil {
+		t.Fatalf("MarkAllRead() error = %v", err)
+	}
+
+	want := []PruneResult{{FeedID: a.ID, Title: "A", Posts: 2}, {FeedID: b.ID, Title: "B", Posts: 1}}
+	results, err = db.Prune(ctx, true)
+	if err != nil {
+		t.Fatalf("Prune(dry run) error = %v", err)
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("Prune(dry run) = %+v, want %+v", results, want)
+	}
+	if got := countPosts(t, db, a.ID); got != 5 {
+		t.Errorf("dry run left %d posts in A, want 5", got)
+	}
+
+	results, err = db.Prune(ctx, false)
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("Prune() = %+v, want %+v", results, want)
+	}
+	posts, _, err := db.ListPosts(ctx, PostQuery{FeedIDs: []int{a.ID}})
+	if err != nil {
+		t.Fatalf("ListPosts() error = %v", err)
+	}
+	if len(posts) != 3 || posts[2].Title != "Post 3" {
+		t.Errorf("A kept %d posts ending with %q, want the newest 3", len(posts), posts[len(posts)-1].Title)
+	}
+	if got := countPosts(t, db, b.ID); got != 2 {
+		t.Errorf("B kept %d posts, want 2", got)
+	}
+
+	// The feed still lists the pruned posts, they must not come back
+	stats, err := db.SaveFeed(ctx, a)
+	if err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	if stats.New != 0 || countPosts(t, db, a.ID) != 3 {
+		t.Errorf("refresh after prune stats = %+v, %d posts, want pruned posts left out", stats, countPosts(t, db, a.ID))
+	}
+
+	// Pruned posts are remembered while the feed lists them and forgotten
+	// once it has moved on
+	tombstones := func() int {
+		t.Helper()
+		var n int
+		if err := db.conn.QueryRow(`SELECT COUNT(*) FROM pruned_posts WHERE feed_id = ?`, a.ID).Scan(&n); err != nil {
+			t.Fatalf("failed to count pruned posts: %v", err)
+		}
+		return n
+	}
+	if _, err := db.Prune(ctx, false); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if got := tombstones(); got != 2 {
+		t.Errorf("A remembers %d pruned posts while still listing them, want 2", got)
+	}
+	a.Posts = a.Posts[:3]
+	if _, err := db.SaveFeed(ctx, a); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	if _, err := db.Prune(ctx, false); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if got := tombstones(); got != 0 {
+		t.Errorf("A remembers %d pruned posts it no longer lists, want 0", got)
+	}
+
+	if err := db.Vacuum(ctx); err != nil {
+		t.Errorf("Vacuum() error = %v", err)
+	}
+}
+
+// TestPruneDownloads checks files downloaded for pruned or unsubscribed posts
+// are deleted with them
+func TestPruneDownloads(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	feed := postsDaysAgo(addTestFeed(t, db, "https://example.com/podcast.xml", "Podcast"), 2)
+	for i := range feed.Posts {
+		feed.Posts[i].Enclosures = []models.Enclosure{{URL: fmt.Sprintf("https://cdn.example.com/%d.mp3", i+1)}}
+	}
+	if _, err := db.SaveFeed(ctx, feed); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	enclosures, err := db.ListEnclosures(ctx, EnclosureQuery{FeedID: feed.ID})
+	if err != nil || len(enclosures) != 2 {
+		t.Fatalf("ListEnclosures() = %d enclosures, %v, want 2", len(enclosures), err)
+	}
+
+	// The old episode finished downloading, the new one is halfway there
+	old, current := enclosures[1], enclosures[0]
+	old.State, old.Path = models.DownloadDone, filepath.Join(dir, "2.mp3")
+	current.State, current.Path = models.DownloadActive, filepath.Join(dir, "1.mp3")
+	for _, e := range []models.Enclosure{old, current} {
+		if err := db.UpdateDownload(ctx, e); err != nil {
+			t.Fatalf("UpdateDownload() error = %v", err)
+		}
+	}
+	for _, path := range []string{old.Path, current.PartPath()} {
+		if err := os.WriteFile(path, []byte("audio"), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	if err := db.SetRetentionPolicy(ctx, RetentionPolicy{MaxPosts: 1}); err != nil {
+		t.Fatalf("SetRetentionPolicy() error = %v", err)
+	}
+	if _, err := db.Prune(ctx, false); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if _, err := os.Stat(old.Path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("download of the pruned post is still there: %v", err)
+	}
+	if _, err := os.Stat(current.PartPath()); err != nil {
+		t.Errorf("download of the kept post is gone: %v", err)
+	}
+
+	if err := db.DeleteFeedContext(ctx, feed.ID); err != nil {
+		t.Fatalf("DeleteFeedContext() error = %v", err)
+	}
+	if _, err := os.Stat(current.PartPath()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unfinished download of the deleted feed is still there: %v", err)
+	}
+}
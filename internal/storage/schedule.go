@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// scheduleColumns are the feed schedule columns, read with scheduleRow
+const scheduleColumns = `f.ttl, f.update_period, COALESCE(f.skip_hours, ''), COALESCE(f.skip_days, ''),
+	f.post_interval, f.next_due_at`
+
+// scheduleRow scans scheduleColumns
+type scheduleRow struct {
+	ttl, updatePeriod, postInterval int64
+	skipHours, skipDays             string
+	nextDue                         sql.NullTime
+}
+
+func (r *scheduleRow) dest() []any {
+	return []any{&r.ttl, &r.updatePeriod, &r.skipHours, &r.skipDays, &r.postInterval, &r.nextDue}
+}
+
+func (r *scheduleRow) schedule() (models.FeedSchedule, error) {
+	s := models.FeedSchedule{
+		TTL:          time.Duration(r.ttl) * time.Second,
+		UpdatePeriod: time.Duration(r.updatePeriod) * time.Second,
+		PostInterval: time.Duration(r.postInterval) * time.Second,
+		NextDueAt:    r.nextDue.Time,
+	}
+	var err error
+	if s.SkipHours, err = splitIDs(r.skipHours); err != nil {
+		return s, err
+	}
+	days, err := splitIDs(r.skipDays)
+	for _, d := range days {
+		s.SkipDays = append(s.SkipDays, time.Weekday(d))
+	}
+	return s, err
+}
+
+// joinInts stores a list of small numbers the way splitIDs reads them
+func joinInts[T ~int](values []T) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(parts, ",")
+}
+
+// SetFeedSchedule stores when a feed is next due and the hints that decided it
+func (d *DB) SetFeedSchedule(ctx context.Context, feedID int, s models.FeedSchedule) error {
+	query := `
+		UPDATE feeds
+		SET ttl = ?, update_period = ?, skip_hours = NULLIF(?, ''), skip_days = NULLIF(?, ''),
+			post_interval = ?, next_due_at = ?
+		WHERE id = ?
+	`
+
+	_, err := d.conn.ExecContext(ctx, query, int64(s.TTL.Seconds()), int64(s.UpdatePeriod.Seconds()),
+		joinInts(s.SkipHours), joinInts(s.SkipDays), int64(s.PostInterval.Seconds()), nullTime(s.NextDueAt), feedID)
+	if err != nil {
+		return fmt.Errorf("failed to set schedule of feed %d: %w", feedID, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+func TestFeedSchedule(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	feed := addTestFeed(t, db, "https://example.com/feed.xml", "Feed")
+	if !reflect.DeepEqual(feed.Schedule, models.FeedSchedule{}) {
+		t.Errorf("new feed schedule = %+v, want nothing set", feed.Schedule)
+	}
+
+	want := models.FeedSchedule{
+		TTL:          90 * time.Minute,
+		UpdatePeriod: 12 * time.Hour,
+		SkipHours:    []int{0, 1, 23},
+		SkipDays:     []time.Weekday{time.Sunday, time.Saturday},
+		PostInterval: 4 * time.Hour,
+		NextDueAt:    time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if err := db.SetFeedSchedule(ctx, feed.ID, want); err != nil {
+		t.Fatalf("SetFeedSchedule() error = %v", err)
+	}
+	got := reloadFeed(t, db, feed.ID).Schedule
+	if !got.NextDueAt.Equal(want.NextDueAt) {
+		t.Errorf("next due = %v, want %v", got.NextDueAt, want.NextDueAt)
+	}
+	got.NextDueAt = want.NextDueAt
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("schedule = %+v, want %+v", got, want)
+	}
+
+	// Clearing the hints leaves the feed due right away
+	if err := db.SetFeedSchedule(ctx, feed.ID, models.FeedSchedule{}); err != nil {
+		t.Fatalf("SetFeedSchedule() error = %v", err)
+	}
+	if got := reloadFeed(t, db, feed.ID).Schedule; !reflect.DeepEqual(got, models.FeedSchedule{}) {
+		t.Errorf("cleared schedule = %+v, want nothing set", got)
+	}
+}
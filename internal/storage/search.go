@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/html"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// ErrSearchUnavailable is returned by Search when SQLite was built without
+// FTS5. Build with -tags sqlite_fts5 to get it.
+var ErrSearchUnavailable = errors.New("full-text search needs warss built with -tags sqlite_fts5")
+
+// Snippets and titles returned by Search wrap each match in these, for the
+// caller to turn into whatever highlighting suits it
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+// searchTriggers keep posts_fts in step with posts. html_text is registered
+// on every connection by the driver, see init in db.go.
+var searchTriggers = map[string]string{
+	"posts_fts_insert": `
+	CREATE TRIGGER posts_fts_insert AFTER INSERT ON posts BEGIN
+		INSERT INTO posts_fts (rowid, title, body) VALUES (new.id, new.title, html_text(new.content));
+	END`,
+	"posts_fts_delete": `
+	CREATE TRIGGER posts_fts_delete AFTER DELETE ON posts BEGIN
+		DELETE FROM posts_fts WHERE rowid = old.id;
+	END`,
+	"posts_fts_update": `
+	CREATE TRIGGER posts_fts_update AFTER UPDATE OF title, content ON posts BEGIN
+		DELETE FROM posts_fts WHERE rowid = old.id;
+		INSERT INTO posts_fts (rowid, title, body) VALUES (new.id, new.title, html_text(new.content));
+	END`,
+}
+
+// ensureSearchIndex sets up the full-text index when this SQLite has FTS5 and
+// reports whether it did. It isn't a migration because it depends on how the
+// binary was built rather than on the schema version: a build without FTS5
+// drops the triggers (they'd fail every insert), and the next build with it
+// finds them missing and rebuilds the index from scratch.
+func ensureSearchIndex(ctx context.Context, db *sql.DB) (bool, error) {
+	var fts5 bool
+	if err := db.QueryRowContext(ctx, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		return false, fmt.Errorf("error checking for FTS5: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error starting search index setup: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if !fts5 {
+		for name := range searchTriggers {
+			if _, err := tx.ExecContext(ctx, `DROP TRIGGER IF EXISTS `+name); err != nil {
+				return false, fmt.Errorf("error dropping search trigger %s: %w", name, err)
+			}
+		}
+		return false, tx.Commit()
+	}
+
+	var present int
+	query := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ('posts_fts_insert', 'posts_fts_delete', 'posts_fts_update')`
+	if err := tx.QueryRowContext(ctx, query).Scan(&present); err != nil {
+		return false, fmt.Errorf("error looking for search triggers: %w", err)
+	}
+	if present == len(searchTriggers) {
+		return true, nil
+	}
+
+	query = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+		title,
+		body,
+		tokenize = 'unicode61 remove_diacritics 2'
+	);
+	DELETE FROM posts_fts;
+	INSERT INTO posts_fts (rowid, title, body) SELECT id, title, html_text(content) FROM posts;
+	`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return false, fmt.Errorf("error building search index: %w", err)
+	}
+	for name, trigger := range searchTriggers {
+		if _, err := tx.ExecContext(ctx, `DROP TRIGGER IF EXISTS `+name); err != nil {
+			return false, fmt.Errorf("error dropping search trigger %s: %w", name, err)
+		}
+		if _, err := tx.ExecContext(ctx, trigger); err != nil {
+			return false, fmt.Errorf("error creating search trigger %s: %w", name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing search index: %w", err)
+	}
+	return true, nil
+}
+
+// htmlText is the text of an HTML fragment without markup, what gets indexed
+// for a post's content. Script and style contents are dropped.
+func htmlText(src string) string {
+	var (
+		b    strings.Builder
+		skip int
+	)
+	z := html.NewTokenizer(strings.NewReader(src))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(b.String()), " ")
+		case html.StartTagToken:
+			if name, _ := z.TagName(); string(name) == "script" || string(name) == "style" {
+				skip++
+			}
+			// Tags separate words: "<p>a</p><p>b</p>" isn't "ab"
+			b.WriteByte(' ')
+		case html.EndTagToken:
+			if name, _ := z.TagName(); (string(name) == "script" || string(name) == "style") && skip > 0 {
+				skip--
+			}
+			b.WriteByte(' ')
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(z.Text())
+			}
+		}
+	}
+}
+
+// ReadFilter narrows posts down by whether they've been read
+type ReadFilter int
+
+const (
+	AnyPosts ReadFilter = iota
+	UnreadPosts
+	ReadPosts
+)
+
+// SearchFilters narrow down Search. The zero value searches everything.
+type SearchFilters struct {
+	// FeedIDs limits results to these feeds when not empty
+	FeedIDs []int
+	Read    ReadFilter
+	// Since and Until bound published_at, each ignored when zero. They can be
+	// in any time zone.
+	Since time.Time
+	Until time.Time
+	// Limit defaults to 50, Offset skips that many results for paging
+	Limit  int
+	Offset int
+}
+
+// SearchResult is a post matching a search, best matches first
+type SearchResult struct {
+	Post      models.Post
+	FeedTitle string
+	// Title is the post title with matches highlighted
+	Title string
+	// Snippet is the part of the content around the best match, highlighted
+	Snippet string
+	// Rank is FTS5's bm25 score, lower is better
+	Rank float64
+}
+
+// Search looks for query in the title and content of every stored post. Words
+// must all appear, "quoted words" match as a phrase and a trailing * matches
+// any word starting with what comes before it, like news*. OR and NOT work
+// between terms as in FTS5.
+func (d *DB) Search(ctx context.Context, query string, f SearchFilters) ([]SearchResult, error) {
+	if !d.search {
+		return nil, ErrSearchUnavailable
+	}
+	match, err := ftsQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Matches in the title count for more than matches in the body
+	sqlQuery := `
+		SELECT ` + postColumns + `,
+			COALESCE((SELECT title FROM feeds WHERE feeds.id = posts.feed_id), ''),
+			s.title_hl, s.snippet, s.rank
+		FROM (
+			SELECT rowid AS post_id,
+				bm25(posts_fts, 5.0, 1.0) AS rank,
+				highlight(posts_fts, 0, ?, ?) AS title_hl,
+				snippet(posts_fts, 1, ?, ?, '…', 24) AS snippet
+			FROM posts_fts
+			WHERE posts_fts MATCH ?
+		) s
+		JOIN posts ON posts.id = s.post_id
+		WHERE 1 = 1`
+	args := []any{HighlightStart, HighlightEnd, HighlightStart, HighlightEnd, match}
+
+	if len(f.FeedIDs) > 0 {
+		sqlQuery += ` AND posts.feed_id IN (?` + strings.Repeat(", ?", len(f.FeedIDs)-1) + `)`
+		for _, id := range f.FeedIDs {
+			args = append(args, id)
+		}
+	}
+	switch f.Read {
+	case UnreadPosts:
+		sqlQuery += ` AND posts.read = 0`
+	case ReadPosts:
+		sqlQuery += ` AND posts.read = 1`
+	}
+	if !f.Since.IsZero() {
+		sqlQuery += ` AND posts.published_at >= ?`
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		sqlQuery += ` AND posts.published_at < ?`
+		args = append(args, f.Until.UTC())
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	sqlQuery += ` ORDER BY s.rank, posts.published_at DESC, posts.id DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, max(f.Offset, 0))
+
+	rows, err := d.conn.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for %q: %w", query, err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("error closing rows: %v\n", err)
+		}
+	}()
+
+	var results []SearchResult
+	for rows.Next() {
+		var (
+			r   SearchResult
+			err error
+		)
+		r.Post, err = scanPost(rows, &r.FeedTitle, &r.Title, &r.Snippet, &r.Rank)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating search results: %w", err)
+	}
+	return results, nil
+}
+
+// ftsQuery turns what the user typed into an FTS5 query. Every term is quoted
+// so punctuation like "c++" or "e-mail" can't break the query syntax, while
+// phrases, prefix stars and the OR / NOT operators are kept.
+func ftsQuery(q string) (string, error) {
+	var terms []string
+	rest := strings.TrimSpace(q)
+	for rest != "" {
+		var term string
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				// An unclosed quote runs to the end
+				end = len(rest) - 1
+				rest += `"`
+			}
+			term, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			term, rest = rest[:end], rest[end:]
+			if term == "OR" || term == "NOT" {
+				terms = append(terms, term)
+				rest = strings.TrimSpace(rest)
+				continue
+			}
+		}
+
+		prefix := false
+		if strings.HasPrefix(rest, "*") {
+			prefix, rest = true, rest[1:]
+		} else if strings.HasSuffix(term, "*") {
+			prefix, term = true, strings.TrimRight(term, "*")
+		}
+		rest = strings.TrimSpace(rest)
+		if strings.TrimSpace(term) == "" {
+			continue
+		}
+
+		term = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+
+	if len(terms) == 0 {
+		return "", errors.New("empty search query")
+	}
+	// Operators need a term on both sides
+	if t := terms[0]; t == "OR" || t == "NOT" {
+		return "", fmt.Errorf("search query %q starts with %s", q, t)
+	}
+	if t := terms[len(terms)-1]; t == "OR" || t == "NOT" {
+		return "", fmt.Errorf("search query %q ends with %s", q, t)
+	}
+	return strings.Join(terms, " "), nil
+}
@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{query: "golang", want: `"golang"`},
+		{query: "  sqlite   search ", want: `"sqlite" "search"`},
+		{query: `"full text" search`, want: `"full text" "search"`},
+		{query: "news*", want: `"news"*`},
+		{query: `"full te"*`, want: `"full te"*`},
+		{query: "c++ e-mail", want: `"c++" "e-mail"`},
+		{query: "go OR rust NOT java", want: `"go" OR "rust" NOT "java"`},
+		{query: `"unclosed phrase`, want: `"unclosed phrase"`},
+		{query: "", wantErr: true},
+		{query: "*", wantErr: true},
+		{query: "OR go", wantErr: true},
+		{query: "go NOT", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := ftsQuery(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ftsQuery(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ftsQuery(%q) = %s, want %s", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLText(t *testing.T) {
+	src := `<p>First <b>bold</b> para</p><p>Second</p><script>var x = "hidden";</script><style>p{}</style>&amp; done`
+	if got, want := htmlText(src), "First bold para Second & done"; got != want {
+		t.Errorf("htmlText() = %q, want %q", got, want)
+	}
+}
+
+// setupSearchDB is setupTestDB for tests that need FTS5
+func setupSearchDB(t *testing.T) *DB {
+	t.Helper()
+	db := setupTestDB(t)
+	if !db.search {
+		t.Skip("SQLite built without FTS5, run with -tags sqlite_fts5")
+	}
+	return db
+}
+
+func searchTitles(t *testing.T, db *DB, query string, f SearchFilters) []string {
+	t.Helper()
+	results, err := db.Search(context.Background(), query, f)
+	if err != nil {
+		t.Fatalf("Search(%q) error = %v", query, err)
+	}
+	titles := []string{}
+	for _, r := range results {
+		titles = append(titles, r.Post.Title)
+	}
+	return titles
+}
+
+func TestSearch(t *testing.T) {
+	db := setupSearchDB(t)
+	ctx := context.Background()
+	a := addTestFeed(t, db, "https://a.example.com/feed.xml", "A")
+	b := addTestFeed(t, db, "https://b.example.com/feed.xml", "B")
+
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	a.Posts = []models.Post{
+		{GUID: "1", Title: "SQLite tips", Content: "<p>Use <em>full text</em> search for speed</p>", PublishedAt: day},
+		{GUID: "2", Title: "Weekly links", Content: "<p>Some notes about sqlite and newsletters</p>", PublishedAt: day.AddDate(0, 0, 1)},
+		{GUID: "3", Title: "Café", Content: "<p>Text search across the whole site</p>", PublishedAt: day.AddDate(0, 0, 2)},
+	}
+	b.Posts = []models.Post{
+		{GUID: "1", Title: "Gardening", Content: "<p>News from the allotment, full of text</p>", PublishedAt: day},
+	}
+	for _, f := range []models.Feed{a, b} {
+		if _, err := db.SaveFeed(ctx, f); err != nil {
+			t.Fatalf("SaveFeed() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		filters SearchFilters
+		want    []string
+		// ranked checks the order too, otherwise only which posts matched
+		ranked bool
+	}{
+		{name: "title beats body", query: "sqlite", want: []string{"SQLite tips", "Weekly links"}, ranked: true},
+		{name: "phrase", query: `"full text"`, want: []string{"SQLite tips"}},
+		{name: "words anywhere", query: "full text", want: []string{"Gardening", "SQLite tips"}},
+		{name: "prefix", query: "news*", want: []string{"Gardening", "Weekly links"}},
+		{name: "markup isn't indexed", query: "em", want: []string{}},
+		{name: "diacritics", query: "cafe", want: []string{"Café"}},
+		{name: "feed filter", query: "text", filters: SearchFilters{FeedIDs: []int{b.ID}}, want: []string{"Gardening"}},
+		{name: "date range", query: "text", filters: SearchFilters{Since: day.AddDate(0, 0, 1)}, want: []string{"Café"}},
+		{name: "until", query: "search", filters: SearchFilters{Until: day.AddDate(0, 0, 1)}, want: []string{"SQLite tips"}},
+		{
+			name:  "date range in another zone",
+			query: "text",
+			filters: SearchFilters{
+				Since: day.Add(-time.Hour).In(time.FixedZone("UTC+9", 9*60*60)),
+				Until: day.Add(time.Hour).In(time.FixedZone("UTC-5", -5*60*60)),
+			},
+			want: []string{"Gardening", "SQLite tips"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := searchTitles(t, db, tt.query, tt.filters)
+			if !tt.ranked {
+				slices.Sort(got)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("Search(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+
+	first := searchTitles(t, db, "text", SearchFilters{Limit: 1})
+	second := searchTitles(t, db, "text", SearchFilters{Limit: 1, Offset: 1})
+	if len(first) != 1 || len(second) != 1 || first[0] == second[0] {
+		t.Errorf("paging through text = %q then %q, want one different post each", first, second)
+	}
+
+	results, err := db.Search(ctx, "speed", SearchFilters{})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Search(speed) = %d results, %v", len(results), err)
+	}
+	if want := "search for " + HighlightStart + "speed" + HighlightEnd; !strings.Contains(results[0].Snippet, want) {
+		t.Errorf("snippet = %q, want it to contain %q", results[0].Snippet, want)
+	}
+	if results[0].FeedTitle != "A" {
+		t.Errorf("feed title = %q, want A", results[0].FeedTitle)
+	}
+
+	// Read filters follow the post, the index follows edits and deletes
+	if err := db.MarkRead(ctx, results[0].Post.ID); err != nil {
+		t.Fatalf("MarkRead() error = %v", err)
+	}
+	if got := searchTitles(t, db, "full text", SearchFilters{Read: UnreadPosts}); len(got) != 1 || got[0] != "Gardening" {
+		t.Errorf("unread search = %q, want Gardening", got)
+	}
+	if got := searchTitles(t, db, "full text", SearchFilters{Read: ReadPosts}); len(got) != 1 || got[0] != "SQLite tips" {
+		t.Errorf("read search = %q, want SQLite tips", got)
+	}
+
+	b.Posts[0].Content = "<p>Compost</p>"
+	b.Posts[0].UpdatedAt = day.Add(time.Hour)
+	if _, err := db.SaveFeed(ctx, b); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	if got := searchTitles(t, db, "compost", SearchFilters{}); len(got) != 1 {
+		t.Errorf("edited post not found by its new content: %q", got)
+	}
+	if err := db.DeleteFeed(b.ID); err != nil {
+		t.Fatalf("DeleteFeed() error = %v", err)
+	}
+	if got := searchTitles(t, db, "compost", SearchFilters{}); len(got) != 0 {
+		t.Errorf("deleted post still found: %q", got)
+	}
+}
+
+// TestSearchIndexRebuild checks a database whose index fell behind, like one
+// written by a build without FTS5, is reindexed when opened
+func TestSearchIndexRebuild(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rss.db")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	if !db.search {
+		_ = db.Close()
+		t.Skip("SQLite built without FTS5, run with -tags sqlite_fts5")
+	}
+	ctx := context.Background()
+	feed := addTestFeed(t, db, "https://example.com/feed.xml", "Feed")
+	for name := range searchTriggers {
+		if _, err := db.conn.Exec(`DROP TRIGGER ` + name); err != nil {
+			t.Fatalf("failed to drop %s: %v", name, err)
+		}
+	}
+	feed.Posts = []models.Post{{GUID: "1", Title: "Written without an index", Content: "unindexed"}}
+	if _, err := db.SaveFeed(ctx, feed); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	_ = db.Close()
+
+	db, err = NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() reopening error = %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	if got := searchTitles(t, db, "unindexed", SearchFilters{}); len(got) != 1 {
+		t.Errorf("Search() after reopening = %q, want the post written without an index", got)
+	}
+}
+
+func TestSearchUnavailable(t *testing.T) {
+	db := setupTestDB(t)
+	db.search = false
+	if _, err := db.Search(context.Background(), "anything", SearchFilters{}); !errors.Is(err, ErrSearchUnavailable) {
+		t.Errorf("Search() without FTS5 error = %v, want ErrSearchUnavailable", err)
+	}
+}
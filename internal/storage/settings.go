@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// getIntSetting reads an integer from the settings table, def when it was never set
+func getIntSetting(ctx context.Context, q querier, key string, def int) (int, error) {
+	var value string
+	err := q.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return def, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read setting %s: %w", key, err)
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("setting %s = %q is not a number: %w", key, value, err)
+	}
+	return n, nil
+}
+
+func setIntSetting(ctx context.Context, q querier, key string, value int) error {
+	query := `
+		INSERT INTO settings (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`
+	if _, err := q.ExecContext(ctx, query, key, strconv.Itoa(value)); err != nil {
+		return fmt.Errorf("failed to save setting %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pixel-87/warss/internal/models"
+)
+
+// Cursor marks where a page of posts ended. Pass it back to get the page
+// after it; the empty Cursor starts from the beginning.
+type Cursor string
+
+// newCursor points just past the post with this sort time and id. The time
+// keeps its offset so it binds to the same text SQLite stored, and so
+// compares equal to it.
+func newCursor(at time.Time, id int) Cursor {
+	return Cursor(at.Format(time.RFC3339Nano) + "-" + strconv.Itoa(id))
+}
+
+func (c Cursor) decode() (time.Time, int, error) {
+	i := strings.LastIndexByte(string(c), '-')
+	if i < 0 {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor %q", c)
+	}
+	at, err := time.Parse(time.RFC3339Nano, string(c[:i]))
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor %q", c)
+	}
+	id, err := strconv.Atoi(string(c[i+1:]))
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor %q", c)
+	}
+	return at, id, nil
+}
+
+// Star saves a post to keep. Starring it again keeps the first time.
+func (d *DB) Star(ctx context.Context, postID int) error {
+	query := `UPDATE posts SET starred_at = COALESCE(starred_at, ?) WHERE id = ?`
+	res, err := d.conn.ExecContext(ctx, query, time.Now().UTC(), postID)
+	if err != nil {
+		return fmt.Errorf("failed to star post %d: %w", postID, err)
+	}
+	return postUpdated(res, postID)
+}
+
+// Unstar removes a post's star, leaving it to the retention policy again
+func (d *DB) Unstar(ctx context.Context, postID int) error {
+	query := `UPDATE posts SET starred_at = NULL WHERE id = ?`
+	res, err := d.conn.ExecContext(ctx, query, postID)
+	if err != nil {
+		return fmt.Errorf("failed to unstar post %d: %w", postID, err)
+	}
+	return postUpdated(res, postID)
+}
+
+// StarredPosts returns up to limit starred posts from every feed, most
+// recently starred first, starting after the cursor. The returned cursor
+// fetches the next page and is empty once there are no more.
+func (d *DB) StarredPosts(ctx context.Context, limit int, after Cursor) ([]models.Post, Cursor, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts
+		WHERE starred_at IS NOT NULL`
+	var args []any
+	if after != "" {
+		at, id, err := after.decode()
+		if err != nil {
+			return nil, "", err
+		}
+		query += ` AND (starred_at < ? OR (starred_at = ? AND id < ?))`
+		args = append(args, at, at, id)
+	}
+	// One extra row tells whether there's another page
+	query += ` ORDER BY starred_at DESC, id DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := d.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get starred posts: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("error closing rows: %v\n", err)
+		}
+	}()
+
+	var posts []models.Post
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("error iterating starred posts: %w", err)
+	}
+
+	if len(posts) <= limit {
+		return posts, "", nil
+	}
+	posts = posts[:limit]
+	last := posts[limit-1]
+	return posts, newCursor(last.StarredAt, last.ID), nil
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStarredPosts(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	a := addTestFeed(t, db, "https://a.example.com/feed.xml", "A")
+	b := addTestFeed(t, db, "https://b.example.com/feed.xml", "B")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	idsA := seedPosts(t, db, a, 3, start)
+	idsB := seedPosts(t, db, b, 2, start)
+
+	// Starred in this order, so listed in the reverse
+	starred := []int{idsA[0], idsB[1], idsA[2]}
+	for _, id := range starred {
+		if err := db.Star(ctx, id); err != nil {
+			t.Fatalf("Star(%d) error = %v", id, err)
+		}
+	}
+
+	var got []int
+	var cursor Cursor
+	for page := 0; ; page++ {
+		posts, next, err := db.StarredPosts(ctx, 2, cursor)
+		if err != nil {
+			t.Fatalf("StarredPosts() page %d error = %v", page, err)
+		}
+		for _, p := range posts {
+			if !p.Starred() {
+				t.Errorf("post %d listed as starred without a starred time", p.ID)
+			}
+			got = append(got, p.ID)
+		}
+		if next == "" {
+			break
+		}
+		if page > 2 {
+			t.Fatal("StarredPosts() never ran out of pages")
+		}
+		cursor = next
+	}
+	want := []int{starred[2], starred[1], starred[0]}
+	if len(got) != len(want) {
+		t.Fatalf("starred posts = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("starred posts = %v, want %v", got, want)
+			break
+		}
+	}
+
+	// Starring again keeps its place, unstarring drops it
+	before, err := db.GetPost(ctx, starred[0])
+	if err != nil {
+		t.Fatalf("GetPost() error = %v", err)
+	}
+	if err := db.Star(ctx, starred[0]); err != nil {
+		t.Fatalf("Star() error = %v", err)
+	}
+	after, err := db.GetPost(ctx, starred[0])
+	if err != nil {
+		t.Fatalf("GetPost() error = %v", err)
+	}
+	if !after.StarredAt.Equal(before.StarredAt) {
+		t.Errorf("starring again moved starred_at from %v to %v", before.StarredAt, after.StarredAt)
+	}
+	if err := db.Unstar(ctx, starred[1]); err != nil {
+		t.Fatalf("Unstar() error = %v", err)
+	}
+	posts, _, err := db.StarredPosts(ctx, 10, "")
+	if err != nil || len(posts) != 2 {
+		t.Errorf("after Unstar() got %d starred posts, %v, want 2", len(posts), err)
+	}
+
+	if _, _, err := db.StarredPosts(ctx, 10, "nonsense"); err == nil {
+		t.Error("StarredPosts() accepted a bad cursor")
+	}
+
+	if err := db.Star(ctx, 999); !errors.Is(err, ErrNoPost) {
+		t.Errorf("Star() on an unknown post error = %v, want ErrNoPost", err)
+	}
+	if err := db.Unstar(ctx, 999); !errors.Is(err, ErrNoPost) {
+		t.Errorf("Unstar() on an unknown post error = %v, want ErrNoPost", err)
+	}
+}
+
+func TestPruneKeepsStarred(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	feed := postsDaysAgo(addTestFeed(t, db, "https://example.com/feed.xml", "Feed"), 4)
+	if _, err := db.SaveFeed(ctx, feed); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+	posts, _, err := db.ListPosts(ctx, PostQuery{FeedIDs: []int{feed.ID}})
+	if err != nil {
+		t.Fatalf("ListPosts() error = %v", err)
+	}
+	// The oldest would go first under any rule
+	oldest := posts[len(posts)-1]
+	if err := db.Star(ctx, oldest.ID); err != nil {
+		t.Fatalf("Star() error = %v", err)
+	}
+
+	if err := db.SetRetentionPolicy(ctx, RetentionPolicy{MaxAgeDays: 1, MaxPosts: 1}); err != nil {
+		t.Fatalf("SetRetentionPolicy() error = %v", err)
+	}
+	results, err := db.Prune(ctx, false)
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	// The newest post is under a day old and fills the one place
+	if len(results) != 1 || results[0].Posts != 2 {
+		t.Errorf("Prune() = %+v, want 2 posts pruned", results)
+	}
+	if _, err := db.GetPost(ctx, oldest.ID); err != nil {
+		t.Errorf("starred post was pruned: %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+-- rss.db as written by warss before schema versioning (user_version 0)
+CREATE TABLE feeds (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url TEXT UNIQUE NOT NULL,
+	title TEXT
+);
+
+CREATE TABLE posts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	feed_id INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	link TEXT UNIQUE NOT NULL,
+	content TEXT,
+	published_at DATETIME,
+	updated_at DATETIME,
+	read BOOLEAN DEFAULT 0,
+	FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_post_feed_id ON posts(feed_id);
+CREATE INDEX idx_post_feed_published ON posts(feed_id, published_at DESC);
+
+INSERT INTO feeds (url, title) VALUES
+	('https://ed-thomas.dev/rss.xml', 'Ed''s Blog'),
+	('https://example.com/feed.xml', 'Example');
+
+INSERT INTO posts (feed_id, title, link, content, published_at, updated_at, read) VALUES
+	(1, 'Hello', 'https://ed-thomas.dev/hello', '<p>Hi</p>', '2024-01-02 03:04:05+00:00', '2024-01-02 03:04:05+00:00', 1),
+	(1, 'Second', 'https://ed-thomas.dev/second', '<p>Again</p>', '2024-02-02 03:04:05+00:00', '2024-02-02 03:04:05+00:00', 0),
+	(2, 'Example post', 'https://example.com/1', 'Body', '2024-03-02 03:04:05+00:00', '2024-03-02 03:04:05+00:00', 0);
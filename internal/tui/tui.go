@@ -0,0 +1,703 @@
+// Package tui is the full-screen terminal interface: a feed list, the posts of
+// the selected feed or category and a reader for the selected post.
+package tui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/pixel-87/warss/internal/models"
+	"github.com/pixel-87/warss/internal/rss"
+	"github.com/pixel-87/warss/internal/storage"
+)
+
+type pane int
+
+const (
+	feedsPane pane = iota
+	postsPane
+	readerPane
+)
+
+type model struct {
+	ctx     context.Context
+	db      *storage.DB
+	fetcher *rss.Fetcher
+
+	feeds      []models.Feed
+	categories []models.Category
+	// rows is the feed list as shown, categories with their feeds below
+	rows      []treeRow
+	rowIdx    int
+	collapsed map[int]bool
+	posts     []models.Post
+	postIdx   int
+	// next continues the selected row's posts past the ones loaded, empty
+	// once they all are
+	next   storage.Cursor
+	reader viewport.Model
+	// post shown in the reader, -1 when empty
+	readingID int
+
+	// search prompt, which takes the keyboard while prompting
+	prompt    textinput.Model
+	prompting bool
+	// query whose results fill the posts pane, empty when it shows the selected feed
+	query string
+
+	focus      pane
+	width      int
+	height     int
+	status     string
+	refreshing bool
+}
+
+// listLimit is how many posts a feed or a category loads at a time, and caps
+// how many a search puts in the posts pane
+const listLimit = 200
+
+// Run starts the interface and blocks until the user quits or ctx is cancelled
+func Run(ctx context.Context, db *storage.DB) error {
+	// Quitting cancels whatever a command still has in flight, like a refresh
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	p := tea.NewProgram(newModel(ctx, db), tea.WithAltScreen(), tea.WithContext(ctx))
+	_, err := p.Run()
+	if err != nil && ctx.Err() != nil {
+		// Ctrl-C from outside is a normal way to leave
+		return nil
+	}
+	return err
+}
+
+func newModel(ctx context.Context, db *storage.DB) model {
+	prompt := textinput.New()
+	prompt.Prompt = "/"
+	prompt.Placeholder = `words, "a phrase" or prefix*`
+	// nothing forwards blink ticks to the prompt
+	prompt.Cursor.SetMode(cursor.CursorStatic)
+	return model{
+		prompt:    prompt,
+		ctx:       ctx,
+		db:        db,
+		fetcher:   rss.NewFetcher(db),
+		reader:    viewport.New(0, 0),
+		readingID: -1,
+		collapsed: make(map[int]bool),
+		status:    "loading feeds…",
+	}
+}
+
+// Messages sent back by commands
+
+type feedsLoadedMsg struct {
+	feeds      []models.Feed
+	categories []models.Category
+	err        error
+}
+
+type postsLoadedMsg struct {
+	// one of the two is set, depending on the row the posts are for
+	categoryID int
+	feedID     int
+	// after is where a later page continued from, empty for the first
+	after storage.Cursor
+	next  storage.Cursor
+	posts []models.Post
+	err   error
+}
+
+type searchDoneMsg struct {
+	query string
+	posts []models.Post
+	err   error
+}
+
+type refreshDoneMsg struct {
+	updated, unchanged, failed int
+	skipped, pruned            int
+	err                        error
+}
+
+type statusMsg string
+
+func (m model) Init() tea.Cmd {
+	return m.loadFeeds()
+}
+
+func (m model) loadFeeds() tea.Cmd {
+	return func() tea.Msg {
+		feeds, err := m.db.GetFeedsContext(m.ctx)
+		if err != nil {
+			return feedsLoadedMsg{err: err}
+		}
+		categories, err := m.db.GetCategories(m.ctx)
+		return feedsLoadedMsg{feeds: feeds, categories: categories, err: err}
+	}
+}
+
+// loadPosts loads a page of the posts of a row, a feed or a whole category,
+// continuing from after unless it's empty
+func (m model) loadPosts(row treeRow, after storage.Cursor, limit int) tea.Cmd {
+	q := storage.PostQuery{Limit: limit, After: after}
+	msg := postsLoadedMsg{after: after}
+	if row.feedIdx < 0 {
+		q.CategoryID, msg.categoryID = row.categoryID, row.categoryID
+	} else {
+		q.FeedIDs, msg.feedID = []int{row.feedID}, row.feedID
+	}
+	return func() tea.Msg {
+		msg.posts, msg.next, msg.err = m.db.ListPosts(m.ctx, q)
+		return msg
+	}
+}
+
+// loadSelected loads the posts of the selected row from the top, as many as
+// are already shown so a reload doesn't take away the ones scrolled to
+func (m model) loadSelected() tea.Cmd {
+	row, ok := m.selectedRow()
+	if !ok {
+		return nil
+	}
+	return m.loadPosts(row, "", max(listLimit, len(m.posts)))
+}
+
+// loadMore loads the next page of the selected row's posts, if there is one
+func (m model) loadMore() tea.Cmd {
+	row, ok := m.selectedRow()
+	if !ok || m.next == "" || m.query != "" {
+		return nil
+	}
+	return m.loadPosts(row, m.next, listLimit)
+}
+
+func (m model) search(query string) tea.Cmd {
+	return func() tea.Msg {
+		results, err := m.db.Search(m.ctx, query, storage.SearchFilters{Limit: listLimit})
+		msg := searchDoneMsg{query: query, err: err}
+		for _, r := range results {
+			msg.posts = append(msg.posts, r.Post)
+		}
+		return msg
+	}
+}
+
+func (m model) refresh() tea.Cmd {
+	feeds := m.feeds
+	return func() tea.Msg {
+		var done refreshDoneMsg
+		for res := range m.fetcher.RefreshAll(m.ctx, feeds) {
+			switch res.Status {
+			case rss.StatusUpdated:
+				done.updated++
+			case rss.StatusUnchanged:
+				done.unchanged++
+			case rss.StatusSkipped:
+				done.skipped++
+			default:
+				done.failed++
+			}
+		}
+		// Old posts go after every refresh, as they do for warss refresh
+		pruned, err := m.db.Prune(m.ctx, false)
+		for _, r := range pruned {
+			done.pruned += r.Posts
+		}
+		done.err = err
+		return done
+	}
+}
+
+func (m model) setRead(postID int, read bool) tea.Cmd {
+	return func() tea.Msg {
+		var err error
+		if read {
+			err = m.db.MarkRead(m.ctx, postID)
+		} else {
+			err = m.db.MarkUnread(m.ctx, postID)
+		}
+		if err != nil {
+			return statusMsg(err.Error())
+		}
+		return nil
+	}
+}
+
+func (m model) setStarred(postID int, starred bool) tea.Cmd {
+	return func() tea.Msg {
+		var err error
+		if starred {
+			err = m.db.Star(m.ctx, postID)
+		} else {
+			err = m.db.Unstar(m.ctx, postID)
+		}
+		if err != nil {
+			return statusMsg(err.Error())
+		}
+		return nil
+	}
+}
+
+func (m model) markFeedRead(feedID int) tea.Cmd {
+	return func() tea.Msg {
+		n, err := m.db.MarkFeedRead(m.ctx, feedID, time.Now())
+		if err != nil {
+			return statusMsg(err.Error())
+		}
+		return statusMsg(fmt.Sprintf("marked %d posts read", n))
+	}
+}
+
+// openBrowser opens url in $BROWSER, falling back to the platform's opener
+func openBrowser(url string) tea.Cmd {
+	return func() tea.Msg {
+		browser := os.Getenv("BROWSER")
+		if browser == "" {
+			switch runtime.GOOS {
+			case "darwin":
+				browser = "open"
+			case "windows":
+				browser = "explorer"
+			default:
+				browser = "xdg-open"
+			}
+		}
+		cmd := exec.Command(browser, url)
+		if err := cmd.Start(); err != nil {
+			return statusMsg(fmt.Sprintf("couldn't open browser: %v", err))
+		}
+		// Reap it in the background, we don't care how it exits
+		go func() { _ = cmd.Wait() }()
+		return statusMsg("opened " + url)
+	}
+}
+
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.resizeReader()
+		return m, nil
+
+	case feedsLoadedMsg:
+		if msg.err != nil {
+			m.status = msg.err.Error()
+			return m, nil
+		}
+		// Keep the selection on the same category or feed as the list changes
+		prev, _ := m.selectedRow()
+		m.feeds = msg.feeds
+		m.categories = msg.categories
+		m.buildRows()
+		for i, r := range m.rows {
+			if r.categoryID == prev.categoryID && r.feedID == prev.feedID {
+				m.rowIdx = i
+				break
+			}
+		}
+		m.status = fmt.Sprintf("%d feeds", len(m.feeds))
+		return m, m.loadSelected()
+
+	case postsLoadedMsg:
+		if msg.err != nil {
+			m.status = msg.err.Error()
+			return m, nil
+		}
+		// Ignore answers for a row we already moved away from, or that
+		// would replace search results
+		row, ok := m.selectedRow()
+		if !ok || m.query != "" {
+			return m, nil
+		}
+		if row.feedIdx < 0 && row.categoryID != msg.categoryID || row.feedIdx >= 0 && row.feedID != msg.feedID {
+			return m, nil
+		}
+		switch {
+		case msg.after == "":
+			m.posts = msg.posts
+		case msg.after == m.next:
+			m.posts = append(m.posts, msg.posts...)
+		default:
+			// a page we already have, asked for again while it loaded
+			return m, nil
+		}
+		m.next = msg.next
+		m.postIdx = clamp(m.postIdx, 0, len(m.posts)-1)
+		return m, nil
+
+	case refreshDoneMsg:
+		m.refreshing = false
+		m.status = fmt.Sprintf("refreshed: %d updated, %d unchanged, %d failed", msg.updated, msg.unchanged, msg.failed)
+		if msg.skipped > 0 {
+			m.status += fmt.Sprintf(", %d not due yet", msg.skipped)
+		}
+		switch {
+		case msg.err != nil:
+			m.status += ", " + msg.err.Error()
+		case msg.pruned > 0:
+			m.status += fmt.Sprintf(", %d old posts pruned", msg.pruned)
+		}
+		return m, m.loadFeeds()
+
+	case searchDoneMsg:
+		if msg.err != nil {
+			m.status = msg.err.Error()
+			return m, nil
+		}
+		m.query = msg.query
+		m.posts = msg.posts
+		m.next = ""
+		m.postIdx = 0
+		m.focus = postsPane
+		m.status = fmt.Sprintf("%d posts match %q, esc to go back", len(m.posts), m.query)
+		return m, nil
+
+	case statusMsg:
+		m.status = string(msg)
+		return m, m.loadFeeds()
+
+	case tea.KeyMsg:
+		return m.handleKey(msg)
+	}
+	return m, nil
+}
+
+func (m model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.prompting {
+		return m.handlePrompt(msg)
+	}
+
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return m, tea.Quit
+
+	case "/":
+		m.prompting = true
+		m.prompt.SetValue(m.query)
+		m.prompt.CursorEnd()
+		return m, m.prompt.Focus()
+
+	case "tab", "l", "right":
+		if m.focus == postsPane {
+			return m.openPost()
+		}
+		if m.focus < readerPane {
+			m.focus++
+		}
+		return m, nil
+
+	case "shift+tab", "h", "left", "esc":
+		// Leaving search results goes back to the selected feed's posts
+		if m.query != "" && m.focus == postsPane {
+			return m.clearSearch()
+		}
+		if m.focus > feedsPane {
+			m.focus--
+		}
+		return m, nil
+
+	case "enter":
+		switch m.focus {
+		case feedsPane:
+			if row, ok := m.selectedRow(); ok && row.feedIdx < 0 {
+				m.collapsed[row.categoryID] = !m.collapsed[row.categoryID]
+				m.buildRows()
+				return m, nil
+			}
+			m.focus = postsPane
+			return m, nil
+		case postsPane:
+			return m.openPost()
+		}
+		return m, nil
+
+	case "j", "down":
+		return m.move(1)
+	case "k", "up":
+		return m.move(-1)
+	case "pgdown", " ":
+		return m.move(m.listHeight())
+	case "pgup":
+		return m.move(-m.listHeight())
+	case "g", "home":
+		return m.move(-1 << 30)
+	case "G", "end":
+		return m.move(1 << 30)
+
+	case "r":
+		if m.refreshing {
+			return m, nil
+		}
+		m.refreshing = true
+		m.status = fmt.Sprintf("refreshing %d feeds…", len(m.feeds))
+		return m, m.refresh()
+
+	case "m":
+		post, ok := m.selectedPost()
+		if !ok || m.focus == feedsPane {
+			return m, nil
+		}
+		m.posts[m.postIdx].Read = !post.Read
+		m.posts[m.postIdx].UpdatedSinceRead = false
+		m.adjustUnread(post.FeedID, post.Read)
+		return m, m.setRead(post.ID, !post.Read)
+
+	case "s":
+		post, ok := m.selectedPost()
+		if !ok || m.focus == feedsPane {
+			return m, nil
+		}
+		if post.Starred() {
+			m.posts[m.postIdx].StarredAt = time.Time{}
+		} else {
+			m.posts[m.postIdx].StarredAt = time.Now()
+		}
+		return m, m.setStarred(post.ID, !post.Starred())
+
+	case "A":
+		feed, ok := m.selectedFeed()
+		if !ok {
+			return m, nil
+		}
+		// Search results aren't all from this feed, they get reloaded instead
+		if m.query == "" {
+			for i := range m.posts {
+				m.posts[i].Read = true
+				m.posts[i].UpdatedSinceRead = false
+			}
+		}
+		return m, m.markFeedRead(feed.ID)
+
+	case "o":
+		post, ok := m.selectedPost()
+		if !ok || m.focus == feedsPane || post.Link == "" {
+			return m, nil
+		}
+		return m, openBrowser(post.Link)
+	}
+	return m, nil
+}
+
+// handlePrompt edits the search prompt, enter runs the search and esc gives up
+func (m model) handlePrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc":
+		m.prompting = false
+		m.prompt.Blur()
+		return m, nil
+	case "enter":
+		m.prompting = false
+		m.prompt.Blur()
+		query := strings.TrimSpace(m.prompt.Value())
+		if query == "" {
+			return m.clearSearch()
+		}
+		m.status = fmt.Sprintf("searching for %q…", query)
+		return m, m.search(query)
+	}
+	var cmd tea.Cmd
+	m.prompt, cmd = m.prompt.Update(msg)
+	return m, cmd
+}
+
+// clearSearch puts the selected row's posts back in the posts pane
+func (m model) clearSearch() (tea.Model, tea.Cmd) {
+	m.query = ""
+	m.posts = nil
+	m.next = ""
+	m.postIdx = 0
+	m.status = fmt.Sprintf("%d feeds", len(m.feeds))
+	return m, m.loadSelected()
+}
+
+// move the selection (or scroll the reader) by delta rows
+func (m model) move(delta int) (tea.Model, tea.Cmd) {
+	switch m.focus {
+	case feedsPane:
+		prev := m.rowIdx
+		m.rowIdx = clamp(m.rowIdx+delta, 0, len(m.rows)-1)
+		if m.rowIdx != prev {
+			m.query = ""
+			m.posts = nil
+			m.next = ""
+			m.postIdx = 0
+			return m, m.loadSelected()
+		}
+	case postsPane:
+		m.postIdx = clamp(m.postIdx+delta, 0, len(m.posts)-1)
+		// Reaching the end of the list brings in the posts after it
+		if m.postIdx == len(m.posts)-1 {
+			return m, m.loadMore()
+		}
+	case readerPane:
+		if delta > 0 {
+			m.reader.ScrollDown(delta)
+		} else {
+			m.reader.ScrollUp(-delta)
+		}
+	}
+	return m, nil
+}
+
+// openPost shows the selected post in the reader and marks it read
+func (m model) openPost() (tea.Model, tea.Cmd) {
+	post, ok := m.selectedPost()
+	if !ok {
+		return m, nil
+	}
+	m.focus = readerPane
+	m.readingID = post.ID
+	m.resizeReader()
+	m.reader.GotoTop()
+	if post.Read && !post.UpdatedSinceRead {
+		return m, nil
+	}
+	// reading it again acknowledges the update, the reader already shows the note
+	m.posts[m.postIdx].UpdatedSinceRead = false
+	if !post.Read {
+		m.posts[m.postIdx].Read = true
+		m.adjustUnread(post.FeedID, false)
+	}
+	return m, m.setRead(post.ID, true)
+}
+
+// adjustUnread keeps the feed list's counts in step with a local read toggle,
+// including every category the feed is in and the ones above them
+func (m *model) adjustUnread(feedID int, nowUnread bool) {
+	adjust := func(n *int) {
+		if nowUnread {
+			*n++
+		} else if *n > 0 {
+			*n--
+		}
+	}
+	parents := make(map[int]int, len(m.categories))
+	for _, c := range m.categories {
+		parents[c.ID] = c.ParentID
+	}
+	affected := make(map[int]bool)
+	for i := range m.feeds {
+		if m.feeds[i].ID != feedID {
+			continue
+		}
+		adjust(&m.feeds[i].Unread)
+		for _, id := range m.feeds[i].CategoryIDs {
+			for ; id != 0 && !affected[id]; id = parents[id] {
+				affected[id] = true
+			}
+		}
+	}
+	for i := range m.categories {
+		if affected[m.categories[i].ID] {
+			adjust(&m.categories[i].Unread)
+		}
+	}
+}
+
+// treeRow is one line of the feed list: a category when feedIdx is -1,
+// otherwise the feed at that index in feeds, filed under categoryID
+type treeRow struct {
+	depth      int
+	categoryID int
+	feedIdx    int
+	feedID     int
+}
+
+// buildRows lays categories out as a tree with their feeds after any
+// subcategories, skipping what's inside collapsed ones. Feeds in no category
+// come last at the top level, so without categories the list is just the feeds.
+func (m *model) buildRows() {
+	children := make(map[int][]models.Category)
+	for _, c := range m.categories {
+		children[c.ParentID] = append(children[c.ParentID], c)
+	}
+	filed := make(map[int][]int)
+	for i, f := range m.feeds {
+		for _, id := range f.CategoryIDs {
+			filed[id] = append(filed[id], i)
+		}
+	}
+
+	m.rows = nil
+	var walk func(parent, depth int)
+	walk = func(parent, depth int) {
+		for _, c := range children[parent] {
+			m.rows = append(m.rows, treeRow{depth: depth, categoryID: c.ID, feedIdx: -1})
+			if m.collapsed[c.ID] {
+				continue
+			}
+			walk(c.ID, depth+1)
+			for _, i := range filed[c.ID] {
+				m.rows = append(m.rows, treeRow{depth: depth + 1, categoryID: c.ID, feedIdx: i, feedID: m.feeds[i].ID})
+			}
+		}
+	}
+	walk(0, 0)
+	for i, f := range m.feeds {
+		if len(f.CategoryIDs) == 0 {
+			m.rows = append(m.rows, treeRow{feedIdx: i, feedID: f.ID})
+		}
+	}
+	m.rowIdx = clamp(m.rowIdx, 0, len(m.rows)-1)
+}
+
+func (m model) selectedRow() (treeRow, bool) {
+	if m.rowIdx < 0 || m.rowIdx >= len(m.rows) {
+		return treeRow{feedIdx: -1}, false
+	}
+	return m.rows[m.rowIdx], true
+}
+
+// category looks up a loaded category by id
+func (m model) category(id int) (models.Category, bool) {
+	for _, c := range m.categories {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return models.Category{}, false
+}
+
+func (m model) selectedFeed() (models.Feed, bool) {
+	row, ok := m.selectedRow()
+	if !ok || row.feedIdx < 0 {
+		return models.Feed{}, false
+	}
+	return m.feeds[row.feedIdx], true
+}
+
+func (m model) selectedPost() (models.Post, bool) {
+	if m.postIdx < 0 || m.postIdx >= len(m.posts) {
+		return models.Post{}, false
+	}
+	return m.posts[m.postIdx], true
+}
+
+// readingPost is the post currently in the reader, if it is still loaded
+func (m model) readingPost() (models.Post, bool) {
+	for _, p := range m.posts {
+		if p.ID == m.readingID {
+			return p, true
+		}
+	}
+	return models.Post{}, false
+}
+
+func clamp(v, low, high int) int {
+	if high < low {
+		return low
+	}
+	return min(max(v, low), high)
+}
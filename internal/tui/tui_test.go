@@ -0,0 +1,300 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/pixel-87/warss/internal/models"
+	"github.com/pixel-87/warss/internal/storage"
+)
+
+// setupModel returns a sized model over a database holding one feed with three posts
+func setupModel(t *testing.T) (model, *storage.DB) {
+	t.Helper()
+	db, err := storage.NewDB(filepath.Join(t.TempDir(), "rss.db"))
+	if err != nil {
+		t.Fatalf("failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err := db.AddFeed("https://example.com/feed.xml", "Example"); err != nil {
+		t.Fatalf("failed to add feed: %v", err)
+	}
+	feeds, err := db.GetFeeds()
+	if err != nil {
+		t.Fatalf("GetFeeds() error = %v", err)
+	}
+	feed := feeds[0]
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, title := range []string{"Oldest", "Middle", "Newest"} {
+		at := start.Add(time.Duration(i) * time.Hour)
+		feed.Posts = append(feed.Posts, models.Post{
+			Title:       title,
+			Link:        "https://example.com/" + title,
+			Content:     "<p>Body of " + title + "</p>",
+			PublishedAt: at,
+			UpdatedAt:   at,
+		})
+	}
+	if _, err := db.SaveFeed(context.Background(), feed); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+
+	m := newModel(context.Background(), db)
+	next, _ := m.Update(tea.WindowSizeMsg{Width: 120, Height: 30})
+	m = run(t, next.(model), m.Init())
+	return m, db
+}
+
+// run feeds cmd's message (and any follow-up commands) back into the model
+func run(t *testing.T, m model, cmd tea.Cmd) model {
+	t.Helper()
+	for cmd != nil {
+		msg := cmd()
+		if msg == nil {
+			return m
+		}
+		var next tea.Model
+		next, cmd = m.Update(msg)
+		m = next.(model)
+	}
+	return m
+}
+
+func press(t *testing.T, m model, key string) model {
+	t.Helper()
+	var msg tea.KeyMsg
+	switch key {
+	case "enter":
+		msg = tea.KeyMsg{Type: tea.KeyEnter}
+	case "esc":
+		msg = tea.KeyMsg{Type: tea.KeyEsc}
+	default:
+		msg = tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+	}
+	next, cmd := m.Update(msg)
+	return run(t, next.(model), cmd)
+}
+
+func TestLoadsFeedsAndPosts(t *testing.T) {
+	m, _ := setupModel(t)
+
+	if len(m.feeds) != 1 || m.feeds[0].UnreadCount() != 3 {
+		t.Fatalf("feeds = %+v, want one feed with 3 unread", m.feeds)
+	}
+	if len(m.posts) != 3 || m.posts[0].Title != "Newest" {
+		t.Fatalf("posts = %+v, want 3 posts newest first", m.posts)
+	}
+
+	view := m.View()
+	for _, want := range []string{"Example (3)", "Newest", "Oldest"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("view is missing %q", want)
+		}
+	}
+}
+
+// TestFeedPostsPaged checks a long feed loads a page at a time, the next one
+// once the selection reaches the end of the list
+func TestFeedPostsPaged(t *testing.T) {
+	m, db := setupModel(t)
+
+	feed := m.feeds[0]
+	feed.Posts = nil
+	start := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	for i := range listLimit {
+		at := start.Add(time.Duration(i) * time.Minute)
+		feed.Posts = append(feed.Posts, models.Post{
+			Title:       fmt.Sprintf("Post %d", i),
+			Link:        fmt.Sprintf("https://example.com/%d", i),
+			PublishedAt: at,
+			UpdatedAt:   at,
+		})
+	}
+	if _, err := db.SaveFeed(context.Background(), feed); err != nil {
+		t.Fatalf("SaveFeed() error = %v", err)
+	}
+
+	m = run(t, m, m.loadSelected())
+	if len(m.posts) != listLimit || m.posts[0].Title != fmt.Sprintf("Post %d", listLimit-1) {
+		t.Fatalf("got %d posts starting with %q, want the newest %d", len(m.posts), m.posts[0].Title, listLimit)
+	}
+
+	m = press(t, m, "enter")
+	m = press(t, m, "G")
+	if len(m.posts) != listLimit+3 || m.posts[len(m.posts)-1].Title != "Oldest" {
+		t.Fatalf("after G got %d posts, want all %d down to the oldest", len(m.posts), listLimit+3)
+	}
+	if m.next != "" || m.postIdx != listLimit-1 {
+		t.Errorf("next = %q at %d with every post loaded, want empty and still at %d", m.next, m.postIdx, listLimit-1)
+	}
+
+	// A reload, like the one after a refresh, keeps what was scrolled to
+	m = run(t, m, m.loadSelected())
+	if len(m.posts) != listLimit+3 || m.postIdx != listLimit-1 {
+		t.Errorf("after reload got %d posts at %d, want %d at %d", len(m.posts), m.postIdx, listLimit+3, listLimit-1)
+	}
+}
+
+func TestOpenPostMarksRead(t *testing.T) {
+	m, db := setupModel(t)
+
+	m = press(t, m, "enter") // into the post list
+	m = press(t, m, "j")     // second post, "Middle"
+	m = press(t, m, "enter") // open it
+
+	if m.focus != readerPane {
+		t.Fatalf("focus = %v, want reader", m.focus)
+	}
+	if !strings.Contains(m.reader.View(), "Body of Middle") {
+		t.Errorf("reader = %q, want the Middle post", m.reader.View())
+	}
+	if m.feeds[0].UnreadCount() != 2 {
+		t.Errorf("feed unread = %d, want 2", m.feeds[0].UnreadCount())
+	}
+
+	counts, err := db.UnreadCounts(context.Background())
+	if err != nil {
+		t.Fatalf("UnreadCounts() error = %v", err)
+	}
+	if counts[m.feeds[0].ID] != 2 {
+		t.Errorf("stored unread = %d, want 2", counts[m.feeds[0].ID])
+	}
+
+	// Toggle it back to unread from the reader
+	m = press(t, m, "m")
+	if m.posts[1].Read {
+		t.Errorf("post should be unread after m")
+	}
+	if m.feeds[0].UnreadCount() != 3 {
+		t.Errorf("feed unread after m = %d, want 3", m.feeds[0].UnreadCount())
+	}
+}
+
+func TestMarkFeedRead(t *testing.T) {
+	m, _ := setupModel(t)
+
+	m = press(t, m, "A")
+	if m.feeds[0].UnreadCount() != 0 {
+		t.Errorf("feed unread after A = %d, want 0", m.feeds[0].UnreadCount())
+	}
+	for _, p := range m.posts {
+		if !p.Read {
+			t.Errorf("post %q still unread", p.Title)
+		}
+	}
+}
+
+func TestSearchPrompt(t *testing.T) {
+	m, db := setupModel(t)
+
+	m = press(t, m, "/")
+	if !m.prompting {
+		t.Fatal("/ didn't open the search prompt")
+	}
+	m = press(t, m, "middle")
+	m = press(t, m, "enter")
+	if m.prompting {
+		t.Error("prompt still open after enter")
+	}
+	if _, err := db.Search(context.Background(), "middle", storage.SearchFilters{}); errors.Is(err, storage.ErrSearchUnavailable) {
+		if !strings.Contains(m.status, "sqlite_fts5") {
+			t.Errorf("status = %q, want the search unavailable error", m.status)
+		}
+		return
+	}
+
+	if m.query != "middle" || len(m.posts) != 1 || m.posts[0].Title != "Middle" {
+		t.Fatalf("search results = %q %+v, want the Middle post", m.query, m.posts)
+	}
+	if m.focus != postsPane {
+		t.Errorf("focus = %v, want the posts pane", m.focus)
+	}
+
+	// esc goes back to the feed's posts
+	m = press(t, m, "esc")
+	if m.query != "" || len(m.posts) != 3 {
+		t.Errorf("after esc query = %q with %d posts, want the feed's 3 posts", m.query, len(m.posts))
+	}
+}
+
+func TestStarPost(t *testing.T) {
+	m, db := setupModel(t)
+
+	m = press(t, m, "enter") // into the post list
+	m = press(t, m, "s")
+	if !m.posts[0].Starred() {
+		t.Fatal("post not starred after s")
+	}
+	if !strings.Contains(m.View(), "★ ") {
+		t.Error("view doesn't mark the starred post")
+	}
+	posts, _, err := db.StarredPosts(context.Background(), 10, "")
+	if err != nil || len(posts) != 1 || posts[0].ID != m.posts[0].ID {
+		t.Fatalf("stored starred posts = %+v, %v, want the selected post", posts, err)
+	}
+
+	m = press(t, m, "s")
+	if m.posts[0].Starred() {
+		t.Error("post still starred after a second s")
+	}
+}
+
+func TestCategoryTree(t *testing.T) {
+	m, db := setupModel(t)
+	ctx := context.Background()
+
+	news, err := db.AddCategory(ctx, "News", 0)
+	if err != nil {
+		t.Fatalf("AddCategory() error = %v", err)
+	}
+	if err := db.AddFeedToCategory(ctx, m.feeds[0].ID, news); err != nil {
+		t.Fatalf("AddFeedToCategory() error = %v", err)
+	}
+	m = run(t, m, m.loadFeeds())
+
+	if len(m.rows) != 2 || m.rows[0].categoryID != news || m.rows[1].feedIdx != 0 {
+		t.Fatalf("rows = %+v, want the category then its feed", m.rows)
+	}
+	if !strings.Contains(m.View(), "▾ News (3)") {
+		t.Error("view is missing the expanded category with its unread count")
+	}
+	// The category row lists the posts of every feed in it
+	if len(m.posts) != 3 {
+		t.Errorf("category posts = %+v, want its feed's 3 posts", m.posts)
+	}
+
+	// Reading a post counts down the category too
+	m = press(t, m, "j")
+	if len(m.posts) != 3 {
+		t.Fatalf("posts = %+v, want the feed's 3 posts", m.posts)
+	}
+	m = press(t, m, "enter")
+	m = press(t, m, "enter")
+	if c, _ := m.category(news); c.Unread != 2 {
+		t.Errorf("category unread after reading = %d, want 2", c.Unread)
+	}
+
+	// Folding the category hides its feed
+	m = press(t, m, "h")
+	m = press(t, m, "h")
+	m = press(t, m, "k")
+	m = press(t, m, "enter")
+	if len(m.rows) != 1 || m.focus != feedsPane {
+		t.Fatalf("after folding rows = %+v focus = %d, want just the category", m.rows, m.focus)
+	}
+	if !strings.Contains(m.View(), "▸ News (2)") {
+		t.Error("view is missing the folded category")
+	}
+	m = press(t, m, "enter")
+	if len(m.rows) != 2 {
+		t.Errorf("after unfolding rows = %+v, want the feed back", m.rows)
+	}
+}
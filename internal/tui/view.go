@@ -0,0 +1,192 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/pixel-87/warss/internal/models"
+	"github.com/pixel-87/warss/internal/render"
+)
+
+var (
+	paneStyle = lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("8"))
+	focusedPaneStyle = paneStyle.BorderForeground(lipgloss.Color("12"))
+	selectedStyle    = lipgloss.NewStyle().Reverse(true)
+	unreadStyle      = lipgloss.NewStyle().Bold(true)
+	dimStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	titleStyle       = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("12"))
+	statusStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+)
+
+const helpLine = "j/k move • enter open/fold • tab/h/l panes • / search • m toggle read • s star • A feed read • r refresh • o browser • q quit"
+
+// paneWidths splits the screen between the three panes, borders included
+func (m model) paneWidths() (feeds, posts, reader int) {
+	feeds = max(m.width/5, 20)
+	posts = max(m.width*3/10, 24)
+	reader = max(m.width-feeds-posts, 20)
+	return feeds, posts, reader
+}
+
+// listHeight is how many rows fit inside a pane
+func (m model) listHeight() int {
+	// two border rows and two status rows
+	return max(m.height-4, 1)
+}
+
+func (m *model) resizeReader() {
+	_, _, w := m.paneWidths()
+	m.reader.Width = max(w-2, 1)
+	m.reader.Height = m.listHeight()
+	if post, ok := m.readingPost(); ok {
+		m.reader.SetContent(renderPost(post, m.reader.Width))
+	} else {
+		m.reader.SetContent("")
+	}
+}
+
+func (m model) View() string {
+	if m.width == 0 {
+		return "loading…"
+	}
+
+	fw, pw, rw := m.paneWidths()
+	h := m.listHeight()
+
+	feedRows := make([]string, len(m.rows))
+	for i, r := range m.rows {
+		var (
+			title  string
+			unread int
+		)
+		if r.feedIdx < 0 {
+			c, _ := m.category(r.categoryID)
+			title, unread = "▾ "+c.Name, c.Unread
+			if m.collapsed[c.ID] {
+				title = "▸ " + c.Name
+			}
+		} else {
+			f := m.feeds[r.feedIdx]
+			title, unread = f.Title, f.UnreadCount()
+			if title == "" {
+				title = f.URL
+			}
+			// the last fetch failed, warss health says why
+			if f.Health.Failing() {
+				title = "✗ " + title
+			}
+		}
+		row := title
+		if unread > 0 {
+			row = unreadStyle.Render(fmt.Sprintf("%s (%d)", title, unread))
+		}
+		feedRows[i] = strings.Repeat("  ", r.depth) + row
+	}
+
+	postRows := make([]string, len(m.posts))
+	for i, p := range m.posts {
+		marker := "  "
+		title := p.Title
+		switch {
+		case !p.Read:
+			marker = "• "
+			title = unreadStyle.Render(title)
+		case p.UpdatedSinceRead:
+			// read, but the feed has edited it since
+			marker = "✎ "
+		}
+		if p.Starred() {
+			title = "★ " + title
+		}
+		date := ""
+		if !p.PublishedAt.IsZero() {
+			date = dimStyle.Render(p.PublishedAt.Format(" 2006-01-02"))
+		}
+		postRows[i] = marker + title + date
+	}
+
+	panes := lipgloss.JoinHorizontal(lipgloss.Top,
+		m.paneStyle(feedsPane).Width(fw-2).Height(h).Render(list(feedRows, m.rowIdx, h, fw-2, m.focus == feedsPane)),
+		m.paneStyle(postsPane).Width(pw-2).Height(h).Render(list(postRows, m.postIdx, h, pw-2, m.focus == postsPane)),
+		m.paneStyle(readerPane).Width(rw-2).Height(h).Render(m.reader.View()),
+	)
+
+	status := statusStyle.Render(truncate(m.status, m.width))
+	if m.prompting {
+		status = truncate(m.prompt.View(), m.width)
+	}
+	help := statusStyle.Render(truncate(helpLine, m.width))
+	return lipgloss.JoinVertical(lipgloss.Left, panes, status, help)
+}
+
+func (m model) paneStyle(p pane) lipgloss.Style {
+	if m.focus == p {
+		return focusedPaneStyle
+	}
+	return paneStyle
+}
+
+// list renders the rows that fit in height, scrolled so selected is visible
+func list(rows []string, selected, height, width int, focused bool) string {
+	start := 0
+	if selected >= height {
+		start = selected - height + 1
+	}
+	end := min(start+height, len(rows))
+
+	var b strings.Builder
+	for i := start; i < end; i++ {
+		row := truncate(rows[i], width)
+		if i == selected && focused {
+			row = selectedStyle.Render(lipgloss.NewStyle().Width(width).Render(row))
+		}
+		b.WriteString(row)
+		if i < end-1 {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// truncate cuts s to width cells, styles included
+func truncate(s string, width int) string {
+	return lipgloss.NewStyle().MaxWidth(width).Render(s)
+}
+
+// renderPost lays out a post for the reader pane
+func renderPost(p models.Post, width int) string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render(p.Title))
+	b.WriteByte('\n')
+	if p.Link != "" {
+		b.WriteString(dimStyle.Render(p.Link))
+		b.WriteByte('\n')
+	}
+	if byline := p.Byline(); byline != "" {
+		b.WriteString(dimStyle.Render("by " + byline))
+		b.WriteByte('\n')
+	}
+	if !p.PublishedAt.IsZero() {
+		b.WriteString(dimStyle.Render(p.PublishedAt.Format("Mon, 02 Jan 2006 15:04")))
+		b.WriteByte('\n')
+	}
+	if len(p.Tags) > 0 {
+		b.WriteString(dimStyle.Render("tags: " + strings.Join(p.Tags, ", ")))
+		b.WriteByte('\n')
+	}
+	if p.CommentsURL != "" {
+		b.WriteString(dimStyle.Render("comments: " + p.CommentsURL))
+		b.WriteByte('\n')
+	}
+	if p.UpdatedSinceRead {
+		b.WriteString(dimStyle.Render(fmt.Sprintf("updated since you read it, see warss diff %d", p.ID)))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	b.WriteString(render.HTML(p.Content, render.Options{Width: width, Color: true, BaseURL: p.Link}))
+	return b.String()
+}
@@ -18,6 +18,11 @@ buildGo126Module {
 
   vendorHash = null;
 
+  # full-text search (warss search) needs SQLite's FTS5, the tags are passed
+  # to go test in checkPhase too so the search tests run rather than skip
+  tags = [ "sqlite_fts5" ];
+  doCheck = true;
+
   ldflags = [
     "-s"
     "-w"
@@ -22,4 +22,7 @@ mkShell {
     goreleaser
     golangci-lint
   ];
+
+  # so plain go build and go test get full-text search like the package does
+  GOFLAGS = "-tags=sqlite_fts5";
 }